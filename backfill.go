@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	// backfillPageSize is the number of Blobrefs listed at once.
+	backfillPageSize = 100
+
+	// backfillTimeLimit bounds the time spent per backfill request, leaving
+	// enough of the request deadline to record the progress.
+	backfillTimeLimit = 30 * time.Second
+)
+
+// backfillState records the progress of backfillBlobrefs across requests.
+type backfillState struct {
+	// Cursor is where the next request continues, see blobrefStore.List.
+	Cursor string `datastore:",noindex"`
+	// Done is set once all Blobrefs were processed. Logs uploaded since carry
+	// their hash already.
+	Done bool
+}
+
+// contentHash returns the hex-encoded SHA-256 hash of |data|, see
+// Blobref.SHA256.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// backfillBlobrefsHandler is run by cron (see cron.yaml) to record hashes and
+// formats of logs uploaded before they were stored in the Blobref.
+func backfillBlobrefsHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	// App Engine removes the X-Appengine-Cron header from external requests.
+	if r.Header.Get("X-Appengine-Cron") != "true" && !requireAdmin(ctx, w, r) {
+		return
+	}
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
+
+	key := datastore.NewKey(ctx, "backfill", "blobrefs", 0, nil)
+	var state backfillState
+	if err := datastore.Get(ctx, key, &state); err != nil && err != datastore.ErrNoSuchEntity {
+		http.Error(w, err.Error(), errorStatus(ctx, err))
+		return
+	}
+	if state.Done {
+		fmt.Fprintln(w, "Backfill complete.")
+		return
+	}
+	updated, err := backfillBlobrefs(ctx, &state, time.Now().Add(backfillTimeLimit))
+	if err != nil {
+		errorf(ctx, "backfillBlobrefs: %v", err)
+	}
+	// Record the progress made before a failure, too.
+	if _, err := datastore.Put(ctx, key, &state); err != nil {
+		http.Error(w, err.Error(), errorStatus(ctx, err))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(ctx, err))
+		return
+	}
+	fmt.Fprintf(w, "Backfilled %d logs, done: %v\n", updated, state.Done)
+}
+
+// backfillBlobrefs records the hash and the sniffed format of Blobrefs which
+// lack a hash, starting at state.Cursor and stopping after |deadline|. It
+// returns the number of updated Blobrefs. Already hashed Blobrefs are skipped,
+// so processing a page again is harmless.
+func backfillBlobrefs(ctx context.Context, state *backfillState, deadline time.Time) (int, error) {
+	updated := 0
+	for {
+		ids, refs, next, err := blobrefs.List(ctx, state.Cursor, backfillPageSize)
+		if err != nil {
+			return updated, err
+		}
+		for i, b := range refs {
+			if time.Now().After(deadline) {
+				// Continue with this page next time.
+				return updated, nil
+			}
+			// Legacy blobstore logs (see serveLog) cannot be read.
+			if b.SHA256 != "" || b.Filename == "" {
+				continue
+			}
+			if err := backfillBlobref(ctx, b); err != nil {
+				if ctx.Err() != nil {
+					return updated, err
+				}
+				// Do not let a single broken log stall the backfill.
+				errorf(ctx, "backfilling log %d: %v", ids[i], err)
+				continue
+			}
+			if err := blobrefs.Update(ctx, ids[i], b); err != nil {
+				return updated, err
+			}
+			updated++
+		}
+		if next == "" {
+			state.Cursor, state.Done = "", true
+			return updated, nil
+		}
+		state.Cursor = next
+	}
+}
+
+// backfillBlobref reads the log described by |b| and sets b.SHA256 and, if it
+// can be sniffed, b.Format.
+func backfillBlobref(ctx context.Context, b *Blobref) error {
+	rc, err := objects.NewReader(ctx, bucket, b.Filename)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	br := bufio.NewReader(rc)
+	// Peek fails for objects shorter than the buffer, which sniffFormat
+	// handles.
+	head, _ := br.Peek(16)
+	format, ok := sniffFormat(head)
+	h := sha256.New()
+	if _, err := io.Copy(h, br); err != nil {
+		return err
+	}
+	b.SHA256 = hex.EncodeToString(h.Sum(nil))
+	if ok {
+		b.Format = format.name
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// blobrefStore stores the Blobref entities which describe uploaded logs. Tests
+// replace blobrefs with an in-memory implementation.
+type blobrefStore interface {
+	// Get returns the Blobref with the specified datastore ID.
+	Get(ctx context.Context, id int64) (*Blobref, error)
+	// GetBySlug returns the Blobref with the specified slug and its datastore
+	// ID.
+	GetBySlug(ctx context.Context, slug string) (int64, *Blobref, error)
+	// Put stores a new Blobref and returns its datastore ID.
+	Put(ctx context.Context, b *Blobref) (int64, error)
+	// Update replaces the Blobref with the specified datastore ID.
+	Update(ctx context.Context, id int64, b *Blobref) error
+	// List returns up to |limit| Blobrefs and their datastore IDs, starting
+	// at |cursor| (empty for the first page). The returned cursor is where
+	// the next page starts, or empty after the last page.
+	List(ctx context.Context, cursor string, limit int) ([]int64, []*Blobref, string, error)
+	// Delete deletes the Blobref with the specified datastore ID and records
+	// its log identifiers (see logID) as deleted.
+	Delete(ctx context.Context, id int64, b *Blobref) error
+	// Deleted returns whether the log identifier belongs to a deleted log.
+	Deleted(ctx context.Context, logid string) (bool, error)
+	// ReserveSlug atomically reserves |slug| for a new Blobref and returns
+	// false if it was reserved before.
+	ReserveSlug(ctx context.Context, slug string) (bool, error)
+}
+
+var blobrefs blobrefStore = datastoreBlobrefs{}
+
+// datastoreBlobrefs implements blobrefStore using the App Engine datastore.
+type datastoreBlobrefs struct{}
+
+func (datastoreBlobrefs) Get(ctx context.Context, id int64) (*Blobref, error) {
+	var b Blobref
+	if err := datastore.Get(ctx, datastore.NewKey(ctx, "blobref", "", id, nil), &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+func (datastoreBlobrefs) GetBySlug(ctx context.Context, slug string) (int64, *Blobref, error) {
+	var res []Blobref
+	q := datastore.NewQuery("blobref").Filter("Slug =", slug).Limit(1)
+	keys, err := q.GetAll(ctx, &res)
+	if err != nil {
+		return 0, nil, err
+	}
+	if len(res) == 0 {
+		return 0, nil, datastore.ErrNoSuchEntity
+	}
+	return keys[0].IntID(), &res[0], nil
+}
+
+func (datastoreBlobrefs) Put(ctx context.Context, b *Blobref) (int64, error) {
+	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "blobref", nil), b)
+	if err != nil {
+		return 0, err
+	}
+	return key.IntID(), nil
+}
+
+func (datastoreBlobrefs) Update(ctx context.Context, id int64, b *Blobref) error {
+	_, err := datastore.Put(ctx, datastore.NewKey(ctx, "blobref", "", id, nil), b)
+	return err
+}
+
+func (datastoreBlobrefs) List(ctx context.Context, cursor string, limit int) ([]int64, []*Blobref, string, error) {
+	q := datastore.NewQuery("blobref").Limit(limit)
+	if cursor != "" {
+		c, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return nil, nil, "", err
+		}
+		q = q.Start(c)
+	}
+	var (
+		ids  []int64
+		refs []*Blobref
+	)
+	t := q.Run(ctx)
+	for {
+		var b Blobref
+		key, err := t.Next(&b)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			return nil, nil, "", err
+		}
+		ids = append(ids, key.IntID())
+		refs = append(refs, &b)
+	}
+	if len(refs) < limit {
+		return ids, refs, "", nil
+	}
+	next, err := t.Cursor()
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return ids, refs, next.String(), nil
+}
+
+// deletedLog is a tombstone for a deleted log, keyed by log identifier, so
+// that requests for it are answered with 410 Gone instead of 404 Not Found.
+type deletedLog struct {
+	Deleted time.Time
+}
+
+// deletedLogIDs returns the identifiers under which the log was served: its
+// datastore ID and, if it has one, its slug.
+func deletedLogIDs(id int64, b *Blobref) []string {
+	ids := []string{strconv.FormatInt(id, 10)}
+	if b.Slug != "" {
+		ids = append(ids, b.Slug)
+	}
+	return ids
+}
+
+func (datastoreBlobrefs) Delete(ctx context.Context, id int64, b *Blobref) error {
+	var (
+		keys       []*datastore.Key
+		tombstones []deletedLog
+	)
+	for _, logid := range deletedLogIDs(id, b) {
+		keys = append(keys, datastore.NewKey(ctx, "deletedlog", logid, 0, nil))
+		tombstones = append(tombstones, deletedLog{Deleted: time.Now()})
+	}
+	if _, err := datastore.PutMulti(ctx, keys, tombstones); err != nil {
+		return err
+	}
+	return datastore.Delete(ctx, datastore.NewKey(ctx, "blobref", "", id, nil))
+}
+
+func (datastoreBlobrefs) Deleted(ctx context.Context, logid string) (bool, error) {
+	var d deletedLog
+	err := datastore.Get(ctx, datastore.NewKey(ctx, "deletedlog", logid, 0, nil), &d)
+	if err == datastore.ErrNoSuchEntity {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// slugReservation reserves a slug, keyed by slug, so that concurrent uploads
+// never get the same slug.
+type slugReservation struct {
+	Reserved time.Time
+}
+
+func (datastoreBlobrefs) ReserveSlug(ctx context.Context, slug string) (bool, error) {
+	reserved := false
+	err := datastore.RunInTransaction(ctx, func(ctx context.Context) error {
+		key := datastore.NewKey(ctx, "slug", slug, 0, nil)
+		var r slugReservation
+		if err := datastore.Get(ctx, key, &r); err != datastore.ErrNoSuchEntity {
+			reserved = false
+			return err // nil if the slug is reserved already
+		}
+		if _, err := datastore.Put(ctx, key, &slugReservation{Reserved: time.Now()}); err != nil {
+			return err
+		}
+		reserved = true
+		return nil
+	}, nil)
+	return reserved, err
+}
+
+// slugLength is the length of log slugs. Datastore IDs are usually longer,
+// and slugs always contain a letter (see isSlug), which tells them apart.
+const slugLength = 8
+
+var slugEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// isSlug returns whether the log identifier |logid| is a slug rather than a
+// datastore ID.
+func isSlug(logid string) bool {
+	return len(logid) == slugLength && strings.IndexFunc(logid, func(r rune) bool {
+		return r < '0' || r > '9'
+	}) != -1
+}
+
+// newSlug returns a random slug which is not yet used by any Blobref, and
+// reserves it.
+func newSlug(ctx context.Context) (string, error) {
+	for attempt := 0; attempt < 5; attempt++ {
+		b := make([]byte, slugLength*5/8)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		slug := slugEncoding.EncodeToString(b)
+		if !isSlug(slug) {
+			continue // could be mistaken for a datastore ID
+		}
+		// Slugs assigned before reservations were introduced are only
+		// found by querying.
+		if _, _, err := blobrefs.GetBySlug(ctx, slug); err == nil {
+			continue // collision, try again
+		} else if err != datastore.ErrNoSuchEntity {
+			return "", err
+		}
+		if ok, err := blobrefs.ReserveSlug(ctx, slug); err != nil {
+			return "", err
+		} else if ok {
+			return slug, nil
+		}
+	}
+	return "", fmt.Errorf("could not find an unused slug")
+}
+
+// logID returns the identifier under which the log is served, i.e. its slug
+// if it has one, its datastore ID otherwise.
+func logID(id int64, b *Blobref) string {
+	if b.Slug != "" {
+		return b.Slug
+	}
+	return strconv.FormatInt(id, 10)
+}
+
+// lookupBlobref returns the Blobref for a log identifier as returned by logID.
+func lookupBlobref(ctx context.Context, logid string) (*Blobref, error) {
+	_, b, err := lookupBlobrefID(ctx, logid)
+	return b, err
+}
+
+// lookupBlobrefID is like lookupBlobref, but also returns the datastore ID.
+func lookupBlobrefID(ctx context.Context, logid string) (int64, *Blobref, error) {
+	if isSlug(logid) {
+		return blobrefs.GetBySlug(ctx, strings.ToLower(logid))
+	}
+	intid, err := strconv.ParseInt(logid, 0, 64)
+	if err != nil {
+		return 0, nil, err
+	}
+	b, err := blobrefs.Get(ctx, intid)
+	return intid, b, err
+}
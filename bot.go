@@ -1,17 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"regexp"
+	"sort"
 	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/go-github/v47/github"
 	"google.golang.org/appengine"
@@ -24,10 +31,22 @@ import (
 type GitHubToken struct {
 	Token  string
 	Secret string
+	// SecretPrevious is also accepted as webhook secret while rotating the
+	// secret, so that deliveries signed with either secret are accepted
+	// until the new secret is configured on GitHub. Empty when not rotating.
+	SecretPrevious string
 }
 
 var githubToken GitHubToken
 
+// debugf, infof and errorf log using the App Engine log package, which panics
+// when not called with an App Engine context. Tests replace these.
+var (
+	debugf = log.Debugf
+	infof  = log.Infof
+	errorf = log.Errorf
+)
+
 const updateTokenForm = `
 <html>
 <body>
@@ -38,6 +57,9 @@ const updateTokenForm = `
 <label for="secret">Secret:</label>
 <input type="text" name="secret" id="secret" value="%s">
 
+<label for="secret_previous">Previous secret (during rotation):</label>
+<input type="text" name="secret_previous" id="secret_previous" value="%s">
+
 <input type="submit" value="Update token">
 </form>
 </body>
@@ -45,35 +67,209 @@ const updateTokenForm = `
 `
 
 var (
-	newConfigurationRegexp = regexp.MustCompile(`\[\s*x\s*\]\s*this\s*feature\s*requires\s*new\s*configuration`)
+	// devBuildRegexp matches version suffixes of development builds, e.g.
+	// “4.20.1-51-g9a4c6b4” or “4.21-non-git”. Development builds are also
+	// recognized by their branch, see isDevBuild.
+	devBuildRegexp = regexp.MustCompile(`[0-9]\.[0-9]+(?:\.[0-9]+)*-(?:[0-9]+-g[0-9a-f]+|non-git)\b`)
+
+	// fencedCodeRegexp matches Markdown fenced code blocks.
+	fencedCodeRegexp = regexp.MustCompile("(?ms)^[ \t]*(```|~~~)[^\n]*\n(.*?)^[ \t]*(?:```|~~~)")
+
+	// configLineRegexp matches i3 config directives, e.g. “bindsym $mod+x
+	// kill”.
+	configLineRegexp = regexp.MustCompile(`(?m)^[ \t]*(?:bindsym|bindcode|exec|exec_always|for_window|assign|no_focus|workspace|mode|bar|font|floating_modifier|default_border|hide_edge_borders|focus_follows_mouse|include|set[ \t]+\$[^ \t]+)[ \t]+\S`)
+
+	// issueReferenceRegexp matches references to other issues, such as
+	// “#123”, “i3/i3#123” or https://github.com/i3/i3/issues/123. Numbers
+	// starting with 0 or with six digits are most likely colors, e.g. #000000.
+	issueReferenceRegexp = regexp.MustCompile(`(?:^|[\s(])(?:[\w.-]+/[\w.-]+)?#[1-9][0-9]{0,4}\b|github\.com/[\w.-]+/[\w.-]+/(?:issues|pull)/[0-9]+`)
+)
+
+// minBodyLength is the length below which a bug report is considered empty,
+// e.g. when only the title was filled in.
+const minBodyLength = 20
+
+// regexpWindow returns the part of |text| which is matched against the
+// classification and version regexps: |text| itself, or, if it is longer than
+// MaxBodyBytes, its head and tail (split at character boundaries). Go’s
+// regexps run in linear time, so this bounds the time spent per event even
+// for pathological inputs.
+func (c *Config) regexpWindow(text string) string {
+	if c.MaxBodyBytes == 0 || len(text) <= c.MaxBodyBytes {
+		return text
+	}
+	head := c.MaxBodyBytes / 2
+	for head > 0 && !utf8.RuneStart(text[head]) {
+		head--
+	}
+	tail := len(text) - c.MaxBodyBytes/2
+	for tail < len(text) && !utf8.RuneStart(text[tail]) {
+		tail++
+	}
+	return text[:head] + "\n" + text[tail:]
+}
+
+// collapsibleTagRegexp matches the tags of collapsible sections, e.g.
+// “<details><summary>i3 --version</summary>…</details>”, in which reporters
+// hide long output.
+var collapsibleTagRegexp = regexp.MustCompile(`(?i)</?(?:details|summary)(?:\s[^>]*)?>`)
+
+// matchText returns |text| prepared for the classification, log link and
+// version regexps: its regexpWindow, with the tags of collapsible sections
+// replaced by line breaks (keeping their contents).
+func (c *Config) matchText(text string) string {
+	return collapsibleTagRegexp.ReplaceAllString(c.regexpWindow(text), "\n")
+}
 
-	documentationRegexp = regexp.MustCompile(`\[\s*x\s*\]\s*documentation\s*request`)
+const (
+	featureRequestComment = "Please note that new features which require additional configuration will usually not be considered. We are happy with the feature set of i3 and want to focus in fixing bugs instead. We do accept feature requests, however, and will evaluate whether the added benefit (clearly) outweighs the complexity it adds to i3.\n\nKeep in mind that i3 provides a powerful way to interact with it through its IPC interface: https://i3wm.org/docs/ipc.html."
+
+	missingLogComment = "I don’t see a link to logs.i3wm.org. " +
+		"Did you follow https://i3wm.org/docs/debugging.html? " +
+		"(In case you actually provided a link to a logfile, please ignore me.)"
+
+	// missingLogCommentShort is used instead of missingLogComment when the
+	// reporter refers to other issues, i.e. is probably familiar with our
+	// process already.
+	missingLogCommentShort = "I don’t see a link to logs.i3wm.org, " +
+		"please see https://i3wm.org/docs/debugging.html."
+
+	emptyIssueComment = "This issue does not contain a description. " +
+		"Please edit it and fill out the issue template: describe the problem, " +
+		"include the output of `i3 --version` and a debug log " +
+		"(see https://i3wm.org/docs/debugging.html)."
+
+	needsConfigComment = "I don’t see an i3 config in this issue. " +
+		"Please reduce your config to the minimum which still reproduces the problem " +
+		"(start from the default config and remove everything unrelated) " +
+		"and paste it into this issue as a code block."
+
+	// onboardingComment replaces the separate missing-log and missing-version
+	// comments if both are missing, see Config.OnboardingComment.
+	onboardingComment = "Thanks for reporting this! To look into it, we need two more things:\n\n" +
+		"1. The version of i3 you are using. Please copy & paste the output of `i3 --version` into this issue.\n" +
+		"2. A debug log. https://i3wm.org/docs/debugging.html explains how to enable debug logging " +
+		"and reproduce the problem. Then, upload the log using " +
+		"`bzip2 -c -9 ~/i3.log | curl --data-binary @- https://logs.i3wm.org` " +
+		"and paste the resulting link into this issue."
+
+	// triageSummaryComment introduces the checklist of missing information,
+	// see Config.TriageSummary.
+	triageSummaryComment = "Thanks for reporting this! To look into it, we need a few more things:\n\n"
+
+	// oldIssueComment is posted on comments to ancient issues, see
+	// Config.OldIssuesBefore.
+	oldIssueComment = "This issue was filed a long time ago, about a version of i3 which is no longer supported, " +
+		"and the code has changed a lot since. If you still see this problem with the latest release, " +
+		"please open a new issue (including the `i3 --version` output and a debug log) instead of commenting here."
+
+	missingVersionComment = "I don’t see a version number. " +
+		"Could you please copy & paste the output of `i3 --version` into this issue?"
+
+	truncatedVersionComment = "It looks like your version string got truncated — " +
+		"please paste the full `i3 --version` output."
+
+	dateOnlyVersionComment = "Your `i3 --version` output contains a build date, but no version number, " +
+		"which suggests that your i3 package was built incorrectly. Please report this to your " +
+		"distribution’s package maintainers and tell us the actual version of the package " +
+		"(e.g. as shown by your package manager)."
+
+	versionMismatchComment = "Your `i3 --moreversion` output shows that the i3 binary (%s) differs " +
+		"from the running i3 (%s), so the problem may have been observed with a different version. " +
+		"Please restart i3 (and, when building from source, run `make clean` before `make`), " +
+		"then check whether the problem persists."
+
+	moreversionWarningComment = "Your `i3 --moreversion` output warns that the running i3 differs from " +
+		"the i3 binary on disk, so the reported version may not be the one you are running. " +
+		"Please restart i3 (and, when building from source, run `make clean` before `make`), " +
+		"then check whether the problem persists and paste the new `i3 --moreversion` output."
+
+	developmentVersionComment = "Thanks for testing a development version of i3! As it may be newer " +
+		"than the latest release, we won’t ask you to upgrade. Please make sure the problem still " +
+		"occurs with the latest commit of the `next` branch."
 )
 
 func main() {
 	http.HandleFunc("/issues", issuesHandler)
 	http.HandleFunc("/issue_comment", issueCommentHandler)
+	http.HandleFunc("/pull_request", pullRequestHandler)
 	http.HandleFunc("/update_github_token", updateTokenHandler)
-	http.HandleFunc("/", logHandler)
+	http.HandleFunc("/update_config", updateConfigHandler)
+	http.HandleFunc("/config", configHandler)
+	http.HandleFunc("/bulk_label", bulkLabelHandler)
+	http.HandleFunc("/installation", installationHandler)
+	http.HandleFunc("/debug/verify-signature", verifySignatureHandler)
+	http.HandleFunc("/debug/token-scopes", tokenScopesHandler)
+	http.HandleFunc("/_ah/warmup", warmupHandler)
+	http.HandleFunc("/upload", logHandler)
+	http.HandleFunc("/", indexHandler)
+	http.HandleFunc("/healthz", healthzHandler)
 	http.HandleFunc("/logs/", logsHandler)
+	http.HandleFunc("/logs/immutable/", immutableLogsHandler)
+	http.HandleFunc("/logs/export", exportHandler)
+	http.HandleFunc("/logs/delete", deleteLogHandler)
+	http.HandleFunc("/logs/acl", logACLHandler)
+	http.HandleFunc("/cron/backfill-blobrefs", backfillBlobrefsHandler)
+	http.HandleFunc("/tasks/evaluate", evaluateTaskHandler)
+	http.HandleFunc("/tasks/autoclose", autoCloseTaskHandler)
+	http.HandleFunc("/tasks/reproduction", reproductionTaskHandler)
+	http.HandleFunc("/tasks/notify", notifyTaskHandler)
 	appengine.Main()
 }
 
-func updateTokenHandler(w http.ResponseWriter, r *http.Request) {
-	ctx := appengine.NewContext(r)
+// withRequestBudget returns a context derived from |ctx| which expires after
+// cfg.RequestBudgetSeconds.
+func withRequestBudget(ctx context.Context, cfg *Config) (context.Context, context.CancelFunc) {
+	if cfg.RequestBudgetSeconds <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, time.Duration(cfg.RequestBudgetSeconds)*time.Second)
+}
+
+// errorStatus returns the HTTP status code for a failed outbound operation:
+// 503 if the request budget was exhausted, 500 otherwise.
+func errorStatus(ctx context.Context, err error) int {
+	if ctx.Err() == context.DeadlineExceeded || errors.Is(err, context.DeadlineExceeded) {
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// adminEmail is the Google account of the bot administrator.
+const adminEmail = "michael@i3wm.org"
+
+// requireAdmin verifies that the request was made by a bot administrator,
+// redirecting to the login page if necessary. It returns false (having
+// written a response) otherwise.
+func requireAdmin(ctx context.Context, w http.ResponseWriter, r *http.Request) bool {
 	u := user.Current(ctx)
 	if u == nil {
-		url, err := user.LoginURL(ctx, "/update_github_token")
+		url, err := user.LoginURL(ctx, r.URL.Path)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+			return false
 		}
 		http.Redirect(w, r, url, http.StatusFound)
-		return
+		return false
 	}
 
-	if u.String() != "michael@i3wm.org" {
+	if u.String() != adminEmail {
 		http.Error(w, "Unauthorized", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// isAdmin returns whether the request was made by a bot administrator, see
+// requireAdmin. Tests replace it.
+var isAdmin = func(ctx context.Context) bool {
+	u := user.Current(ctx)
+	return u != nil && u.String() == adminEmail
+}
+
+func updateTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
 		return
 	}
 
@@ -85,42 +281,191 @@ func updateTokenHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == "POST" {
 		k := datastore.NewKey(ctx, "GitHubToken", "githubtoken", 0, nil)
 		t := GitHubToken{
-			Token:  r.FormValue("token"),
-			Secret: r.FormValue("secret"),
+			Token:          r.FormValue("token"),
+			Secret:         r.FormValue("secret"),
+			SecretPrevious: r.FormValue("secret_previous"),
 		}
 		if _, err := datastore.Put(ctx, k, &t); err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		githubToken = t
+		githubTokenLoaded = time.Now()
 	}
-	fmt.Fprintf(w, updateTokenForm, githubToken.Token, githubToken.Secret)
+	fmt.Fprintf(w, updateTokenForm, githubToken.Token, githubToken.Secret, githubToken.SecretPrevious)
+}
+
+// githubTokenMaxAge is how long githubToken is used before it is read from
+// datastore again, so that updates made on other instances are picked up.
+const githubTokenMaxAge = 10 * time.Minute
+
+// githubTokenLoaded is when githubToken was last read from datastore.
+var githubTokenLoaded time.Time
+
+// tokenRetryDelay is the delay before the first retry of a failed datastore
+// read in getGitHubToken. It doubles with every retry.
+var tokenRetryDelay = 50 * time.Millisecond
+
+// loadGitHubToken reads the GitHubToken from datastore. Tests replace it.
+var loadGitHubToken = func(ctx context.Context) (GitHubToken, error) {
+	var t GitHubToken
+	k := datastore.NewKey(ctx, "GitHubToken", "githubtoken", 0, nil)
+	err := datastore.Get(ctx, k, &t)
+	return t, err
 }
 
 func getGitHubToken(ctx context.Context) error {
-	if githubToken.Secret != "" && githubToken.Token != "" {
+	cached := githubToken.Secret != "" && githubToken.Token != ""
+	if cached && time.Since(githubTokenLoaded) < githubTokenMaxAge {
 		return nil
 	}
-	k := datastore.NewKey(ctx, "GitHubToken", "githubtoken", 0, nil)
-	return datastore.Get(ctx, k, &githubToken)
+
+	var err error
+	delay := tokenRetryDelay
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var t GitHubToken
+		if t, err = loadCredentials(ctx); err == nil {
+			githubToken = t
+			githubTokenLoaded = time.Now()
+			return nil
+		}
+		if err == datastore.ErrNoSuchEntity {
+			break // not transient, retrying will not help
+		}
+	}
+	if cached {
+		// Better to use a possibly stale token than to lose the webhook.
+		errorf(ctx, "Could not load GitHub token, using cached token: %v", err)
+		return nil
+	}
+	return err
 }
 
-type githubTransport urlfetch.Transport
+// githubTransport sets our User-Agent and authentication on requests to
+// GitHub, which it sends using base.
+type githubTransport struct {
+	base      http.RoundTripper
+	userAgent string
+	// authorization is the Authorization header value, e.g. for an
+	// installation token. When empty, githubToken is used.
+	authorization string
+}
+
+// newGitHubTransport returns a githubTransport which sends requests using
+// urlfetch, identifying as |cfg|’s UserAgentContact.
+func newGitHubTransport(ctx context.Context, cfg *Config) *githubTransport {
+	return &githubTransport{
+		base:      &urlfetch.Transport{Context: ctx},
+		userAgent: cfg.userAgent(),
+	}
+}
 
 func (g *githubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	req.Header.Set("User-Agent", "i3-github-bot (run by github.com/stapelberg)")
-	req.SetBasicAuth(githubToken.Token, "x-oauth-basic")
-	res, err := (*urlfetch.Transport)(g).RoundTrip(req)
+	req.Header.Set("User-Agent", g.userAgent)
+	if g.authorization != "" {
+		req.Header.Set("Authorization", g.authorization)
+	} else {
+		req.SetBasicAuth(githubToken.Token, "x-oauth-basic")
+	}
+	res, err := g.base.RoundTrip(req)
 	return res, err
 }
 
+// issueService is the subset of github.IssuesService used by the bot, so
+// that tests can substitute a fake.
+type issueService interface {
+	ListByRepo(ctx context.Context, owner string, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error)
+	Get(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error)
+	Edit(ctx context.Context, owner string, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error)
+	AddLabelsToIssue(ctx context.Context, owner string, repo string, number int, labels []string) ([]*github.Label, *github.Response, error)
+	RemoveLabelForIssue(ctx context.Context, owner string, repo string, number int, label string) (*github.Response, error)
+	CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	ListMilestones(ctx context.Context, owner string, repo string, opts *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error)
+	ListComments(ctx context.Context, owner string, repo string, number int, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+}
+
+// repositoryService is the subset of github.RepositoriesService used by the
+// bot.
+type repositoryService interface {
+	IsCollaborator(ctx context.Context, owner, repo, user string) (bool, *github.Response, error)
+	CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error)
+}
+
+// pullRequestService is the subset of github.PullRequestsService used by the
+// bot.
+type pullRequestService interface {
+	ListFiles(ctx context.Context, owner string, repo string, number int, opts *github.ListOptions) ([]*github.CommitFile, *github.Response, error)
+}
+
+// apiClient contains the GitHub API services used by the bot. In production,
+// these are the services of a github.Client (see newAPIClient).
+type apiClient struct {
+	Issues       issueService
+	Repositories repositoryService
+	PullRequests pullRequestService
+}
+
+// newGitHubClient returns a github.Client which authenticates using
+// githubToken, or using |authorization| (see githubTransport) if not empty.
+func newGitHubClient(ctx context.Context, authorization string) *github.Client {
+	transport := newGitHubTransport(ctx, configOrDefault(ctx))
+	transport.authorization = authorization
+	return github.NewClient(&http.Client{Transport: transport})
+}
+
+// newAPIClient returns an apiClient using newGitHubClient.
+func newAPIClient(ctx context.Context, authorization string) *apiClient {
+	githubclient := newGitHubClient(ctx, authorization)
+	return &apiClient{
+		Issues:       githubclient.Issues,
+		Repositories: githubclient.Repositories,
+		PullRequests: githubclient.PullRequests,
+	}
+}
+
+// clientFor returns the apiClient to use for repositories of |owner|. In
+// GitHub App mode, it authenticates as the installation recorded for |owner|
+// (see installationToken), otherwise with githubToken. Tests replace
+// clientFor.
+var clientFor = func(ctx context.Context, owner string) (*apiClient, error) {
+	if githubAppID == "" {
+		return newAPIClient(ctx, ""), nil
+	}
+	token, err := installationToken(ctx, owner)
+	if err != nil {
+		return nil, err
+	}
+	return newAPIClient(ctx, "token "+token), nil
+}
+
 func discardResponse(resp *github.Response) {
 	ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
 }
 
+// maxWebhookBodySize is the largest webhook payload we accept. GitHub caps
+// payloads at 25 MB, but issue events are orders of magnitude smaller.
+const maxWebhookBodySize = 5 << 20
+
+var errBodyTooLarge = fmt.Errorf("Request body exceeds %d bytes", maxWebhookBodySize)
+
+// verifyErrorStatus returns the HTTP status code for an error returned by
+// readAndVerifyBody.
+func verifyErrorStatus(err error) int {
+	if err == errBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
 // readAndVerifyBody verifies the HMAC signature to make sure this request was
-// sent by GitHub with the configured secret key.
+// sent by GitHub with the configured secret key. The HMAC-SHA256 signature in
+// X-Hub-Signature-256 is preferred, the HMAC-SHA1 signature in
+// X-Hub-Signature is accepted in its absence.
 func readAndVerifyBody(r *http.Request) ([]byte, string, error) {
 	ctx := appengine.NewContext(r)
 
@@ -129,33 +474,85 @@ func readAndVerifyBody(r *http.Request) ([]byte, string, error) {
 		return []byte{}, "", fmt.Errorf("X-GitHub-Event header missing")
 	}
 
-	signature := r.Header.Get("X-Hub-Signature")
+	header, prefix, hash := "X-Hub-Signature-256", "sha256=", sha256.New
+	signature := r.Header.Get(header)
 	if signature == "" {
-		return []byte{}, "", fmt.Errorf("X-Hub-Signature missing")
+		header, prefix, hash = "X-Hub-Signature", "sha1=", sha1.New
+		signature = r.Header.Get(header)
 	}
-	if !strings.HasPrefix(signature, "sha1=") {
-		return []byte{}, "", fmt.Errorf("X-Hub-Signature does not start with sha1=")
+	if signature == "" {
+		return []byte{}, "", fmt.Errorf("X-Hub-Signature-256 and X-Hub-Signature missing")
+	}
+	if !strings.HasPrefix(signature, prefix) {
+		return []byte{}, "", fmt.Errorf("%s does not start with %s", header, prefix)
 	}
-	want, err := hex.DecodeString(signature[len("sha1="):])
+	want, err := hex.DecodeString(signature[len(prefix):])
 	if err != nil {
-		return []byte{}, "", fmt.Errorf("Error decoding X-Hub-Signature: %v", err)
+		return []byte{}, "", fmt.Errorf("Error decoding %s: %v", header, err)
 	}
 
-	h := hmac.New(sha1.New, []byte(githubToken.Secret))
+	if r.ContentLength > maxWebhookBodySize {
+		return []byte{}, "", errBodyTooLarge
+	}
+	h := hmac.New(hash, []byte(githubToken.Secret))
+	hPrevious := hmac.New(hash, []byte(githubToken.SecretPrevious))
 	// Intentionally check the HMAC first, only then attempt to decode JSON.
-	body, err := ioutil.ReadAll(io.TeeReader(r.Body, h))
+	// Read one byte more than allowed to detect oversized bodies.
+	body, err := ioutil.ReadAll(io.TeeReader(io.LimitReader(r.Body, maxWebhookBodySize+1), io.MultiWriter(h, hPrevious)))
 	if err != nil {
 		return []byte{}, "", fmt.Errorf("Could not read body: %v", err)
 	}
+	if len(body) > maxWebhookBodySize {
+		return []byte{}, "", errBodyTooLarge
+	}
 	got := h.Sum(nil)
+	if githubToken.SecretPrevious != "" && hmac.Equal(want, hPrevious.Sum(nil)) {
+		infof(ctx, "%s matches the previous secret", header)
+		return body, event, nil
+	}
 	if !hmac.Equal(want, got) {
-		log.Errorf(ctx, "X-Hub-Signature: want %x, got %x", want, got)
-		return []byte{}, "", fmt.Errorf("X-Hub-Signature wrong")
+		errorf(ctx, "%s: want %x, got %x", header, want, got)
+		return []byte{}, "", fmt.Errorf("%s wrong", header)
 	}
 
 	return body, event, nil
 }
 
+// verifySignatureHandler lets administrators check that a webhook secret
+// matches the configured one(s) without waiting for GitHub to send an event:
+// the request body is verified like a webhook delivery, using the
+// X-Hub-Signature-256 or X-Hub-Signature header.
+func verifySignatureHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifySignature(w, r)
+}
+
+// verifySignature reports whether the body of |r| verifies against the
+// configured secret(s), see readAndVerifyBody.
+func verifySignature(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-GitHub-Event") == "" {
+		// Only the signature is of interest.
+		r.Header.Set("X-GitHub-Event", "ping")
+	}
+	body, _, err := readAndVerifyBody(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Signature does not verify: %v", err), verifyErrorStatus(err))
+		return
+	}
+	fmt.Fprintf(w, "Signature verifies (%d bytes).\n", len(body))
+}
+
 func getRepoAndIssue(payload interface{}) (*github.Repository, *github.Issue) {
 	switch v := payload.(type) {
 	case github.IssueCommentEvent:
@@ -165,40 +562,135 @@ func getRepoAndIssue(payload interface{}) (*github.Repository, *github.Issue) {
 	default:
 		panic("Unknown type passed as payload")
 	}
-	return nil, nil
 }
 
-func addLabel(ctx context.Context, client *github.Client, payload interface{}, w http.ResponseWriter, newLabel string) bool {
+// splitRepo splits a repository name such as “i3/i3” into owner and name.
+func splitRepo(fullName string) (owner, name string, ok bool) {
+	owner, name, ok = strings.Cut(fullName, "/")
+	if !ok || owner == "" || name == "" || strings.Contains(name, "/") {
+		return "", "", false
+	}
+	return owner, name, true
+}
+
+// logPlaceholderPatterns match template placeholders which mention
+// logs.i3wm.org but are not links to an uploaded log, such as HTML comments in
+// issue forms (“<!-- replace with https://logs.i3wm.org link -->”, invisible
+// when rendered) or example links with a placeholder ID.
+var logPlaceholderPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?s)<!--.*?-->`),
+	regexp.MustCompile(`(?i)https?://logs\.i3wm\.org/logs/(?:<[^>]*>|\{[^}]*\}|\[[^\]]*\]|x{3,}|\.{3}|…)`),
+}
+
+// hasLogLink returns whether |text| links to a log uploaded to logs.i3wm.org.
+// Placeholders (see logPlaceholderPatterns) do not count.
+func hasLogLink(text string) bool {
+	for _, re := range logPlaceholderPatterns {
+		text = re.ReplaceAllString(text, "")
+	}
+	return strings.Contains(strings.ToLower(text), "://logs.i3wm.org")
+}
+
+func hasLabel(issue *github.Issue, name string) bool {
+	for _, label := range issue.Labels {
+		if label.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addLabel adds |newLabel| to the issue, followed by the label’s comment (see
+// Config.LabelComments), if any. It returns false if the issue already had the
+// label or the label could not be added.
+func addLabel(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, newLabel string) bool {
 	repo, issue := getRepoAndIssue(payload)
 
 	// Avoid useless API requests.
 	for _, label := range issue.Labels {
-		if *label.Name == newLabel {
+		if label.GetName() == newLabel {
 			return false
 		}
 	}
 
 	_, resp, err := client.Issues.AddLabelsToIssue(
 		ctx,
-		*repo.Owner.Login,
-		*repo.Name,
-		*issue.Number,
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		issue.GetNumber(),
 		[]string{newLabel})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("AddLabelsToIssue: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("AddLabelsToIssue: %v", err), errorStatus(ctx, err))
 		return false
 	}
 	discardResponse(resp)
+	if tmpl := cfg.labelComments[newLabel]; tmpl != nil {
+		addLabelComment(ctx, client, cfg, payload, w, newLabel, tmpl)
+	}
 	return true
 }
 
-func deleteLabel(ctx context.Context, client *github.Client, payload interface{}, w http.ResponseWriter, oldLabel string) bool {
+// labelCommentData is passed to Config.LabelComments templates.
+type labelCommentData struct {
+	Label string
+	// Author is the login of the issue author.
+	Author string
+}
+
+// labelCommentMarker returns the invisible marker by which the comment for
+// |label| is recognized, so that it is posted only once even when the label
+// is removed and added again.
+func labelCommentMarker(label string) string {
+	return fmt.Sprintf("<!-- i3-github-bot label comment: %s -->", label)
+}
+
+// addLabelComment posts the comment for |label| unless the issue already has
+// it.
+func addLabelComment(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, label string, tmpl *template.Template) {
+	repo, issue := getRepoAndIssue(payload)
+	marker := labelCommentMarker(label)
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		comments, resp, err := client.Issues.ListComments(
+			ctx,
+			repo.GetOwner().GetLogin(),
+			repo.GetName(),
+			issue.GetNumber(),
+			opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ListComments: %v", err), errorStatus(ctx, err))
+			return
+		}
+		discardResponse(resp)
+		for _, comment := range comments {
+			if strings.Contains(comment.GetBody(), marker) {
+				return
+			}
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, labelCommentData{
+		Label:  label,
+		Author: issue.GetUser().GetLogin(),
+	}); err != nil {
+		errorf(ctx, "label comment for %q: %v", label, err)
+		return
+	}
+	addComment(ctx, client, cfg, payload, w, buf.String()+"\n\n"+marker)
+}
+
+func deleteLabel(ctx context.Context, client *apiClient, payload interface{}, w http.ResponseWriter, oldLabel string) bool {
 	repo, issue := getRepoAndIssue(payload)
 
 	// Avoid useless API requests.
 	found := false
 	for _, label := range issue.Labels {
-		if *label.Name == oldLabel {
+		if label.GetName() == oldLabel {
 			found = true
 			break
 		}
@@ -209,68 +701,129 @@ func deleteLabel(ctx context.Context, client *github.Client, payload interface{}
 
 	resp, err := client.Issues.RemoveLabelForIssue(
 		ctx,
-		*repo.Owner.Login,
-		*repo.Name,
-		*issue.Number,
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		issue.GetNumber(),
 		oldLabel)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("RemoveLabelForIssue: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("RemoveLabelForIssue: %v", err), errorStatus(ctx, err))
 		return false
 	}
 	discardResponse(resp)
 	return true
 }
 
-func addComment(ctx context.Context, client *github.Client, payload interface{}, w http.ResponseWriter, comment string) bool {
+// addComment posts |comment| on the issue, unless the bot recently posted a
+// similar comment (see Config.CommentDedupeLookback), in which case it returns
+// true without posting. It returns false if the comment could not be posted.
+func addComment(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, comment string) bool {
 	repo, issue := getRepoAndIssue(payload)
+	if cfg.CommentDedupeLookback > 0 && issue.GetComments() > 0 {
+		repeated, err := cfg.repeatsRecentComment(ctx, client, payload, comment)
+		if err != nil {
+			// Better to risk a repeated comment than to lose one.
+			errorf(ctx, "checking for similar comments: %v", err)
+		} else if repeated {
+			infof(ctx, "not posting comment %q: a similar comment was posted recently", comment)
+			return true
+		}
+	}
+	key := postedCommentKey(repo, issue, comment)
+	if first, err := postedComments.Record(ctx, key); err != nil {
+		// Better to risk a repeated comment than to lose one.
+		errorf(ctx, "recording comment: %v", err)
+	} else if !first {
+		infof(ctx, "not posting comment %q: it was posted before", comment)
+		return true
+	}
 	_, resp, err := client.Issues.CreateComment(
 		ctx,
-		*repo.Owner.Login,
-		*repo.Name,
-		*issue.Number,
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		issue.GetNumber(),
 		&github.IssueComment{
 			Body: github.String(comment),
 		})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("CreateComment: %v", err), http.StatusInternalServerError)
+		postedComments.Forget(ctx, key)
+		http.Error(w, fmt.Sprintf("CreateComment: %v", err), errorStatus(ctx, err))
 		return false
 	}
 	discardResponse(resp)
 	return true
 }
 
-func getCompletedMilestones(ctx context.Context, client *github.Client, payload interface{}, w http.ResponseWriter) []*github.Milestone {
+func getCompletedMilestones(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter) []*github.Milestone {
 	repo, _ := getRepoAndIssue(payload)
+	re := cfg.releasedMilestoneRegexp(repo)
+	opts := &github.MilestoneListOptions{
+		State:     "closed",
+		Sort:      "due_date",
+		Direction: "desc",
+	}
+	if re != nil {
+		// The title identifies releases, so consider all milestones.
+		opts = &github.MilestoneListOptions{
+			State:       "all",
+			ListOptions: github.ListOptions{PerPage: 100},
+		}
+	}
 	milestones, resp, err := client.Issues.ListMilestones(
 		ctx,
-		*repo.Owner.Login,
-		*repo.Name,
-		&github.MilestoneListOptions{
-			State:     "closed",
-			Sort:      "due_date",
-			Direction: "desc",
-		})
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		opts)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("ListMilestones: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("ListMilestones: %v", err), errorStatus(ctx, err))
 		return nil
 	}
 	discardResponse(resp)
-	return milestones
+	if re == nil {
+		return milestones
+	}
+	var released []*github.Milestone
+	for _, milestone := range milestones {
+		if re.MatchString(milestone.GetTitle()) {
+			released = append(released, milestone)
+		}
+	}
+	sort.SliceStable(released, func(i, j int) bool {
+		return compareVersions(released[i].GetTitle(), released[j].GetTitle()) > 0
+	})
+	return released
 }
 
-func closeIssue(ctx context.Context, client *github.Client, payload interface{}, w http.ResponseWriter) bool {
+// closeIssue closes the issue. reason is either “completed” or
+// “not_planned”.
+func closeIssue(ctx context.Context, client *apiClient, payload interface{}, w http.ResponseWriter, reason string) bool {
 	repo, issue := getRepoAndIssue(payload)
 	_, resp, err := client.Issues.Edit(
 		ctx,
-		*repo.Owner.Login,
-		*repo.Name,
-		*issue.Number,
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		issue.GetNumber(),
 		&github.IssueRequest{
 			State:       github.String("closed"),
-			StateReason: github.String("not_planned"),
+			StateReason: github.String(reason),
 		})
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Edit: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Edit: %v", err), errorStatus(ctx, err))
+		return false
+	}
+	discardResponse(resp)
+	return true
+}
+
+func reopenIssue(ctx context.Context, client *apiClient, payload interface{}, w http.ResponseWriter) bool {
+	repo, issue := getRepoAndIssue(payload)
+	_, resp, err := client.Issues.Edit(
+		ctx,
+		repo.GetOwner().GetLogin(),
+		repo.GetName(),
+		issue.GetNumber(),
+		&github.IssueRequest{State: github.String("open")})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Edit: %v", err), errorStatus(ctx, err))
 		return false
 	}
 	discardResponse(resp)
@@ -279,6 +832,9 @@ func closeIssue(ctx context.Context, client *github.Client, payload interface{},
 
 func issueCommentHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
 
 	if err := getGitHubToken(ctx); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -287,7 +843,7 @@ func issueCommentHandler(w http.ResponseWriter, r *http.Request) {
 
 	body, event, err := readAndVerifyBody(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), verifyErrorStatus(err))
 		return
 	}
 
@@ -295,6 +851,12 @@ func issueCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isInstallationEvent(event) {
+		// GitHub Apps send all events to the same URL.
+		handleInstallationWebhook(ctx, w, event, body)
+		return
+	}
+
 	if event != "issue_comment" {
 		http.Error(w, "Expected X-GitHub-Event: issue_comment", http.StatusBadRequest)
 		return
@@ -306,57 +868,161 @@ func issueCommentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Infof(ctx, "request: %+v", r)
-	log.Infof(ctx, "payload: %+v", payload)
+	infof(ctx, "request: %+v", r)
+	infof(ctx, "payload: %+v", payload)
 
-	// Wrap the urlfetch.Transport with our User-Agent and authentication.
-	transport := githubTransport(urlfetch.Transport{Context: ctx})
-	githubclient := github.NewClient(&http.Client{Transport: &transport})
+	client, err := clientFor(ctx, payload.GetRepo().GetOwner().GetLogin())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handleIssueCommentEvent(ctx, w, client, cfg, payload)
+}
+
+func handleIssueCommentEvent(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssueCommentEvent) {
+	if !cfg.handlesComments() {
+		infof(ctx, "ignoring issue_comment event: scope is %q", cfg.Scope)
+		return
+	}
+	if err := checkPayload(payload.GetRepo(), payload.GetIssue()); err != nil {
+		errorf(ctx, "ignoring issue_comment event: %v", err)
+		return
+	}
+	if payload.GetComment().GetUser().GetLogin() == "" {
+		errorf(ctx, "ignoring issue_comment event: comment author missing")
+		return
+	}
+
+	switch payload.GetAction() {
+	case "created":
+		runCommands(ctx, w, githubclient, cfg, payload)
+
+		if cfg.isOldIssue(payload.GetIssue(), payload.GetRepo().GetName()) {
+			markOldIssue(ctx, w, githubclient, cfg, payload)
+			return
+		}
+
+	case "edited":
+		// Commands and the old issue check apply to new comments only. The
+		// edited text is rechecked only if it actually changed, so that
+		// e.g. editing a typo does not act on text which was already
+		// considered.
+		from := payload.GetChanges().GetBody()
+		if from == nil || from.GetFrom() == payload.GetComment().GetBody() {
+			return
+		}
+
+	default:
+		// Deleted comments are ignored: labels cleared because of a comment
+		// stay cleared, as the information it provided was seen by
+		// maintainers (and may be repeated elsewhere).
+		return
+	}
 
 	// We only act in case the comment is by the issue creator.
-	if *payload.Issue.User.Login != *payload.Comment.User.Login {
+	if payload.GetIssue().GetUser().GetLogin() != payload.GetComment().GetUser().GetLogin() {
+		return
+	}
+
+	recheckVersionAndLog(ctx, w, githubclient, cfg, payload, payload.GetComment().GetBody())
+}
+
+// markOldIssue labels an ancient issue (see Config.OldIssuesBefore) which
+// received a comment as stale-old-version, unless the comment was written by a
+// maintainer.
+func markOldIssue(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssueCommentEvent) {
+	repo := payload.GetRepo()
+	login := payload.GetComment().GetUser().GetLogin()
+	collaborator, err := isCollaborator(ctx, githubclient, repo.GetOwner().GetLogin(), repo.GetName(), login)
+	if err != nil {
+		errorf(ctx, "IsCollaborator(%q): %v", login, err)
+		return
+	}
+	if collaborator {
 		return
 	}
+	if addLabel(ctx, githubclient, cfg, payload, w, "stale-old-version") {
+		addComment(ctx, githubclient, cfg, payload, w, oldIssueComment)
+	}
+}
+
+// checkPayload verifies that the fields needed to act on an issue are present
+// in a webhook payload. Other fields (e.g. the issue body) may be missing and
+// must be accessed using the nil-safe getters.
+func checkPayload(repo *github.Repository, issue *github.Issue) error {
+	switch {
+	case repo.GetOwner().GetLogin() == "" || repo.GetName() == "":
+		return fmt.Errorf("repository missing")
+	case issue == nil || issue.GetNumber() == 0:
+		return fmt.Errorf("issue missing")
+	}
+	return nil
+}
+
+// recheckVersionAndLog removes the missing-log, missing-version and
+// unsupported-version labels from the issue if |text| (e.g. a comment by the
+// reporter) provides a log link or a supported version. It returns false if
+// there was nothing to recheck.
+func recheckVersionAndLog(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssueCommentEvent, text string) bool {
+	// Feature and documentation requests need neither version nor log.
+	if cfg.classifyIssue(payload.Issue) != "bug" {
+		return false
+	}
+
+	text = cfg.matchText(text)
 
 	// See if any labels need to be removed.
 	currentLabels := make(map[string]bool)
-	for _, label := range payload.Issue.Labels {
-		currentLabels[*label.Name] = true
+	for _, label := range payload.GetIssue().Labels {
+		currentLabels[label.GetName()] = true
 	}
 	if !currentLabels["missing-version"] &&
+		!currentLabels["needs-full-version"] &&
 		!currentLabels["unsupported-version"] &&
+		!currentLabels["version-unverified"] &&
 		!currentLabels["missing-log"] {
-		return
+		return false
 	}
 
 	if currentLabels["missing-log"] {
-		if strings.Contains(*payload.Comment.Body, "://logs.i3wm.org") {
+		if hasLogLink(text) {
 			deleteLabel(ctx, githubclient, payload, w, "missing-log")
 		}
 	}
 
-	if currentLabels["missing-version"] || currentLabels["unsupported-version"] {
-		matches := extractVersion(*payload.Comment.Body)
+	if currentLabels["missing-version"] || currentLabels["needs-full-version"] ||
+		currentLabels["unsupported-version"] || currentLabels["version-unverified"] {
+		matches, decision := explainIssueVersion(text, payload.GetRepo().GetName())
+		debugf(ctx, "version: %v", decision)
 		if len(matches) == 0 {
-			return
+			return true
 		}
-		// TODO: point to the other repositories if payload.Repo.Name != matches[1]
-
-		log.Infof(ctx, "matches: %v", matches)
 
 		deleteLabel(ctx, githubclient, payload, w, "missing-version")
+		deleteLabel(ctx, githubclient, payload, w, "needs-full-version")
+
+		// Like evaluateIssue, only redirect if neither the issue nor |text|
+		// mention the repository’s programs at all.
+		programs := cfg.repoPrograms(payload.GetRepo())
+		if cfg.RedirectWrongRepository && !mentionsProgram(text, programs) &&
+			!mentionsProgram(cfg.matchText(payload.GetIssue().GetBody()), programs) {
+			if comment := cfg.redirectComment(payload.GetRepo(), matches[1]); comment != "" {
+				redirectIssue(ctx, githubclient, cfg, payload, w, comment)
+				return true
+			}
+		}
 
 		// We only verify the major version for i3 itself, not for i3status or
 		// i3lock (those bugs are not filed in the right repository anyway, but
 		// people still do that…).
-		if matches[1] != "i3" {
-			return
+		if !isI3Program(matches[1]) {
+			return true
 		}
 
 		// Verify the major version is recent enough to be supported.
-		milestones := getCompletedMilestones(ctx, githubclient, payload, w)
+		milestones := getCompletedMilestones(ctx, githubclient, cfg, payload, w)
 		if len(milestones) == 0 {
-			return
+			return true
 		}
 
 		majorVersion := matches[2]
@@ -364,24 +1030,29 @@ func issueCommentHandler(w http.ResponseWriter, r *http.Request) {
 			majorVersion = majorVersion[:len(majorVersion)-1]
 		}
 
-		if *milestones[0].Title != majorVersion {
-			if addLabel(ctx, githubclient, payload, w, "unsupported-version") {
-				addComment(ctx, githubclient, payload, w, fmt.Sprintf(
-					"Sorry, we can only support the latest major version. "+
-						"Please upgrade from %s to %s, verify the bug still exists, "+
-						"and re-open this issue.", majorVersion, *milestones[0].Title))
-				closeIssue(ctx, githubclient, payload, w)
-			}
-			return
+		latest := milestones[0].GetTitle()
+		accepted := cfg.acceptsVersion(payload.GetRepo(), matches)
+		devBuild := isDevBuild(text)
+		verifyMajorVersion(ctx, githubclient, cfg, payload, w, majorVersion, latest, devBuild, accepted)
+
+		// Reporters whose issue was closed because of an old version are
+		// asked to re-open it after upgrading, but cannot always do so.
+		supported := majorVersion == latest || devBuild || accepted
+		if supported && cfg.ReopenSupportedVersions &&
+			currentLabels["unsupported-version"] &&
+			payload.GetIssue().GetState() == "closed" &&
+			payload.GetComment().GetUser().GetLogin() == payload.GetIssue().GetUser().GetLogin() {
+			reopenIssue(ctx, githubclient, payload, w)
 		}
-
-		addLabel(ctx, githubclient, payload, w, *milestones[0].Title)
-		deleteLabel(ctx, githubclient, payload, w, "unsupported-version")
 	}
+	return true
 }
 
 func issuesHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
 
 	if err := getGitHubToken(ctx); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -390,7 +1061,7 @@ func issuesHandler(w http.ResponseWriter, r *http.Request) {
 
 	body, event, err := readAndVerifyBody(r)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		http.Error(w, err.Error(), verifyErrorStatus(err))
 		return
 	}
 
@@ -398,6 +1069,12 @@ func issuesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if isInstallationEvent(event) {
+		// GitHub Apps send all events to the same URL.
+		handleInstallationWebhook(ctx, w, event, body)
+		return
+	}
+
 	if event != "issues" {
 		http.Error(w, "Expected X-GitHub-Event: issues", http.StatusBadRequest)
 		return
@@ -409,68 +1086,346 @@ func issuesHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if *payload.Action != "opened" {
+	switch payload.GetAction() {
+	case "opened", "reopened", "transferred", "edited", "labeled", "unlabeled":
+	default:
+		return
+	}
+
+	infof(ctx, "request: %+v", r)
+	infof(ctx, "payload: %+v", payload)
+
+	client, err := clientFor(ctx, payload.GetRepo().GetOwner().GetLogin())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handleIssuesEvent(ctx, w, client, cfg, payload)
+}
+
+func handleIssuesEvent(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssuesEvent) {
+	if !cfg.handlesIssues() {
+		infof(ctx, "ignoring issues event: scope is %q", cfg.Scope)
+		return
+	}
+	if err := checkPayload(payload.GetRepo(), payload.GetIssue()); err != nil {
+		errorf(ctx, "ignoring issues event: %v", err)
 		return
 	}
 
-	log.Infof(ctx, "request: %+v", r)
-	log.Infof(ctx, "payload: %+v", payload)
+	if cfg.skipIssue(payload.GetIssue()) {
+		infof(ctx, "skipping excluded issue %q", payload.GetIssue().GetTitle())
+		return
+	}
 
-	// Wrap the urlfetch.Transport with our User-Agent and authentication.
-	transport := githubTransport(urlfetch.Transport{Context: ctx})
-	githubclient := github.NewClient(&http.Client{Transport: &transport})
+	optIn := cfg.optInLabel(payload.GetRepo())
+	if optIn != "" && !hasLabel(payload.GetIssue(), optIn) {
+		infof(ctx, "deferring %s event until issue #%d has the %q label",
+			payload.GetAction(), payload.GetIssue().GetNumber(), optIn)
+		return
+	}
 
-	lcBody := strings.ToLower(*payload.Issue.Body)
-	if hasEnhancementLabel(payload.Issue) {
-		if newConfigurationRegexp.MatchString(lcBody) {
-			addLabel(ctx, githubclient, payload, w, "requires-configuration")
+	switch payload.GetAction() {
+	case "opened":
+		// Issue forms can label bug reports right away.
+		scheduleReproductionCheck(ctx, cfg, payload)
+		for _, label := range cfg.formLabels(payload.GetRepo(), cfg.matchText(payload.GetIssue().GetBody())) {
+			addLabel(ctx, githubclient, cfg, payload, w, label)
+		}
+		if isFirstTimer(payload.GetIssue()) && inRepoList(cfg.GreetingRepos, payload.GetRepo()) &&
+			cfg.GreetingComment != "" {
+			addComment(ctx, githubclient, cfg, payload, w, cfg.GreetingComment)
 		}
+		if cfg.EvaluationDelaySeconds > 0 {
+			delay := time.Duration(cfg.EvaluationDelaySeconds) * time.Second
+			err := enqueueEvaluation(ctx, payload.GetRepo().GetOwner().GetLogin(), payload.GetRepo().GetName(),
+				payload.GetIssue().GetNumber(), delay)
+			if err == nil {
+				return
+			}
+			errorf(ctx, "enqueueEvaluation: %v (evaluating now)", err)
+		}
+		evaluateIssue(ctx, w, githubclient, cfg, payload)
+
+	case "transferred":
+		// The issue was moved from another repository, where it may have
+		// been out of scope for our checks. The reporter was possibly
+		// greeted there already.
+		evaluateIssue(ctx, w, githubclient, cfg, payload)
+
+	case "reopened":
+		// Issues which predate the current bot logic (or the current release)
+		// are better looked at by a human than closed again.
+		created := payload.GetIssue().GetCreatedAt()
+		if cfg.ReopenedMaxAgeMonths > 0 &&
+			created.Before(time.Now().AddDate(0, -cfg.ReopenedMaxAgeMonths, 0)) {
+			addLabel(ctx, githubclient, cfg, payload, w, "needs-manual-triage")
+			return
+		}
+		// Only bug reports are re-evaluated, there is no point in repeating
+		// the comments on feature or documentation requests.
+		if cfg.classifyIssue(payload.Issue) == "bug" {
+			evaluateIssue(ctx, w, githubclient, cfg, payload)
+		}
+
+	case "edited":
+		handleEditedBody(ctx, w, githubclient, cfg, payload)
 
-		addComment(ctx, githubclient, payload, w, "Please note that new features which require additional configuration will usually not be considered. We are happy with the feature set of i3 and want to focus in fixing bugs instead. We do accept feature requests, however, and will evaluate whether the added benefit (clearly) outweighs the complexity it adds to i3.\n\nKeep in mind that i3 provides a powerful way to interact with it through its IPC interface: https://i3wm.org/docs/ipc.html.")
+	case "labeled", "unlabeled":
+		if payload.GetAction() == "labeled" && payload.GetLabel().GetName() == bugLabel {
+			scheduleReproductionCheck(ctx, cfg, payload)
+		}
+		if optIn != "" && payload.GetAction() == "labeled" && payload.GetLabel().GetName() == optIn {
+			// A maintainer triaged the issue, so do what we deferred when
+			// it was opened.
+			evaluateIssue(ctx, w, githubclient, cfg, payload)
+			return
+		}
+		if cfg.SyncVersionLabels {
+			syncVersionLabels(ctx, w, githubclient, cfg, payload)
+		}
+	}
+}
 
+// handleEditedBody keeps the missing-log label consistent with the issue body
+// when reporters edit their issue (instead of commenting) to add or remove the
+// log link.
+func handleEditedBody(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssuesEvent) {
+	if payload.GetChanges().GetBody() == nil {
+		return // only the title was edited
+	}
+	issue := payload.GetIssue()
+	hadLog := hasLogLink(cfg.matchText(payload.GetChanges().GetBody().GetFrom()))
+	hasLog := hasLogLink(cfg.matchText(issue.GetBody()))
+	switch {
+	case hasLog && hasLabel(issue, "missing-log"):
+		deleteLabel(ctx, githubclient, payload, w, "missing-log")
+
+	case hadLog && !hasLog && cfg.ReaddMissingLog &&
+		issue.GetState() == "open" && cfg.classifyIssue(issue) == "bug":
+		addLabel(ctx, githubclient, cfg, payload, w, "missing-log")
+	}
+}
+
+// syncVersionLabels keeps the missing-version and unsupported-version labels
+// consistent with version labels (i.e. labels named after a completed
+// milestone, such as “4.23”) which maintainers add or remove manually.
+func syncVersionLabels(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssuesEvent) {
+	// Most labels are unrelated to versions, so check for version-like label
+	// names before listing the milestones.
+	if !bareVersionRegexp.MatchString(payload.GetLabel().GetName()) {
 		return
 	}
+	milestones := getCompletedMilestones(ctx, githubclient, cfg, payload, w)
+	isVersionLabel := func(name string) bool {
+		for _, milestone := range milestones {
+			if milestone.GetTitle() == name {
+				return true
+			}
+		}
+		return false
+	}
+	if !isVersionLabel(payload.GetLabel().GetName()) {
+		return
+	}
+
+	if payload.GetAction() == "labeled" {
+		deleteLabel(ctx, githubclient, payload, w, "missing-version")
+		deleteLabel(ctx, githubclient, payload, w, "unsupported-version")
+		return
+	}
+
+	// The version label was removed: if no other version label remains and
+	// the issue does not mention a version, the version is missing again.
+	for _, label := range payload.GetIssue().Labels {
+		if isVersionLabel(label.GetName()) {
+			return
+		}
+	}
+	if len(extractIssueVersion(cfg.matchText(payload.GetIssue().GetBody()), payload.GetRepo().GetName())) == 0 {
+		addLabel(ctx, githubclient, cfg, payload, w, "missing-version")
+	}
+}
+
+// isFirstTimer returns whether the author of |issue| never contributed to the
+// repository before, according to GitHub.
+func isFirstTimer(issue *github.Issue) bool {
+	switch issue.GetAuthorAssociation() {
+	case "FIRST_TIMER", "FIRST_TIME_CONTRIBUTOR":
+		return true
+	}
+	return false
+}
 
-	if documentationRegexp.MatchString(lcBody) {
+// evaluateIssue labels and comments on the issue based on its contents.
+func evaluateIssue(ctx context.Context, w http.ResponseWriter, githubclient *apiClient, cfg *Config, payload github.IssuesEvent) {
+	// The body is null (not the empty string) for issues without description.
+	body := payload.GetIssue().GetBody()
+	if cfg.MaxBodyBytes > 0 && len(body) > cfg.MaxBodyBytes {
+		infof(ctx, "issue body is %d bytes, only processing its first and last %d bytes", len(body), cfg.MaxBodyBytes/2)
+	}
+	body = cfg.matchText(body)
+	lcBody := strings.ToLower(body)
+	// If the reporter links related issues or prior discussion, they have
+	// likely seen our guidance already, so we keep the comments short.
+	referencesIssue := issueReferenceRegexp.MatchString(body)
+	kind := cfg.classifyIssue(payload.Issue)
+	if kind == "enhancement" {
+		if cfg.FeatureTriageLabel != "" {
+			addLabel(ctx, githubclient, cfg, payload, w, cfg.FeatureTriageLabel)
+		}
+
+		if cfg.classificationMatches("new_configuration", lcBody) {
+			if addLabel(ctx, githubclient, cfg, payload, w, "requires-configuration") &&
+				cfg.RequiresConfigurationComment != "" {
+				addComment(ctx, githubclient, cfg, payload, w, cfg.RequiresConfigurationComment)
+			}
+		}
+
+		if !referencesIssue {
+			addComment(ctx, githubclient, cfg, payload, w, featureRequestComment)
+		}
+
+		return
+	}
+
+	if kind == "documentation" {
 		// Same for documentation requests.
-		addLabel(ctx, githubclient, payload, w, "documentation")
+		addLabel(ctx, githubclient, cfg, payload, w, "documentation")
+		return
+	}
+
+	if len(strings.TrimSpace(body)) < minBodyLength {
+		// Asking for the version and log separately would be noise when
+		// the reporter has not described the problem at all.
+		if addLabel(ctx, githubclient, cfg, payload, w, "needs-info") {
+			addComment(ctx, githubclient, cfg, payload, w, emptyIssueComment)
+		}
+		return
+	}
+
+	if marker := cfg.componentMarker(payload.GetRepo(), body); marker != nil {
+		if addLabel(ctx, githubclient, cfg, payload, w, "wrong-component") {
+			addComment(ctx, githubclient, cfg, payload, w, marker.Comment)
+		}
+		return
+	}
+
+	if project := cfg.wrongProject(payload.GetRepo(), payload.GetIssue(), body); project != nil {
+		if addLabel(ctx, githubclient, cfg, payload, w, "wrong-project") {
+			addComment(ctx, githubclient, cfg, payload, w, project.Comment)
+		}
+		return
+	}
+
+	for _, rule := range cfg.triageRules(payload.GetRepo(), payload.GetIssue(), body) {
+		if addLabel(ctx, githubclient, cfg, payload, w, rule.Label) && rule.Comment != "" {
+			addComment(ctx, githubclient, cfg, payload, w, rule.Comment)
+		}
+	}
+
+	if inRepoList(cfg.DistroLabelRepos, payload.GetRepo()) {
+		if distro := extractDistro(body); distro != "" {
+			addLabel(ctx, githubclient, cfg, payload, w, "distro:"+distro)
+		}
+	}
+
+	found := &findings{summarize: cfg.TriageSummary}
+	defer found.flush(ctx, githubclient, cfg, payload, w)
+
+	if cfg.needsConfig(payload.GetRepo()) && !hasConfigBlock(body) {
+		if addLabel(ctx, githubclient, cfg, payload, w, "needs-config") {
+			found.report(ctx, githubclient, cfg, payload, w, needsConfigComment,
+				"Your i3 config, reduced to the minimum which reproduces the problem, as a code block.")
+		}
+	}
+
+	hasLog := hasLogLink(body)
+	if !hasLog && cfg.RemoteLogs {
+		hasLog = hasRemoteLog(ctx, cfg, body)
+	}
+	matches, decision := explainIssueVersion(body, payload.GetRepo().GetName())
+	debugf(ctx, "version: %v", decision)
+	if !hasLog && len(matches) == 0 && cfg.OnboardingComment {
+		// Walk the reporter through both steps in a single comment.
+		addedLog := addLabel(ctx, githubclient, cfg, payload, w, "missing-log")
+		addedVersion := addLabel(ctx, githubclient, cfg, payload, w, "missing-version")
+		if addedLog || addedVersion {
+			addComment(ctx, githubclient, cfg, payload, w, onboardingComment)
+		}
 		return
 	}
 
-	// TODO: be a bit smarter about this if it turns out that people use
-	// something else than logs.i3wm.org a lot. we could HEAD all URLs, then
-	// request just enough bytes to see if the file is a bzip2 file (and
-	// reasonably small), then download the rest, uncompress, and see whether
-	// it’s an i3 log
-	if !strings.Contains(lcBody, "://logs.i3wm.org") {
-		if addLabel(ctx, githubclient, payload, w, "missing-log") {
-			addComment(ctx, githubclient, payload, w, "I don’t see a link to logs.i3wm.org. "+
-				"Did you follow https://i3wm.org/docs/debugging.html? "+
-				"(In case you actually provided a link to a logfile, please ignore me.)")
+	if !hasLog {
+		if addLabel(ctx, githubclient, cfg, payload, w, "missing-log") {
+			comment := missingLogComment
+			if referencesIssue {
+				comment = missingLogCommentShort
+			}
+			found.report(ctx, githubclient, cfg, payload, w, comment,
+				"A link to a debug log uploaded to logs.i3wm.org, see https://i3wm.org/docs/debugging.html.")
 		}
 	}
 
-	matches := extractVersion(*payload.Issue.Body)
 	if len(matches) == 0 {
-		if addLabel(ctx, githubclient, payload, w, "missing-version") {
-			addComment(ctx, githubclient, payload, w, "I don’t see a version number. "+
-				"Could you please copy & paste the output of `i3 --version` into this issue?")
+		if reDateOnlyVersion.MatchString(body) {
+			if addLabel(ctx, githubclient, cfg, payload, w, "packaging-issue") {
+				found.report(ctx, githubclient, cfg, payload, w, dateOnlyVersionComment,
+					"The actual version of your i3 package (its `i3 --version` output lacks the version number).")
+			}
+			return
+		}
+		if reTruncatedVersion.MatchString(body) {
+			if addLabel(ctx, githubclient, cfg, payload, w, "needs-full-version") {
+				found.report(ctx, githubclient, cfg, payload, w, truncatedVersionComment,
+					"The full output of `i3 --version` (it seems to be truncated).")
+			}
+			return
+		}
+		if addLabel(ctx, githubclient, cfg, payload, w, "missing-version") {
+			found.report(ctx, githubclient, cfg, payload, w, missingVersionComment,
+				"The output of `i3 --version`.")
 		}
 		return
 	}
-	// TODO: point to the other repositories if payload.Repo.Name != matches[1]
+
+	// With mismatching versions, the reported version is unreliable, so it is
+	// not verified below (e.g. stale binaries must not get the issue closed).
+	mismatch := false
+	if binary, running, ok := versionMismatch(body); ok {
+		mismatch = true
+		if addLabel(ctx, githubclient, cfg, payload, w, "version-mismatch") {
+			found.report(ctx, githubclient, cfg, payload, w, fmt.Sprintf(versionMismatchComment, binary, running),
+				"Restart i3 so that the running version ("+running+") matches the binary ("+binary+").")
+		}
+	} else if moreversionWarning.MatchString(body) {
+		mismatch = true
+		if addLabel(ctx, githubclient, cfg, payload, w, "version-mismatch") {
+			found.report(ctx, githubclient, cfg, payload, w, moreversionWarningComment,
+				"Restart i3 so that the running i3 matches the i3 binary on disk.")
+		}
+	}
+
+	// Only redirect if the issue does not mention the repository’s programs
+	// at all (not even outside of the version field).
+	if cfg.RedirectWrongRepository && !mentionsProgram(body, cfg.repoPrograms(payload.GetRepo())) {
+		if comment := cfg.redirectComment(payload.GetRepo(), matches[1]); comment != "" {
+			redirectIssue(ctx, githubclient, cfg, payload, w, comment)
+			return
+		}
+	}
 
 	// We only verify the major version for i3 itself, not for i3status or
 	// i3lock (those bugs are not filed in the right repository anyway, but
 	// people still do that…).
-	if matches[1] != "i3" {
+	if !isI3Program(matches[1]) || mismatch {
 		return
 	}
 
 	// Verify the major version is recent enough to be supported.
-	milestones := getCompletedMilestones(ctx, githubclient, payload, w)
+	milestones := getCompletedMilestones(ctx, githubclient, cfg, payload, w)
 	if len(milestones) == 0 {
-		log.Errorf(ctx, "No milestones found")
+		errorf(ctx, "No milestones found")
 		return
 	}
 
@@ -479,17 +1434,222 @@ func issuesHandler(w http.ResponseWriter, r *http.Request) {
 		majorVersion = majorVersion[:len(majorVersion)-1]
 	}
 
-	if *milestones[0].Title != majorVersion {
-		if addLabel(ctx, githubclient, payload, w, "unsupported-version") {
-			addComment(ctx, githubclient, payload, w, fmt.Sprintf(
-				"Sorry, we can only support the latest major version. "+
-					"Please upgrade from %s to %s, verify the bug still exists, "+
-					"and re-open this issue.", majorVersion, *milestones[0].Title))
-			closeIssue(ctx, githubclient, payload, w)
+	latest := milestones[0].GetTitle()
+	verifyMajorVersion(ctx, githubclient, cfg, payload, w, majorVersion, latest, isDevBuild(body),
+		cfg.acceptsVersion(payload.GetRepo(), matches))
+}
+
+// findings collects the comments about what is missing from a bug report.
+// When summarizing (see Config.TriageSummary), they are posted as a single
+// checklist comment by flush instead of one comment each.
+type findings struct {
+	summarize bool
+	comments  []string
+	items     []string
+}
+
+// report posts |comment|, or records it along with its checklist |item| when
+// summarizing.
+func (f *findings) report(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, comment, item string) {
+	if !f.summarize {
+		addComment(ctx, client, cfg, payload, w, comment)
+		return
+	}
+	f.comments = append(f.comments, comment)
+	f.items = append(f.items, item)
+}
+
+// flush posts the recorded findings. A single finding is posted as its
+// regular comment, which is more detailed than the checklist item.
+func (f *findings) flush(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter) {
+	switch len(f.items) {
+	case 0:
+		return
+	case 1:
+		addComment(ctx, client, cfg, payload, w, f.comments[0])
+		return
+	}
+	var comment strings.Builder
+	comment.WriteString(triageSummaryComment)
+	for _, item := range f.items {
+		comment.WriteString("- [ ] " + item + "\n")
+	}
+	addComment(ctx, client, cfg, payload, w, comment.String())
+}
+
+// verifyMajorVersion compares the reported majorVersion against the latest
+// released version (the title of the most recently completed milestone) and
+// labels the issue accordingly. Issues reporting an older version are closed.
+// devBuild specifies whether the report indicates a development build (see
+// devBuildRegexp), accepted whether the version is supported although it is
+// older (see Config.acceptsVersion).
+func verifyMajorVersion(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, majorVersion, latest string, devBuild, accepted bool) {
+	if majorVersion == latest {
+		addLabel(ctx, client, cfg, payload, w, latest)
+		assignNextMilestone(ctx, client, cfg, payload, w)
+		deleteLabel(ctx, client, payload, w, "unsupported-version")
+		deleteLabel(ctx, client, payload, w, "version-unverified")
+		return
+	}
+
+	if accepted {
+		// Maintainers support this older version, e.g. because of a
+		// backported fix. It is not the latest release, so neither its
+		// label nor the next milestone apply.
+		deleteLabel(ctx, client, payload, w, "unsupported-version")
+		deleteLabel(ctx, client, payload, w, "version-unverified")
+		return
+	}
+
+	if devBuild {
+		// The reporter runs a development build, e.g. of the next branch,
+		// whose version number may well trail the latest release. Telling
+		// them to upgrade would be wrong.
+		if addLabel(ctx, client, cfg, payload, w, "development-version") {
+			addComment(ctx, client, cfg, payload, w, developmentVersionComment)
 		}
+		deleteLabel(ctx, client, payload, w, "unsupported-version")
+		deleteLabel(ctx, client, payload, w, "version-unverified")
 		return
 	}
-	addLabel(ctx, githubclient, payload, w, *milestones[0].Title)
+
+	if compareVersions(majorVersion, latest) > 0 {
+		// A release newer than the latest one does not exist, so this is
+		// likely a typo. Do not close the issue, it might well be valid.
+		if addLabel(ctx, client, cfg, payload, w, "version-unverified") {
+			addComment(ctx, client, cfg, payload, w, fmt.Sprintf(
+				"The latest release is %s, but you reported version %s. "+
+					"Could you please copy & paste the exact output of `i3 --version` into this issue?",
+				latest, majorVersion))
+		}
+		return
+	}
+
+	// Issues whose close was deferred already have the unsupported-version
+	// label, but still need to be closed.
+	_, issue := getRepoAndIssue(payload)
+	deferred := hasLabel(issue, autoCloseDeferredLabel)
+	if !addLabel(ctx, client, cfg, payload, w, "unsupported-version") && !deferred {
+		return
+	}
+	closeUnsupported(ctx, client, cfg, payload, w, majorVersion, latest)
+}
+
+// closeUnsupported closes the issue for reporting |majorVersion| instead of
+// |latest|, unless it has the KeepOpenLabel or MaxAutoClosesPerHour defers
+// closing it.
+func closeUnsupported(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, majorVersion, latest string) {
+	_, issue := getRepoAndIssue(payload)
+	if cfg.KeepOpenLabel != "" && hasLabel(issue, cfg.KeepOpenLabel) {
+		infof(ctx, "issue #%d has the %q label, not closing it", issue.GetNumber(), cfg.KeepOpenLabel)
+		return
+	}
+	window, ok := reserveAutoClose(ctx, cfg)
+	if !ok {
+		// Likely a new release made many issues outdated at once, so
+		// close the issue in a later window.
+		infof(ctx, "more than %d auto-closes this hour, deferring closing issue #%d", cfg.MaxAutoClosesPerHour, issue.GetNumber())
+		deferAutoClose(ctx, client, cfg, payload, w, window, majorVersion, latest)
+		return
+	}
+	// Close first: the comment asks the reporter to re-open the issue, which
+	// makes no sense on an issue which is still open.
+	if !closeIssue(ctx, client, payload, w, "not_planned") {
+		releaseAutoClose(ctx, cfg, window)
+		return
+	}
+	addComment(ctx, client, cfg, payload, w, fmt.Sprintf(
+		"Sorry, we can only support the latest major version. "+
+			"Please upgrade from %s to %s, verify the bug still exists, "+
+			"and re-open this issue.", majorVersion, latest))
+	notifyClosed(ctx, cfg, payload, majorVersion, latest)
+	deleteLabel(ctx, client, payload, w, autoCloseDeferredLabel)
+}
+
+// assignNextMilestone assigns the open milestone matching the repository’s
+// NextMilestonePatterns entry which is due first to the issue, unless it has a
+// milestone already.
+func assignNextMilestone(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter) {
+	repo, issue := getRepoAndIssue(payload)
+	re := cfg.nextMilestoneRegexp(repo)
+	if re == nil || issue.Milestone != nil {
+		return
+	}
+	milestones, resp, err := client.Issues.ListMilestones(ctx, repo.GetOwner().GetLogin(), repo.GetName(), &github.MilestoneListOptions{
+		State:       "open",
+		Sort:        "due_on",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("ListMilestones: %v", err), errorStatus(ctx, err))
+		return
+	}
+	discardResponse(resp)
+	for _, milestone := range milestones {
+		if !re.MatchString(milestone.GetTitle()) {
+			continue
+		}
+		_, resp, err := client.Issues.Edit(ctx, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), &github.IssueRequest{
+			Milestone: github.Int(milestone.GetNumber()),
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Edit: %v", err), errorStatus(ctx, err))
+			return
+		}
+		discardResponse(resp)
+		return
+	}
+	infof(ctx, "no open milestone matches %q", re)
+}
+
+// hasConfigBlock returns whether |body| contains an i3 config, i.e. a fenced
+// code block containing config directives.
+func hasConfigBlock(body string) bool {
+	for _, block := range fencedCodeRegexp.FindAllStringSubmatch(body, -1) {
+		if configLineRegexp.MatchString(block[2]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionsProgram returns whether |body| contains a version of one of
+// |programs|.
+func mentionsProgram(body string, programs []string) bool {
+	versions := extractVersions(body)
+	for _, program := range programs {
+		if _, ok := versions[program]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectIssue closes an issue which was filed in the wrong repository,
+// pointing the reporter to the right one using |comment|.
+func redirectIssue(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, comment string) {
+	if addComment(ctx, client, cfg, payload, w, comment) {
+		closeIssue(ctx, client, payload, w, "not_planned")
+	}
+}
+
+// classifyIssue returns “enhancement”, “documentation” or “bug”, based on the
+// issue’s labels and body, see Config.ClassificationPatterns.
+func (c *Config) classifyIssue(issue *github.Issue) string {
+	body := c.matchText(issue.GetBody())
+	lcBody := strings.ToLower(body)
+	formType := formIssueType(parseIssueForm(body))
+	if hasEnhancementLabel(issue) || formType == "enhancement" ||
+		c.classificationMatches("enhancement", lcBody) {
+		return "enhancement"
+	}
+	if hasLabel(issue, "documentation") ||
+		c.classificationMatches("documentation", lcBody) ||
+		formType == "documentation" {
+		return "documentation"
+	}
+	return "bug"
 }
 
 func hasEnhancementLabel(issue *github.Issue) bool {
@@ -1,8 +1,35 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine/datastore"
 )
 
 func TestVersion1640(t *testing.T) {
@@ -40,6 +67,92 @@ I guess this could lead to pretty strange situations with misleading data, if an
 	}
 }
 
+func TestMoreversionWarning(t *testing.T) {
+	t.Parallel()
+
+	const moreversion = `Binary i3 version:  4.18 © 2009 Michael Stapelberg and contributors
+Running i3 version: 4.18 (pid 1234)
+Loaded i3 config: /home/user/.config/i3/config (Last modified: Sat 01 Feb 2020 12:00:00 CET, 120 seconds ago)
+
+The i3 binary you just called: /usr/bin/i3
+RUNNING BINARY DIFFERENT FROM BINARY ON DISK: /usr/bin/i3 (deleted)
+`
+	for _, tt := range []struct {
+		name    string
+		body    string
+		warning bool
+		want    issueOutcome
+	}{
+		{
+			name:    "warning present",
+			body:    "i3 crashes, see " + logLink + "\n\n" + moreversion,
+			warning: true,
+			// The old version is not trusted, so the issue stays open.
+			want: issueOutcome{added: []string{"version-mismatch"}, comments: 1},
+		},
+		{
+			name: "warning absent",
+			body: "i3 crashes, see " + logLink + "\n\n" + strings.Replace(moreversion, "RUNNING BINARY DIFFERENT FROM BINARY ON DISK", "The i3 binary you are running", 1),
+			want: issueOutcome{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		},
+	} {
+		if got := moreversionWarning.MatchString(tt.body); got != tt.warning {
+			t.Fatalf("%s: moreversionWarning.MatchString = %v, want %v", tt.name, got, tt.warning)
+		}
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(tt.body))
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestVersionMismatch(t *testing.T) {
+	t.Parallel()
+
+	// Issue #1640 after rebuilding, but before restarting i3.
+	body := `I ran ` + "`make clean`" + ` before ` + "`make`" + `, but ` + "`i3 --moreversion`" + ` prints:
+
+    Binary i3 version:  4.10.1-6-geb04a64 (2015-04-06, branch "master") © 2009-2014 Michael Stapelberg and contributors
+    Running i3 version: 4.10.1 (2015-03-29, branch "4.10.1") (pid 1552)
+
+Here is my log: ` + logLink
+	binary, running, ok := versionMismatch(body)
+	if !ok || binary != "4.10.1-6-geb04a64" || running != "4.10.1" {
+		t.Fatalf("versionMismatch = %q, %q, %v, want 4.10.1-6-geb04a64, 4.10.1, true", binary, running, ok)
+	}
+
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.10")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(body))
+	// The version is not verified, so the issue does not get a version label.
+	want := issueOutcome{added: []string{"version-mismatch"}, comments: 1}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+	if got := fake.comments[1][0]; !strings.Contains(got, "`make clean`") {
+		t.Fatalf("unexpected comment %q", got)
+	}
+}
+
+func TestVersionMismatchMatching(t *testing.T) {
+	t.Parallel()
+
+	// Issue #1640 shows matching binary and running versions (the stale
+	// binaries reported the old version, too).
+	for _, body := range []string{
+		`    Binary i3 version:  4.10.1 (2015-03-29, branch "4.10.1") © 2009-2014 Michael Stapelberg and contributors
+    Running i3 version: 4.10.1 (2015-03-29, branch "4.10.1") (pid 1552)`,
+		`    Binary i3 version:  4.10.1-6-geb04a64 (2015-04-06, branch "master") © 2009-2014 Michael Stapelberg and contributors
+    Running i3 version: 4.10.1-6-geb04a64 (2015-04-06, branch "master") (pid 1552)`,
+	} {
+		if binary, running, ok := versionMismatch(body); ok {
+			t.Fatalf("unexpected version mismatch in %q: binary %q, running %q", body, binary, running)
+		}
+	}
+}
+
 func TestVersion1694(t *testing.T) {
 	body := `
 i3 >= 4.8 doesn't play nice with xfce4-panel (=4.10.1) anymore.
@@ -125,10 +238,4527 @@ func TestNewConfigurationMatch(t *testing.T) {
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
-			got := newConfigurationRegexp.MatchString(strings.ToLower(tt.body))
+			got := defaultConfig().classificationMatches("new_configuration", strings.ToLower(tt.body))
 			if got != tt.want {
 				t.Fatalf("unexpected match: got %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func TestClassificationPatterns(t *testing.T) {
+	t.Parallel()
+
+	custom, err := parseConfig([]byte(`{"classification_patterns": {
+		"enhancement": "^### feature request",
+		"documentation": "^### docs"
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		cfg  *Config
+		body string
+		want string
+	}{
+		{cfg: defaultConfig(), body: "### Feature request\nplease add tabs", want: "bug"},
+		{cfg: custom, body: "### Feature request\nplease add tabs", want: "enhancement"},
+		{cfg: custom, body: "### Docs\nthe user guide is wrong", want: "documentation"},
+		{cfg: defaultConfig(), body: "[x] Documentation Request", want: "documentation"},
+		// Overriding the documentation pattern replaces the default one.
+		{cfg: custom, body: "[x] Documentation Request", want: "bug"},
+	} {
+		issue := &github.Issue{Body: github.String(tt.body)}
+		if got := tt.cfg.classifyIssue(issue); got != tt.want {
+			t.Fatalf("classifyIssue(%q): got %q, want %q", tt.body, got, tt.want)
+		}
+	}
+
+	for _, invalid := range []string{
+		`{"classification_patterns": {"documentation": "("}}`,
+		`{"classification_patterns": {"question": "\\?"}}`,
+	} {
+		if _, err := parseConfig([]byte(invalid)); err == nil {
+			t.Fatalf("parseConfig(%s) unexpectedly succeeded", invalid)
+		}
+	}
+}
+
+func TestVersionHighestMinor(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		{body: "i3 version 4.9, also tried i3 version 4.10", want: "4.10"},
+		{body: "i3 version 4.10, also tried i3 version 4.9", want: "4.10"},
+		{body: "i3 version 4.2 and i3 version 4.20", want: "4.20"},
+		{body: "i3 version 4.20 and i3 version 4.2", want: "4.20"},
+	} {
+		matches := extractVersion(tt.body)
+		if len(matches) < 4 || matches[3] != tt.want {
+			t.Fatalf("extractVersion(%q): got %q, want version %s", tt.body, matches, tt.want)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{a: "4.20", b: "4.20", want: 0},
+		{a: "4.19", b: "4.20", want: -1},
+		{a: "4.21", b: "4.20", want: 1},
+		{a: "5.0", b: "4.20", want: 1},
+		{a: "4.8", b: "4.20", want: -1},
+		{a: "4.9", b: "4.10", want: -1},
+		{a: "4.10", b: "4.9", want: 1},
+		{a: "4.2", b: "4.20", want: -1},
+		{a: "4.10", b: "4.20", want: -1},
+		{a: "4.10.1", b: "4.10", want: 1},
+		{a: "3.e", b: "4.0", want: -1},
+		{a: "3.a", b: "3.e", want: -1},
+		{a: "3.δ", b: "3.ε", want: -1},
+	} {
+		t.Run(tt.a+" vs "+tt.b, func(t *testing.T) {
+			if got := compareVersions(tt.a, tt.b); got != tt.want {
+				t.Fatalf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBacktracePatterns(t *testing.T) {
+	t.Parallel()
+
+	log := []byte(`2015-02-01 17:21:48 - ../i3-4.8/src/handlers.c:handle_event:1231 - blah
+2015-02-01 17:21:48 - ../i3-4.8/src/main.c:main:42 - PANIC: container tree corrupted
+`)
+
+	def := defaultConfig()
+	if def.hasBacktrace(log) {
+		t.Fatalf("default patterns unexpectedly detected a crash")
+	}
+
+	custom, err := parseConfig([]byte(`{"backtrace_patterns": ["PANIC: "]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !custom.hasBacktrace(log) {
+		t.Fatalf("custom pattern did not detect the crash")
+	}
+
+	if _, err := parseConfig([]byte(`{"backtrace_patterns": ["("]}`)); err == nil {
+		t.Fatalf("parseConfig unexpectedly accepted an invalid pattern")
+	}
+}
+
+// fakeResponse returns an empty API response, as expected by discardResponse.
+func fakeResponse() *github.Response {
+	return &github.Response{
+		Response: &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader("")),
+		},
+	}
+}
+
+func init() {
+	// The App Engine log functions require an App Engine context.
+	debugf = func(ctx context.Context, format string, args ...interface{}) {
+		log.Printf("DEBUG: "+format, args...)
+	}
+	infof = func(ctx context.Context, format string, args ...interface{}) {
+		log.Printf("INFO: "+format, args...)
+	}
+	errorf = func(ctx context.Context, format string, args ...interface{}) {
+		log.Printf("ERROR: "+format, args...)
+	}
+	// memcache requires an App Engine context, too.
+	collaborators = newMemCollaborators()
+	// Most tests post the same comments on issue #1 of their own fake, so
+	// they must not share a commentGuard.
+	postedComments = unguardedComments{}
+}
+
+// unguardedComments implements commentGuard without recording anything.
+type unguardedComments struct{}
+
+func (unguardedComments) Record(ctx context.Context, key string) (bool, error) { return true, nil }
+func (unguardedComments) Forget(ctx context.Context, key string)               {}
+
+// memCommentGuard implements commentGuard in memory.
+type memCommentGuard struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (m *memCommentGuard) Record(ctx context.Context, key string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.keys[key] {
+		return false, nil
+	}
+	m.keys[key] = true
+	return true, nil
+}
+
+func (m *memCommentGuard) Forget(ctx context.Context, key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.keys, key)
+}
+
+// withPostedComments replaces postedComments with guard for the duration of
+// the test. Tests using it must not run in parallel.
+func withPostedComments(t *testing.T, guard commentGuard) {
+	old := postedComments
+	postedComments = guard
+	t.Cleanup(func() { postedComments = old })
+}
+
+// fakeIssues implements issueService, recording all modifications by issue
+// number.
+type fakeIssues struct {
+	issues     []*github.Issue
+	milestones []*github.Milestone
+
+	added    map[int][]string
+	removed  map[int][]string
+	comments map[int][]string
+	edits    map[int][]*github.IssueRequest
+
+	// existingComments are returned by ListComments before the comments
+	// created by the test.
+	existingComments map[int][]*github.IssueComment
+}
+
+func newFakeIssues() *fakeIssues {
+	return &fakeIssues{
+		added:    make(map[int][]string),
+		removed:  make(map[int][]string),
+		comments: make(map[int][]string),
+		edits:    make(map[int][]*github.IssueRequest),
+	}
+}
+
+func (f *fakeIssues) ListByRepo(ctx context.Context, owner string, repo string, opts *github.IssueListByRepoOptions) ([]*github.Issue, *github.Response, error) {
+	return f.issues, fakeResponse(), nil
+}
+
+func (f *fakeIssues) Get(ctx context.Context, owner string, repo string, number int) (*github.Issue, *github.Response, error) {
+	for _, issue := range f.issues {
+		if issue.GetNumber() == number {
+			return issue, fakeResponse(), nil
+		}
+	}
+	return nil, nil, errors.New("404 Not Found")
+}
+
+func (f *fakeIssues) Edit(ctx context.Context, owner string, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	f.edits[number] = append(f.edits[number], issue)
+	return &github.Issue{Number: github.Int(number)}, fakeResponse(), nil
+}
+
+func (f *fakeIssues) AddLabelsToIssue(ctx context.Context, owner string, repo string, number int, labels []string) ([]*github.Label, *github.Response, error) {
+	f.added[number] = append(f.added[number], labels...)
+	return nil, fakeResponse(), nil
+}
+
+func (f *fakeIssues) RemoveLabelForIssue(ctx context.Context, owner string, repo string, number int, label string) (*github.Response, error) {
+	f.removed[number] = append(f.removed[number], label)
+	return fakeResponse(), nil
+}
+
+func (f *fakeIssues) CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	f.comments[number] = append(f.comments[number], comment.GetBody())
+	return comment, fakeResponse(), nil
+}
+
+func (f *fakeIssues) ListMilestones(ctx context.Context, owner string, repo string, opts *github.MilestoneListOptions) ([]*github.Milestone, *github.Response, error) {
+	var milestones []*github.Milestone
+	for _, milestone := range f.milestones {
+		// Milestones without state match any listing.
+		if milestone.State == nil || opts.State == "all" || milestone.GetState() == opts.State {
+			milestones = append(milestones, milestone)
+		}
+	}
+	return milestones, fakeResponse(), nil
+}
+
+func (f *fakeIssues) ListComments(ctx context.Context, owner string, repo string, number int, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error) {
+	comments := append([]*github.IssueComment(nil), f.existingComments[number]...)
+	for _, body := range f.comments[number] {
+		comments = append(comments, &github.IssueComment{Body: github.String(body)})
+	}
+	return comments, fakeResponse(), nil
+}
+
+func TestBulkLabel(t *testing.T) {
+	t.Parallel()
+
+	newFake := func() *fakeIssues {
+		fake := newFakeIssues()
+		fake.issues = []*github.Issue{
+			{Number: github.Int(1), Body: github.String("i3 version 4.19.1 (2020-11-15)")},
+			{Number: github.Int(2), Body: github.String("i3 version 4.20 (2021-10-19)")},
+			{Number: github.Int(3), Body: github.String("no version here")},
+			{
+				Number: github.Int(4),
+				Body:   github.String("i3 version 4.19"),
+				Labels: []*github.Label{{Name: github.String("old")}},
+			},
+			{
+				Number:           github.Int(5),
+				Body:             github.String("i3 version 4.19"),
+				PullRequestLinks: &github.PullRequestLinks{},
+			},
+		}
+		return fake
+	}
+
+	t.Run("apply", func(t *testing.T) {
+		fake := newFake()
+		matching, err := bulkLabel(context.Background(), fake, "i3", "i3", "4.19", "old", false, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{1}; !reflect.DeepEqual(matching, want) {
+			t.Fatalf("unexpected matching issues: got %v, want %v", matching, want)
+		}
+		if want := map[int][]string{1: {"old"}}; !reflect.DeepEqual(fake.added, want) {
+			t.Fatalf("unexpected labels: got %v, want %v", fake.added, want)
+		}
+	})
+
+	t.Run("dry run", func(t *testing.T) {
+		fake := newFake()
+		matching, err := bulkLabel(context.Background(), fake, "i3", "i3", "4.19", "old", true, 100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{1}; !reflect.DeepEqual(matching, want) {
+			t.Fatalf("unexpected matching issues: got %v, want %v", matching, want)
+		}
+		if len(fake.added) > 0 {
+			t.Fatalf("dry run unexpectedly labeled issues: %v", fake.added)
+		}
+	})
+}
+
+func newIssuesEvent(body string, labels ...string) github.IssuesEvent {
+	issue := &github.Issue{
+		Number: github.Int(1),
+		Body:   github.String(body),
+		User:   &github.User{Login: github.String("reporter")},
+	}
+	for _, label := range labels {
+		issue.Labels = append(issue.Labels, &github.Label{Name: github.String(label)})
+	}
+	return github.IssuesEvent{
+		Action: github.String("opened"),
+		Issue:  issue,
+		Repo: &github.Repository{
+			Name:  github.String("i3"),
+			Owner: &github.User{Login: github.String("i3")},
+		},
+	}
+}
+
+func newIssueCommentEvent(issue github.IssuesEvent, author, body string) github.IssueCommentEvent {
+	return github.IssueCommentEvent{
+		Action: github.String("created"),
+		Issue:  issue.Issue,
+		Repo:   issue.Repo,
+		Comment: &github.IssueComment{
+			Body: github.String(body),
+			User: &github.User{Login: github.String(author)},
+		},
+	}
+}
+
+// editedComment turns |payload| into an edited event, in which the comment
+// previously read |from|.
+func editedComment(payload github.IssueCommentEvent, from string) github.IssueCommentEvent {
+	payload.Action = github.String("edited")
+	payload.Changes = &github.EditChange{Body: &github.EditBody{From: github.String(from)}}
+	return payload
+}
+
+// deletedComment turns |payload| into a deleted event.
+func deletedComment(payload github.IssueCommentEvent) github.IssueCommentEvent {
+	payload.Action = github.String("deleted")
+	return payload
+}
+
+const logLink = "https://logs.i3wm.org/logs/5745865499082752.bz2"
+
+// issueOutcome is the effect of an event on issue #1.
+type issueOutcome struct {
+	added    []string
+	removed  []string
+	comments int
+	closed   bool
+	reopened bool
+}
+
+func outcome(fake *fakeIssues) issueOutcome {
+	closed, reopened := false, false
+	for _, edit := range fake.edits[1] {
+		switch edit.GetState() {
+		case "closed":
+			closed = true
+		case "open":
+			reopened = true
+		}
+	}
+	return issueOutcome{
+		added:    fake.added[1],
+		removed:  fake.removed[1],
+		comments: len(fake.comments[1]),
+		closed:   closed,
+		reopened: reopened,
+	}
+}
+
+func TestIssuesEvent(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+
+	for _, tt := range []struct {
+		name    string
+		payload github.IssuesEvent
+		want    issueOutcome
+	}{
+		{
+			name: "feature request",
+			payload: newIssuesEvent(`<pre>
+[x] This feature requires new configuration and/or commands
+</pre>`, "enhancement"),
+			want: issueOutcome{
+				added:    []string{"requires-configuration"},
+				comments: 1,
+			},
+		},
+
+		{
+			name:    "missing version and log",
+			payload: newIssuesEvent("i3 crashes all the time"),
+			want: issueOutcome{
+				added:    []string{"missing-log", "missing-version"},
+				comments: 2,
+			},
+		},
+
+		{
+			name:    "missing log",
+			payload: newIssuesEvent("i3 version 4.20 (2021-10-19) crashes"),
+			want: issueOutcome{
+				added:    []string{"missing-log", "4.20"},
+				comments: 1,
+			},
+		},
+
+		{
+			name:    "supported version",
+			payload: newIssuesEvent("i3 version 4.20 (2021-10-19) crashes, see " + logLink),
+			want: issueOutcome{
+				added: []string{"4.20"},
+			},
+		},
+
+		{
+			name:    "unsupported version",
+			payload: newIssuesEvent("i3 version 4.19 (2020-11-15) crashes, see " + logLink),
+			want: issueOutcome{
+				added:    []string{"unsupported-version"},
+				comments: 1,
+				closed:   true,
+			},
+		},
+
+		{
+			name:    "development version",
+			payload: newIssuesEvent(`i3 version 4.21-non-git (2021-12-24, branch "next") crashes, see ` + logLink),
+			want: issueOutcome{
+				added:    []string{"development-version"},
+				comments: 1,
+			},
+		},
+
+		{
+			name:    "development version trailing the latest release",
+			payload: newIssuesEvent(`i3 version 4.19 (2020-11-15, branch "next") crashes, see ` + logLink),
+			want: issueOutcome{
+				added:    []string{"development-version"},
+				comments: 1,
+			},
+		},
+
+		{
+			name:    "release built from its tag",
+			payload: newIssuesEvent(`i3 version 4.19 (2020-11-15, branch "4.19") crashes, see ` + logLink),
+			want: issueOutcome{
+				added:    []string{"unsupported-version"},
+				comments: 1,
+				closed:   true,
+			},
+		},
+
+		{
+			name:    "unverified version",
+			payload: newIssuesEvent("i3 version 4.99 crashes, see " + logLink),
+			want: issueOutcome{
+				added:    []string{"version-unverified"},
+				comments: 1,
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = milestones
+			rec := httptest.NewRecorder()
+			handleIssuesEvent(context.Background(), rec, &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected HTTP status: got %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIssueReferenceBoilerplate(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name         string
+		payload      github.IssuesEvent
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name:         "bug without reference",
+			payload:      newIssuesEvent("i3 version 4.20 (2021-10-19) crashes"),
+			wantAdded:    []string{"missing-log", "4.20"},
+			wantComments: []string{missingLogComment},
+		},
+
+		{
+			name:         "bug referencing duplicate",
+			payload:      newIssuesEvent("Similar to #4242, i3 version 4.20 (2021-10-19) crashes"),
+			wantAdded:    []string{"missing-log", "4.20"},
+			wantComments: []string{missingLogCommentShort},
+		},
+
+		{
+			name:         "bug referencing issue URL",
+			payload:      newIssuesEvent("See https://github.com/i3/i3/issues/4242, i3 version 4.20 (2021-10-19) crashes"),
+			wantAdded:    []string{"missing-log", "4.20"},
+			wantComments: []string{missingLogCommentShort},
+		},
+
+		{
+			name:         "bug with color",
+			payload:      newIssuesEvent("With client.focused #285577, i3 version 4.20 (2021-10-19) crashes"),
+			wantAdded:    []string{"missing-log", "4.20"},
+			wantComments: []string{missingLogComment},
+		},
+
+		{
+			name:         "feature request without reference",
+			payload:      newIssuesEvent("Please add a blink option", "enhancement"),
+			wantComments: []string{featureRequestComment},
+		},
+
+		{
+			name: "feature request referencing discussion",
+			payload: newIssuesEvent(`As discussed in i3/i3#4242:
+<pre>
+[x] This feature requires new configuration and/or commands
+</pre>`, "enhancement"),
+			wantAdded: []string{"requires-configuration"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+}
+
+func TestRequiresConfigurationComment(t *testing.T) {
+	t.Parallel()
+
+	const note = "Please describe why this cannot be done via IPC."
+	cfg := defaultConfig()
+	cfg.RequiresConfigurationComment = note
+	body := `<pre>
+[x] This feature requires new configuration and/or commands
+</pre>`
+
+	fake := newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(body, "enhancement"))
+	if got, want := fake.comments[1], []string{note, featureRequestComment}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected comments: got %q, want %q", got, want)
+	}
+
+	// The note is only posted when the label is newly applied.
+	fake = newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(body, "enhancement", "requires-configuration"))
+	if got, want := fake.comments[1], []string{featureRequestComment}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected comments: got %q, want %q", got, want)
+	}
+}
+
+func TestFeatureTriageLabel(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.FeatureTriageLabel = "triage/feature"
+
+	fake := newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent("Please add a blink option", "enhancement"))
+	if got, want := fake.added[1], []string{"triage/feature"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %v, want %v", got, want)
+	}
+
+	// Bug reports do not get the triage label.
+	fake = newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent("i3 version 4.20 (2021-10-19) crashes, see "+logLink))
+	if got, want := fake.added[1], []string{"4.20"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %v, want %v", got, want)
+	}
+}
+
+func TestSyncVersionLabels(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.23")}, {Title: github.String("4.22")}}
+	labelEvent := func(action, label, body string, labels ...string) github.IssuesEvent {
+		payload := newIssuesEvent(body, labels...)
+		payload.Action = github.String(action)
+		payload.Label = &github.Label{Name: github.String(label)}
+		return payload
+	}
+
+	for _, tt := range []struct {
+		name    string
+		sync    bool
+		payload github.IssuesEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "labeled",
+			sync:    true,
+			payload: labelEvent("labeled", "4.23", "i3 crashes", "missing-version", "unsupported-version", "4.23"),
+			want: issueOutcome{
+				removed: []string{"missing-version", "unsupported-version"},
+			},
+		},
+
+		{
+			name:    "labeled disabled",
+			payload: labelEvent("labeled", "4.23", "i3 crashes", "missing-version", "4.23"),
+		},
+
+		{
+			name:    "labeled non-version",
+			sync:    true,
+			payload: labelEvent("labeled", "bug", "i3 crashes", "missing-version", "bug"),
+		},
+
+		{
+			name:    "labeled unknown version",
+			sync:    true,
+			payload: labelEvent("labeled", "4.99", "i3 crashes", "missing-version", "4.99"),
+		},
+
+		{
+			name:    "unlabeled",
+			sync:    true,
+			payload: labelEvent("unlabeled", "4.23", "i3 crashes"),
+			want: issueOutcome{
+				added: []string{"missing-version"},
+			},
+		},
+
+		{
+			name:    "unlabeled with version in body",
+			sync:    true,
+			payload: labelEvent("unlabeled", "4.23", "i3 version 4.22 crashes"),
+		},
+
+		{
+			name:    "unlabeled with other version label",
+			sync:    true,
+			payload: labelEvent("unlabeled", "4.23", "i3 crashes", "4.22"),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := defaultConfig()
+			cfg.SyncVersionLabels = tt.sync
+			fake := newFakeIssues()
+			fake.milestones = milestones
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, tt.payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHasLogLink(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		text string
+		want bool
+	}{
+		{text: "see " + logLink, want: true},
+		{text: "see https://logs.i3wm.org/logs/immutable/k3xw9q2m.bz2", want: true},
+		{text: "<!-- replace with logs.i3wm.org link -->", want: false},
+		{text: "<!-- e.g. " + logLink + " -->", want: false},
+		{text: "<!--\nPaste your log link, e.g. https://logs.i3wm.org/logs/1234.bz2\n-->", want: false},
+		{text: "https://logs.i3wm.org/logs/<your log ID>.bz2", want: false},
+		{text: "https://logs.i3wm.org/logs/XXXXXXXX.bz2", want: false},
+		{text: "https://logs.i3wm.org/logs/{id}", want: false},
+		{text: "<!-- replace with logs.i3wm.org link -->\n" + logLink, want: true},
+	} {
+		if got := hasLogLink(tt.text); got != tt.want {
+			t.Fatalf("hasLogLink(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	// The unreplaced placeholder of an issue form still results in
+	// missing-log.
+	body := strings.Replace(issueFormBody, logLink, "<!-- replace with https://logs.i3wm.org link -->", 1)
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.23")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(body))
+	if got, want := outcome(fake), (issueOutcome{added: []string{"missing-log", "4.23"}, comments: 1}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestEditedBody(t *testing.T) {
+	t.Parallel()
+
+	editEvent := func(from, body string, labels ...string) github.IssuesEvent {
+		payload := newIssuesEvent(body, labels...)
+		payload.Action = github.String("edited")
+		payload.Issue.State = github.String("open")
+		payload.Changes = &github.EditChange{Body: &github.EditBody{From: github.String(from)}}
+		return payload
+	}
+	titleOnly := editEvent("", "i3 crashes", "missing-log")
+	titleOnly.Changes = &github.EditChange{Title: &github.EditTitle{From: github.String("crash")}}
+	closed := editEvent("i3 crashes, see "+logLink, "i3 crashes")
+	closed.Issue.State = github.String("closed")
+
+	for _, tt := range []struct {
+		name    string
+		readd   bool
+		payload github.IssuesEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "log added",
+			payload: editEvent("i3 crashes", "i3 crashes, see "+logLink, "missing-log"),
+			want: issueOutcome{
+				removed: []string{"missing-log"},
+			},
+		},
+
+		{
+			name:    "log added without label",
+			payload: editEvent("i3 crashes", "i3 crashes, see "+logLink),
+		},
+
+		{
+			name:    "log removed",
+			payload: editEvent("i3 crashes, see "+logLink, "i3 crashes"),
+		},
+
+		{
+			name:    "log removed, readding label",
+			readd:   true,
+			payload: editEvent("i3 crashes, see "+logLink, "i3 crashes"),
+			want: issueOutcome{
+				added: []string{"missing-log"},
+			},
+		},
+
+		{
+			name:    "log removed from closed issue",
+			readd:   true,
+			payload: closed,
+		},
+
+		{
+			name:    "log removed from feature request",
+			readd:   true,
+			payload: editEvent("see "+logLink, "please add a feature", "enhancement"),
+		},
+
+		{
+			name:    "title edited",
+			readd:   true,
+			payload: titleOnly,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			cfg := defaultConfig()
+			cfg.ReaddMissingLog = tt.readd
+			fake := newFakeIssues()
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, tt.payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnboardingComment(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.OnboardingComment = true
+
+	for _, tt := range []struct {
+		name         string
+		body         string
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name:         "missing version and log",
+			body:         "i3 crashes all the time",
+			wantAdded:    []string{"missing-log", "missing-version"},
+			wantComments: []string{onboardingComment},
+		},
+
+		{
+			name:         "missing log",
+			body:         "i3 version 4.20 (2021-10-19) crashes",
+			wantAdded:    []string{"missing-log", "4.20"},
+			wantComments: []string{missingLogComment},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(tt.body))
+			if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+}
+
+func TestSkipIssue(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.SkipTitlePrefixes = []string{"[auto]"}
+	cfg.SkipLabels = []string{"automation"}
+
+	for _, tt := range []struct {
+		name     string
+		title    string
+		labels   []string
+		wantSkip bool
+	}{
+		{name: "title prefix", title: "[auto] nightly build failed", wantSkip: true},
+		{name: "title prefix case", title: "[AUTO] nightly build failed", wantSkip: true},
+		{name: "label", title: "nightly build failed", labels: []string{"automation"}, wantSkip: true},
+		{name: "regular", title: "i3 crashes [auto]"},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			payload := newIssuesEvent("nightly build failed", tt.labels...)
+			payload.Issue.Title = github.String(tt.title)
+			fake := newFakeIssues()
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, payload)
+			skipped := len(fake.added[1]) == 0 && len(fake.comments[1]) == 0
+			if skipped != tt.wantSkip {
+				t.Fatalf("unexpected outcome: got %+v, want skipped = %v", outcome(fake), tt.wantSkip)
+			}
+		})
+	}
+}
+
+func TestEmptyIssue(t *testing.T) {
+	t.Parallel()
+
+	nullBody := newIssuesEvent("")
+	nullBody.Issue.Body = nil
+	for _, tt := range []struct {
+		name    string
+		payload github.IssuesEvent
+	}{
+		{name: "empty", payload: newIssuesEvent("")},
+		{name: "whitespace", payload: newIssuesEvent("  \r\n\r\n ")},
+		{name: "short", payload: newIssuesEvent("i3 broken")},
+		{name: "null", payload: nullBody},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if got, want := fake.added[1], []string{"needs-info"}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, want)
+			}
+			if got, want := fake.comments[1], []string{emptyIssueComment}; !reflect.DeepEqual(got, want) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRedirectWrongRepository(t *testing.T) {
+	t.Parallel()
+
+	const i3lockComment = "Please report i3lock issues at https://github.com/i3/i3lock/issues."
+	cfg := defaultConfig()
+	cfg.RedirectWrongRepository = true
+	cfg.RedirectComments = map[string]string{"i3lock": i3lockComment}
+
+	for _, tt := range []struct {
+		name         string
+		body         string
+		wantComments []string
+		wantClosed   bool
+	}{
+		{
+			name:         "i3lock",
+			body:         "i3lock version 2.13 does not lock, see " + logLink,
+			wantComments: []string{i3lockComment},
+			wantClosed:   true,
+		},
+
+		{
+			name: "i3status default",
+			body: "i3status version 2.14 shows garbage, see " + logLink,
+			wantComments: []string{"This looks like an issue with i3status, which is developed in a separate repository. " +
+				"Please file it at https://github.com/i3/i3status/issues instead."},
+			wantClosed: true,
+		},
+
+		{
+			name: "i3",
+			body: "i3 version 4.20 crashes, see " + logLink,
+		},
+
+		{
+			name: "i3 and i3lock",
+			body: "### Version\n\ni3lock 2.14\n\n### Description\n\nWith i3 version 4.20, i3lock does not lock, see " + logLink,
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(tt.body))
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+			if got := outcome(fake).closed; got != tt.wantClosed {
+				t.Fatalf("unexpected closed state: got %v, want %v", got, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestRedirectWrongRepositoryComment(t *testing.T) {
+	t.Parallel()
+
+	const i3lockComment = "Please report i3lock issues at https://github.com/i3/i3lock/issues."
+	cfg := defaultConfig()
+	cfg.RedirectWrongRepository = true
+	cfg.RedirectComments = map[string]string{"i3lock": i3lockComment}
+
+	issue := newIssuesEvent("The screen does not lock", "missing-version")
+	for _, tt := range []struct {
+		name         string
+		issue        github.IssuesEvent
+		comment      string
+		wantComments []string
+		wantClosed   bool
+	}{
+		{
+			name:         "i3lock version in comment",
+			issue:        issue,
+			comment:      "i3lock version 2.13",
+			wantComments: []string{i3lockComment},
+			wantClosed:   true,
+		},
+
+		{
+			name:    "i3 version in comment",
+			issue:   issue,
+			comment: "i3 version 4.20 (2021-10-19)",
+		},
+
+		{
+			name:    "issue mentions i3",
+			issue:   newIssuesEvent("The screen does not lock when i3 version 4.20 starts", "missing-version"),
+			comment: "i3lock version 2.13",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg,
+				newIssueCommentEvent(tt.issue, "reporter", tt.comment))
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+			if got := outcome(fake).closed; got != tt.wantClosed {
+				t.Fatalf("unexpected closed state: got %v, want %v", got, tt.wantClosed)
+			}
+		})
+	}
+}
+
+func TestProgramRepos(t *testing.T) {
+	t.Parallel()
+
+	const body = "i3lock version 2.13 does not lock, see " + logLink
+	for _, tt := range []struct {
+		name        string
+		config      string
+		repo        string
+		body        string
+		wantComment string
+	}{
+		{
+			name:        "default",
+			config:      `{"redirect_wrong_repository": true}`,
+			repo:        "i3",
+			body:        body,
+			wantComment: "https://github.com/i3/i3lock/issues",
+		},
+		{
+			name:   "custom",
+			config: `{"redirect_wrong_repository": true, "program_repos": {"i3lock": {"repo": "example/lock", "name": "the screen locker"}}}`,
+			repo:   "i3",
+			body:   body,
+			wantComment: "This looks like an issue with the screen locker, which is developed in a separate repository. " +
+				"Please file it at https://github.com/example/lock/issues instead.",
+		},
+		{
+			name:   "same repository",
+			config: `{"redirect_wrong_repository": true}`,
+			repo:   "i3",
+			body:   "i3bar version 4.20 shows no tray icons, see " + logLink,
+		},
+		{
+			name:        "i3 issue in the i3status repository",
+			config:      `{"redirect_wrong_repository": true}`,
+			repo:        "i3status",
+			body:        "i3 version 4.20 crashes, see " + logLink,
+			wantComment: "https://github.com/i3/i3/issues",
+		},
+	} {
+		cfg, err := parseConfig([]byte(tt.config))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		payload := newIssuesEvent(tt.body)
+		payload.Repo.Name = github.String(tt.repo)
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, payload)
+		comments := fake.comments[1]
+		if tt.wantComment == "" {
+			if len(comments) > 0 || outcome(fake).closed {
+				t.Fatalf("%s: unexpectedly redirected: %q", tt.name, comments)
+			}
+			continue
+		}
+		if len(comments) != 1 || !strings.Contains(comments[0], tt.wantComment) {
+			t.Fatalf("%s: unexpected comments: got %q, want one containing %q", tt.name, comments, tt.wantComment)
+		}
+		if !outcome(fake).closed {
+			t.Fatalf("%s: issue not closed", tt.name)
+		}
+	}
+
+	if _, err := parseConfig([]byte(`{"program_repos": {"i3lock": {"repo": "i3lock"}}}`)); err == nil {
+		t.Fatal("parseConfig(invalid program_repos) unexpectedly succeeded")
+	}
+}
+
+func TestHasConfigBlock(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		body string
+		want bool
+	}{
+		{
+			name: "fenced config",
+			body: "Minimal config:\n\n```\nset $mod Mod4\nbindsym $mod+Return exec xterm\n```\n",
+			want: true,
+		},
+		{
+			name: "fenced config with language",
+			body: "```i3config\n  for_window [class=\"mpv\"] floating enable\n```",
+			want: true,
+		},
+		{
+			name: "tilde fence",
+			body: "~~~\nworkspace 1 output HDMI-1\n~~~",
+			want: true,
+		},
+		{
+			name: "prose",
+			body: "workspace switching is broken when I exec something\nbindsym does not help",
+		},
+		{
+			name: "fenced non-config",
+			body: "```\nBinary i3 version:  4.20\n```",
+		},
+	} {
+		if got := hasConfigBlock(tt.body); got != tt.want {
+			t.Fatalf("%s: hasConfigBlock = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNeedsConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.NeedsConfigRepos = []string{"i3/i3"}
+	bug := "i3 version 4.20 crashes when opening a window, see " + logLink
+
+	for _, tt := range []struct {
+		name         string
+		cfg          *Config
+		body         string
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name:         "without config",
+			cfg:          cfg,
+			body:         bug,
+			wantAdded:    []string{"needs-config", "4.20"},
+			wantComments: []string{needsConfigComment},
+		},
+		{
+			name:      "with config",
+			cfg:       cfg,
+			body:      bug + "\n\n```\nbindsym Mod4+Return exec xterm\n```\n",
+			wantAdded: []string{"4.20"},
+		},
+		{
+			name:      "disabled",
+			cfg:       defaultConfig(),
+			body:      bug,
+			wantAdded: []string{"4.20"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, newIssuesEvent(tt.body))
+			if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+}
+
+func TestTriageSummary(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.NeedsConfigRepos = []string{"i3/i3"}
+	cfg.TriageSummary = true
+	separate := defaultConfig()
+	separate.NeedsConfigRepos = []string{"i3/i3"}
+
+	for _, tt := range []struct {
+		name         string
+		cfg          *Config
+		body         string
+		wantComments []string
+	}{
+		{
+			name: "summary",
+			cfg:  cfg,
+			body: "i3 crashes when opening a window",
+			wantComments: []string{triageSummaryComment +
+				"- [ ] Your i3 config, reduced to the minimum which reproduces the problem, as a code block.\n" +
+				"- [ ] A link to a debug log uploaded to logs.i3wm.org, see https://i3wm.org/docs/debugging.html.\n" +
+				"- [ ] The output of `i3 --version`.\n"},
+		},
+		{
+			name:         "single finding",
+			cfg:          cfg,
+			body:         "i3 version 4.20 crashes when opening a window, see " + logLink,
+			wantComments: []string{needsConfigComment},
+		},
+		{
+			name:         "separate comments",
+			cfg:          separate,
+			body:         "i3 crashes when opening a window",
+			wantComments: []string{needsConfigComment, missingLogComment, missingVersionComment},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, newIssuesEvent(tt.body))
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+}
+
+func TestGreeting(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.GreetingRepos = []string{"i3/i3"}
+	body := "i3 version 4.20 (2021-10-19) crashes, see " + logLink
+
+	for _, tt := range []struct {
+		association  string
+		cfg          *Config
+		wantComments []string
+	}{
+		{association: "FIRST_TIME_CONTRIBUTOR", cfg: cfg, wantComments: []string{cfg.GreetingComment}},
+		{association: "FIRST_TIMER", cfg: cfg, wantComments: []string{cfg.GreetingComment}},
+		{association: "MEMBER", cfg: cfg},
+		{association: "FIRST_TIME_CONTRIBUTOR", cfg: defaultConfig()},
+	} {
+		payload := newIssuesEvent(body)
+		payload.Issue.AuthorAssociation = github.String(tt.association)
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, payload)
+		if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+			t.Fatalf("%s: unexpected comments: got %q, want %q", tt.association, got, tt.wantComments)
+		}
+	}
+}
+
+func TestComponentMarkers(t *testing.T) {
+	t.Parallel()
+
+	body := `i3 version 4.20 (2021-10-19): the tray icons are cut off, see ` + logLink + `
+<pre>
+[ ] i3
+[x] i3bar
+</pre>`
+	i3barComment := defaultComponentMarkers["i3/i3"][1].Comment
+
+	for _, tt := range []struct {
+		name         string
+		payload      github.IssuesEvent
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name:         "i3bar marker in i3 repo",
+			payload:      newIssuesEvent(body),
+			wantAdded:    []string{"wrong-component"},
+			wantComments: []string{i3barComment},
+		},
+		{
+			name: "i3bar marker in other repo",
+			payload: func() github.IssuesEvent {
+				payload := newIssuesEvent(body)
+				payload.Repo.Owner.Login = github.String("someone")
+				return payload
+			}(),
+			wantAdded: []string{"4.20"},
+		},
+		{
+			name:      "unticked marker",
+			payload:   newIssuesEvent(strings.Replace(body, "[x] i3bar", "[ ] i3bar", 1)),
+			wantAdded: []string{"4.20"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+
+	if _, err := parseConfig([]byte(`{"component_markers": {"i3/i3": [{"pattern": "("}]}}`)); err == nil {
+		t.Fatalf("parseConfig unexpectedly accepted an invalid component marker")
+	}
+}
+
+func TestIssueCommentEvent(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	issue := newIssuesEvent("i3 crashes all the time", "missing-version", "missing-log")
+
+	for _, tt := range []struct {
+		name    string
+		payload github.IssueCommentEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "version and log provided",
+			payload: newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19), log: "+logLink),
+			want: issueOutcome{
+				added:   []string{"4.20"},
+				removed: []string{"missing-log", "missing-version"},
+			},
+		},
+
+		{
+			name:    "unsupported version provided",
+			payload: newIssueCommentEvent(issue, "reporter", "i3 version 4.18"),
+			want: issueOutcome{
+				added:    []string{"unsupported-version"},
+				removed:  []string{"missing-version"},
+				comments: 1,
+				closed:   true,
+			},
+		},
+
+		{
+			name:    "comment by somebody else",
+			payload: newIssueCommentEvent(issue, "bystander", "i3 version 4.20 (2021-10-19), log: "+logLink),
+			want:    issueOutcome{},
+		},
+
+		{
+			name:    "edited to provide the version",
+			payload: editedComment(newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19)"), "i3 version: TBD"),
+			want: issueOutcome{
+				added:   []string{"4.20"},
+				removed: []string{"missing-version"},
+			},
+		},
+
+		{
+			name:    "edited without changing the text",
+			payload: editedComment(newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19)"), "i3 version 4.20 (2021-10-19)"),
+			want:    issueOutcome{},
+		},
+
+		{
+			name:    "deleted",
+			payload: deletedComment(newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19), log: "+logLink)),
+			want:    issueOutcome{},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = milestones
+			rec := httptest.NewRecorder()
+			handleIssueCommentEvent(context.Background(), rec, &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if rec.Code != http.StatusOK {
+				t.Fatalf("unexpected HTTP status: got %d, want %d", rec.Code, http.StatusOK)
+			}
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+const issueFormBody = `### Issue type
+
+Bug
+
+### Version
+
+4.23
+
+### Description
+
+Windows are placed on the wrong workspace after i3 3.e made everything better.
+
+### Log file
+
+https://logs.i3wm.org/logs/5745865499082752.bz2
+`
+
+func TestReopenSupportedVersions(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.ReopenSupportedVersions = true
+	closed := newIssuesEvent("i3 version 4.18 crashes, see "+logLink, "unsupported-version")
+	closed.Issue.State = github.String("closed")
+	open := newIssuesEvent("i3 version 4.18 crashes, see "+logLink, "unsupported-version")
+	open.Issue.State = github.String("open")
+
+	for _, tt := range []struct {
+		name    string
+		cfg     *Config
+		payload github.IssueCommentEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "supported version",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(closed, "reporter", "I upgraded: i3 version 4.20 (2021-10-19)"),
+			want: issueOutcome{
+				added:    []string{"4.20"},
+				removed:  []string{"unsupported-version"},
+				reopened: true,
+			},
+		},
+
+		{
+			name:    "disabled",
+			cfg:     defaultConfig(),
+			payload: newIssueCommentEvent(closed, "reporter", "I upgraded: i3 version 4.20 (2021-10-19)"),
+			want: issueOutcome{
+				added:   []string{"4.20"},
+				removed: []string{"unsupported-version"},
+			},
+		},
+
+		{
+			name:    "still unsupported",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(closed, "reporter", "i3 version 4.19 (2020-11-15)"),
+		},
+
+		{
+			name:    "open issue",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(open, "reporter", "I upgraded: i3 version 4.20 (2021-10-19)"),
+			want: issueOutcome{
+				added:   []string{"4.20"},
+				removed: []string{"unsupported-version"},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, tt.payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOldIssue(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"old_issues_before": "2016-01-01", "old_issue_version": "4.11"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	repos := &fakeRepositories{
+		collaborators: map[string]bool{"maintainer": true},
+	}
+	newIssue := func(created time.Time, body string) github.IssuesEvent {
+		issue := newIssuesEvent(body, "missing-log")
+		issue.Issue.CreatedAt = &created
+		return issue
+	}
+	old := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)
+	recent := time.Date(2022, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		name    string
+		cfg     *Config
+		payload github.IssueCommentEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "old issue and version",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(newIssue(old, "i3 version 4.7.2 crashes"), "bystander", "still happening"),
+			want: issueOutcome{
+				added:    []string{"stale-old-version"},
+				comments: 1,
+			},
+		},
+
+		{
+			name:    "comment by maintainer",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(newIssue(old, "i3 version 4.7.2 crashes"), "maintainer", "still happening"),
+		},
+
+		{
+			name:    "recent issue",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(newIssue(recent, "i3 version 4.7.2 crashes"), "bystander", "still happening"),
+		},
+
+		{
+			name:    "supported version",
+			cfg:     cfg,
+			payload: newIssueCommentEvent(newIssue(old, "i3 version 4.11 crashes"), "bystander", "still happening"),
+		},
+
+		{
+			name:    "disabled",
+			cfg:     defaultConfig(),
+			payload: newIssueCommentEvent(newIssue(old, "i3 version 4.7.2 crashes"), "bystander", "still happening"),
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake, Repositories: repos}, tt.cfg, tt.payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := parseConfig([]byte(`{"old_issues_before": "2016-01-01"}`)); err == nil {
+		t.Fatalf("parseConfig unexpectedly accepted old_issues_before without old_issue_version")
+	}
+}
+
+func TestNilPayloadFields(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	newClient := func() (*fakeIssues, *apiClient) {
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		return fake, &apiClient{Issues: fake, Repositories: &fakeRepositories{}}
+	}
+
+	// Events which the bot cannot act on are ignored.
+	for _, payload := range []github.IssuesEvent{
+		{Action: github.String("opened")},
+		{Action: github.String("opened"), Issue: &github.Issue{Number: github.Int(1)}},
+		{Action: github.String("opened"), Repo: newIssuesEvent("").Repo},
+	} {
+		fake, client := newClient()
+		handleIssuesEvent(ctx, httptest.NewRecorder(), client, defaultConfig(), payload)
+		if got := outcome(fake); !reflect.DeepEqual(got, issueOutcome{}) {
+			t.Fatalf("unexpected outcome for %+v: got %+v", payload, got)
+		}
+	}
+
+	// A comment on an issue without body (null in the JSON payload), by an
+	// issue author whose user is missing.
+	issue := newIssuesEvent("", "missing-version")
+	issue.Issue.Body = nil
+	issue.Issue.User = nil
+	fake, client := newClient()
+	comment := newIssueCommentEvent(issue, "reporter", "")
+	comment.Comment.Body = nil
+	handleIssueCommentEvent(ctx, httptest.NewRecorder(), client, defaultConfig(), comment)
+	if got := outcome(fake); !reflect.DeepEqual(got, issueOutcome{}) {
+		t.Fatalf("unexpected outcome: got %+v", got)
+	}
+
+	// A comment event without comment.
+	comment.Comment = nil
+	handleIssueCommentEvent(ctx, httptest.NewRecorder(), client, defaultConfig(), comment)
+
+	// A comment by the reporter providing the version on an issue without
+	// body.
+	issue = newIssuesEvent("", "missing-version")
+	issue.Issue.Body = nil
+	fake, client = newClient()
+	handleIssueCommentEvent(ctx, httptest.NewRecorder(), client, defaultConfig(), newIssueCommentEvent(issue, "reporter", "i3 version 4.20"))
+	want := issueOutcome{added: []string{"4.20"}, removed: []string{"missing-version"}}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestIssueForm(t *testing.T) {
+	t.Parallel()
+
+	fields := parseIssueForm(issueFormBody)
+	if got, want := fields["version"], "4.23"; got != want {
+		t.Fatalf("unexpected version field: got %q, want %q", got, want)
+	}
+	if got, want := formIssueType(fields), "bug"; got != want {
+		t.Fatalf("unexpected issue type: got %q, want %q", got, want)
+	}
+
+	// Without form parsing, the “3.e” in the description would be considered.
+	matches := extractIssueVersion(issueFormBody, "i3")
+	if len(matches) < 3 || matches[1] != "i3" || matches[2] != "4.23" {
+		t.Fatalf("version not extracted from the issue form, matches = %+v", matches)
+	}
+
+	feature := strings.Replace(issueFormBody, "Bug", "Feature request", 1)
+	if got, want := formIssueType(parseIssueForm(feature)), "enhancement"; got != want {
+		t.Fatalf("unexpected issue type: got %q, want %q", got, want)
+	}
+
+	if fields := parseIssueForm("i3 version 4.23 crashes"); fields != nil {
+		t.Fatalf("plain body unexpectedly parsed as issue form: %v", fields)
+	}
+	matches = extractIssueVersion("i3 version 4.22 crashes", "i3")
+	if len(matches) < 3 || matches[1] != "i3" || matches[2] != "4.22" {
+		t.Fatalf("version not extracted from plain body, matches = %+v", matches)
+	}
+
+	// An empty version field falls back to the rest of the body.
+	emptyVersion := strings.Replace(issueFormBody, "4.23", formNoResponse, 1)
+	emptyVersion = strings.Replace(emptyVersion, "3.e made", "version 4.22 made", 1)
+	matches = extractIssueVersion(emptyVersion, "i3")
+	if len(matches) < 3 || matches[1] != "i3" || matches[2] != "4.22" {
+		t.Fatalf("version not extracted from body with empty version field, matches = %+v", matches)
+	}
+	noVersion := strings.Replace(issueFormBody, "4.23", "", 1)
+	noVersion = strings.Replace(noVersion, "i3 3.e made", "it made", 1)
+	if matches := extractIssueVersion(noVersion, "i3"); len(matches) != 0 {
+		t.Fatalf("unexpected version in body without version, matches = %+v", matches)
+	}
+}
+
+func TestGetGitHubTokenTransientError(t *testing.T) {
+	oldLoad, oldDelay := loadGitHubToken, tokenRetryDelay
+	defer func() {
+		loadGitHubToken, tokenRetryDelay = oldLoad, oldDelay
+		githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+	}()
+	tokenRetryDelay = time.Millisecond
+
+	attempts := 0
+	loadGitHubToken = func(ctx context.Context) (GitHubToken, error) {
+		attempts++
+		return GitHubToken{}, errors.New("API error 5 (datastore_v3: TIMEOUT)")
+	}
+
+	// Without a previously loaded token, the error is returned.
+	githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+	if err := getGitHubToken(context.Background()); err == nil {
+		t.Fatalf("getGitHubToken unexpectedly succeeded without cached token")
+	}
+	if attempts != 3 {
+		t.Fatalf("unexpected number of attempts: got %d, want 3", attempts)
+	}
+
+	// A previously loaded (but stale) token is used instead.
+	cached := GitHubToken{Token: "token", Secret: "secret"}
+	githubToken, githubTokenLoaded = cached, time.Now().Add(-2*githubTokenMaxAge)
+	if err := getGitHubToken(context.Background()); err != nil {
+		t.Fatalf("getGitHubToken: %v", err)
+	}
+	if githubToken != cached {
+		t.Fatalf("unexpected token: got %+v, want %+v", githubToken, cached)
+	}
+}
+
+func TestGetConfigMaxAge(t *testing.T) {
+	oldLoad := loadConfigEntity
+	t.Cleanup(func() { loadConfigEntity = oldLoad })
+	stored := `{"scope": "issues"}`
+	var loadErr error
+	loadConfigEntity = func(ctx context.Context) (configEntity, error) {
+		return configEntity{JSON: stored}, loadErr
+	}
+	ctx := context.Background()
+
+	// A fresh cached config is used as is.
+	withConfig(t, defaultConfig())
+	if cfg, err := getConfig(ctx); err != nil || cfg.Scope != scopeAll {
+		t.Fatalf("getConfig = %+v, %v, want the cached config", cfg, err)
+	}
+
+	// A stale one is read again, e.g. after an update on another instance.
+	configMu.Lock()
+	configLoaded = time.Now().Add(-2 * configMaxAge)
+	configMu.Unlock()
+	if cfg, err := getConfig(ctx); err != nil || cfg.Scope != scopeIssues {
+		t.Fatalf("getConfig = %+v, %v, want the stored config", cfg, err)
+	}
+
+	// When reading fails, the stale config is used instead.
+	configMu.Lock()
+	configLoaded = time.Now().Add(-2 * configMaxAge)
+	configMu.Unlock()
+	stored, loadErr = `{"scope": "comments"}`, errors.New("API error 5 (datastore_v3: TIMEOUT)")
+	if cfg, err := getConfig(ctx); err != nil || cfg.Scope != scopeIssues {
+		t.Fatalf("getConfig = %+v, %v, want the cached config", cfg, err)
+	}
+
+	// Concurrent reads and updates are safe (see go test -race).
+	loadErr = nil
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			configOrDefault(ctx)
+		}()
+		go func() {
+			defer wg.Done()
+			setConfig(defaultConfig())
+		}()
+	}
+	wg.Wait()
+}
+
+func TestGitHubTokenFromSecretManager(t *testing.T) {
+	oldLoad, oldLoadSecret := loadGitHubToken, loadSecretGitHubToken
+	oldSecret, oldPrefer := githubTokenSecret, preferSecretManager
+	defer func() {
+		loadGitHubToken, loadSecretGitHubToken = oldLoad, oldLoadSecret
+		githubTokenSecret, preferSecretManager = oldSecret, oldPrefer
+		githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+	}()
+
+	stored := GitHubToken{Token: "datastore-token", Secret: "datastore-secret"}
+	secret := GitHubToken{Token: "secret-token", Secret: "secret-secret"}
+	var datastoreToken *GitHubToken
+	loadGitHubToken = func(ctx context.Context) (GitHubToken, error) {
+		if datastoreToken == nil {
+			return GitHubToken{}, datastore.ErrNoSuchEntity
+		}
+		return *datastoreToken, nil
+	}
+	var names []string
+	loadSecretGitHubToken = func(ctx context.Context, name string) (GitHubToken, error) {
+		names = append(names, name)
+		return secret, nil
+	}
+	githubTokenSecret = "projects/i3-github-bot/secrets/github-token/versions/latest"
+
+	for _, tt := range []struct {
+		name      string
+		datastore *GitHubToken
+		prefer    bool
+		want      GitHubToken
+	}{
+		{name: "datastore empty", want: secret},
+		{name: "datastore populated", datastore: &stored, want: stored},
+		{name: "secret manager preferred", datastore: &stored, prefer: true, want: secret},
+	} {
+		datastoreToken, preferSecretManager = tt.datastore, tt.prefer
+		githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+		if err := getGitHubToken(context.Background()); err != nil {
+			t.Fatalf("%s: getGitHubToken: %v", tt.name, err)
+		}
+		if githubToken != tt.want {
+			t.Fatalf("%s: unexpected token: got %+v, want %+v", tt.name, githubToken, tt.want)
+		}
+	}
+
+	// The token is cached.
+	names = nil
+	if err := getGitHubToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("getGitHubToken read the secret %q again instead of using the cached token", names)
+	}
+}
+
+// slowIssues is an issueService whose label modifications block until the
+// context expires, like requests to an unresponsive GitHub API.
+type slowIssues struct {
+	*fakeIssues
+}
+
+func (s slowIssues) AddLabelsToIssue(ctx context.Context, owner string, repo string, number int, labels []string) ([]*github.Label, *github.Response, error) {
+	<-ctx.Done()
+	return nil, nil, ctx.Err()
+}
+
+func TestRequestBudget(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	ctx, cancel := withRequestBudget(context.Background(), cfg)
+	defer cancel()
+	if _, ok := ctx.Deadline(); !ok {
+		t.Fatalf("withRequestBudget(%d seconds): no deadline set", cfg.RequestBudgetSeconds)
+	}
+
+	cfg.RequestBudgetSeconds = 0
+	ctx, cancel = withRequestBudget(context.Background(), cfg)
+	defer cancel()
+	if deadline, ok := ctx.Deadline(); ok {
+		t.Fatalf("withRequestBudget(0 seconds): unexpected deadline %v", deadline)
+	}
+
+	// An issue event whose budget expires while talking to GitHub results in
+	// a 503, so that GitHub’s delivery can be retried.
+	ctx, cancel = context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	client := &apiClient{Issues: slowIssues{newFakeIssues()}}
+	handleIssuesEvent(ctx, rec, client, defaultConfig(), newIssuesEvent("i3 version 4.20 (2021-10-19) crashes"))
+	if got, want := rec.Code, http.StatusServiceUnavailable; got != want {
+		t.Fatalf("unexpected status: got %d, want %d", got, want)
+	}
+}
+
+// fakeRepositories implements repositoryService.
+type fakeRepositories struct {
+	collaborators map[string]bool
+
+	// statuses are the created commit statuses, keyed by ref.
+	statuses map[string][]*github.RepoStatus
+}
+
+func (f *fakeRepositories) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, *github.Response, error) {
+	return f.collaborators[user], fakeResponse(), nil
+}
+
+func (f *fakeRepositories) CreateStatus(ctx context.Context, owner, repo, ref string, status *github.RepoStatus) (*github.RepoStatus, *github.Response, error) {
+	if f.statuses == nil {
+		f.statuses = make(map[string][]*github.RepoStatus)
+	}
+	f.statuses[ref] = append(f.statuses[ref], status)
+	return status, fakeResponse(), nil
+}
+
+// fakePullRequests implements pullRequestService.
+type fakePullRequests struct {
+	files []string
+}
+
+func (f *fakePullRequests) ListFiles(ctx context.Context, owner string, repo string, number int, opts *github.ListOptions) ([]*github.CommitFile, *github.Response, error) {
+	var files []*github.CommitFile
+	for _, name := range f.files {
+		files = append(files, &github.CommitFile{Filename: github.String(name)})
+	}
+	return files, fakeResponse(), nil
+}
+
+func TestFileStatuses(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"file_statuses": {"i3/i3": [
+		{"pattern": "^src/config_parser\\.c$|^parser-specs/", "context": "i3-github-bot/parser",
+		 "description": "Parser changed: please run the parser tests.", "target_url": "https://i3wm.org/docs/hacking-howto.html"},
+		{"pattern": "^docs/", "description": "Documentation changed."}
+	]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	newEvent := func(action string) github.PullRequestEvent {
+		return github.PullRequestEvent{
+			Action: github.String(action),
+			Number: github.Int(2),
+			Repo: &github.Repository{
+				Name:  github.String("i3"),
+				Owner: &github.User{Login: github.String("i3")},
+			},
+			PullRequest: &github.PullRequest{Head: &github.PullRequestBranch{SHA: github.String("abc123")}},
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		cfg    *Config
+		action string
+		files  []string
+		want   []string // contexts
+	}{
+		{
+			name:   "parser modified",
+			cfg:    cfg,
+			action: "opened",
+			files:  []string{"src/handlers.c", "src/config_parser.c"},
+			want:   []string{"i3-github-bot/parser"},
+		},
+		{
+			name:   "parser and docs modified",
+			cfg:    cfg,
+			action: "synchronize",
+			files:  []string{"parser-specs/config.spec", "docs/userguide"},
+			want:   []string{"i3-github-bot/parser", "i3-github-bot"},
+		},
+		{
+			name:   "unrelated files",
+			cfg:    cfg,
+			action: "opened",
+			files:  []string{"src/handlers.c"},
+		},
+		{
+			name:   "closed",
+			cfg:    cfg,
+			action: "closed",
+			files:  []string{"src/config_parser.c"},
+		},
+		{
+			name:   "not configured",
+			cfg:    defaultConfig(),
+			action: "opened",
+			files:  []string{"src/config_parser.c"},
+		},
+	} {
+		repos := &fakeRepositories{}
+		client := &apiClient{Repositories: repos, PullRequests: &fakePullRequests{files: tt.files}}
+		handlePullRequestEvent(context.Background(), httptest.NewRecorder(), client, tt.cfg, newEvent(tt.action))
+		var got []string
+		for _, status := range repos.statuses["abc123"] {
+			if status.GetState() != "success" {
+				t.Fatalf("%s: unexpected state %q", tt.name, status.GetState())
+			}
+			got = append(got, status.GetContext())
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected statuses: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseConfig([]byte(`{"file_statuses": {"i3/i3": [{"pattern": "("}]}}`)); err == nil {
+		t.Fatal("parseConfig accepted an invalid pattern")
+	}
+}
+
+func TestReadAndVerifyBodyTooLarge(t *testing.T) {
+	t.Parallel()
+
+	newRequest := func(body io.Reader) *http.Request {
+		r := httptest.NewRequest("POST", "/issues", body)
+		r.Header.Set("X-GitHub-Event", "issues")
+		r.Header.Set("X-Hub-Signature", "sha1=0000000000000000000000000000000000000000")
+		return r
+	}
+
+	oversized := strings.Repeat("x", maxWebhookBodySize+1)
+	if _, _, err := readAndVerifyBody(newRequest(strings.NewReader(oversized))); err != errBodyTooLarge {
+		t.Fatalf("readAndVerifyBody(oversized body): got %v, want %v", err, errBodyTooLarge)
+	}
+
+	// Without Content-Length, the body must be rejected while reading.
+	r := newRequest(io.MultiReader(strings.NewReader(oversized)))
+	r.ContentLength = -1
+	if _, _, err := readAndVerifyBody(r); err != errBodyTooLarge {
+		t.Fatalf("readAndVerifyBody(oversized streamed body): got %v, want %v", err, errBodyTooLarge)
+	}
+
+	if got, want := verifyErrorStatus(errBodyTooLarge), http.StatusRequestEntityTooLarge; got != want {
+		t.Fatalf("unexpected status: got %d, want %d", got, want)
+	}
+
+	// Small bodies are read, but fail verification.
+	if _, _, err := readAndVerifyBody(newRequest(strings.NewReader("{}"))); err == nil || err == errBodyTooLarge {
+		t.Fatalf("readAndVerifyBody(small body): got %v, want signature error", err)
+	}
+}
+
+// withConfig makes handlers use cfg (instead of reading the configuration
+// from datastore) for the duration of the test. Tests using it must not run in
+// parallel.
+func withConfig(t *testing.T, cfg *Config) {
+	configMu.Lock()
+	old, oldLoaded := config, configLoaded
+	config, configLoaded = cfg, time.Now()
+	configMu.Unlock()
+	t.Cleanup(func() {
+		configMu.Lock()
+		config, configLoaded = old, oldLoaded
+		configMu.Unlock()
+	})
+}
+
+// newWebhookRequest returns a request for |event| with a valid signature
+// for githubToken.Secret.
+func newWebhookRequest(t *testing.T, event string, payload interface{}) *http.Request {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := hmac.New(sha1.New, []byte(githubToken.Secret))
+	h.Write(body)
+	r := httptest.NewRequest("POST", "/"+event, bytes.NewReader(body))
+	r.Header.Set("X-GitHub-Event", event)
+	r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(h.Sum(nil)))
+	return r
+}
+
+func TestSecretRotation(t *testing.T) {
+	defer func() { githubToken, githubTokenLoaded = GitHubToken{}, time.Time{} }()
+
+	body := []byte(`{"zen": "Design for failure."}`)
+	newRequest := func(secret string) *http.Request {
+		h := hmac.New(sha1.New, []byte(secret))
+		h.Write(body)
+		r := httptest.NewRequest("POST", "/issues", bytes.NewReader(body))
+		r.Header.Set("X-GitHub-Event", "ping")
+		r.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(h.Sum(nil)))
+		return r
+	}
+
+	for _, tt := range []struct {
+		token   GitHubToken
+		secret  string
+		wantErr bool
+	}{
+		{token: GitHubToken{Secret: "new"}, secret: "new"},
+		{token: GitHubToken{Secret: "new"}, secret: "old", wantErr: true},
+		{token: GitHubToken{Secret: "new", SecretPrevious: "old"}, secret: "new"},
+		{token: GitHubToken{Secret: "new", SecretPrevious: "old"}, secret: "old"},
+		{token: GitHubToken{Secret: "new", SecretPrevious: "old"}, secret: "other", wantErr: true},
+		// An empty previous secret does not accept deliveries signed with the
+		// empty secret.
+		{token: GitHubToken{Secret: "new"}, secret: "", wantErr: true},
+	} {
+		githubToken = tt.token
+		_, _, err := readAndVerifyBody(newRequest(tt.secret))
+		if gotErr := err != nil; gotErr != tt.wantErr {
+			t.Fatalf("token %+v, signed with %q: readAndVerifyBody = %v, want error: %v", tt.token, tt.secret, err, tt.wantErr)
+		}
+	}
+}
+
+func TestClientFor(t *testing.T) {
+	oldClientFor := clientFor
+	defer func() {
+		clientFor = oldClientFor
+		githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+	}()
+	githubToken, githubTokenLoaded = GitHubToken{Token: "token", Secret: "secret"}, time.Now()
+	withConfig(t, defaultConfig())
+
+	var owners []string
+	fake := newFakeIssues()
+	clientFor = func(ctx context.Context, owner string) (*apiClient, error) {
+		owners = append(owners, owner)
+		return &apiClient{Issues: fake, Repositories: &fakeRepositories{}}, nil
+	}
+
+	payload := newIssuesEvent("Please add a blink option", "enhancement")
+	payload.Repo.Owner.Login = github.String("other-org")
+	rec := httptest.NewRecorder()
+	issuesHandler(rec, newWebhookRequest(t, "issues", payload))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected HTTP status: got %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	rec = httptest.NewRecorder()
+	issueCommentHandler(rec, newWebhookRequest(t, "issue_comment", newIssueCommentEvent(payload, "someone", "+1")))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected HTTP status: got %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	if want := []string{"other-org", "other-org"}; !reflect.DeepEqual(owners, want) {
+		t.Fatalf("clientFor called with unexpected owners: got %q, want %q", owners, want)
+	}
+	if got, want := len(fake.comments[1]), 1; got != want {
+		t.Fatalf("unexpected number of comments: got %d, want %d", got, want)
+	}
+}
+
+// memCollaborators implements collaboratorCache in memory.
+type memCollaborators struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	entries map[string]memCollaborator
+}
+
+type memCollaborator struct {
+	collaborator bool
+	expires      time.Time
+}
+
+func newMemCollaborators() *memCollaborators {
+	return &memCollaborators{
+		now:     time.Now,
+		entries: make(map[string]memCollaborator),
+	}
+}
+
+func (m *memCollaborators) Get(ctx context.Context, key string) (bool, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || !m.now().Before(e.expires) {
+		return false, false
+	}
+	return e.collaborator, true
+}
+
+func (m *memCollaborators) Set(ctx context.Context, key string, collaborator bool, ttl time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memCollaborator{collaborator: collaborator, expires: m.now().Add(ttl)}
+}
+
+// withCollaborators replaces collaborators with cache for the duration of the
+// test. Tests using it must not run in parallel.
+func withCollaborators(t *testing.T, cache collaboratorCache) {
+	old := collaborators
+	collaborators = cache
+	t.Cleanup(func() { collaborators = old })
+}
+
+// countingRepositories implements repositoryService, counting the API
+// requests. If release is non-nil, requests block until it is closed.
+type countingRepositories struct {
+	fakeRepositories
+	release chan struct{}
+	err     error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingRepositories) IsCollaborator(ctx context.Context, owner, repo, user string) (bool, *github.Response, error) {
+	c.mu.Lock()
+	c.calls++
+	c.mu.Unlock()
+	if c.release != nil {
+		<-c.release
+	}
+	if c.err != nil {
+		return false, nil, c.err
+	}
+	return c.fakeRepositories.IsCollaborator(ctx, owner, repo, user)
+}
+
+func (c *countingRepositories) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls
+}
+
+func TestIsCollaborator(t *testing.T) {
+	cache := newMemCollaborators()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	withCollaborators(t, cache)
+
+	repos := &countingRepositories{
+		fakeRepositories: fakeRepositories{collaborators: map[string]bool{"maintainer": true}},
+	}
+	client := &apiClient{Repositories: repos}
+	check := func(login string, want bool, wantCalls int) {
+		t.Helper()
+		got, err := isCollaborator(context.Background(), client, "i3", "i3", login)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("isCollaborator(%q) = %v, want %v", login, got, want)
+		}
+		if got := repos.count(); got != wantCalls {
+			t.Fatalf("isCollaborator(%q): unexpected number of API requests: got %d, want %d", login, got, wantCalls)
+		}
+	}
+
+	check("maintainer", true, 1)
+	check("maintainer", true, 1) // cache hit
+	check("bystander", false, 2)
+	check("bystander", false, 2) // cache hit
+
+	// Negative results expire first.
+	now = now.Add(nonCollaboratorTTL + time.Second)
+	check("bystander", false, 3)
+	check("maintainer", true, 3)
+
+	now = now.Add(collaboratorTTL)
+	check("maintainer", true, 4)
+
+	// Concurrent lookups of the same user result in one API request.
+	repos = &countingRepositories{
+		fakeRepositories: fakeRepositories{collaborators: map[string]bool{"maintainer": true}},
+		release:          make(chan struct{}),
+	}
+	client = &apiClient{Repositories: repos}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if collaborator, err := isCollaborator(context.Background(), client, "i3", "i3status", "maintainer"); err != nil || !collaborator {
+				t.Errorf("isCollaborator = %v, %v, want true, nil", collaborator, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond) // let the lookups start
+	close(repos.release)
+	wg.Wait()
+	if got, want := repos.count(), 1; got != want {
+		t.Fatalf("unexpected number of API requests: got %d, want %d", got, want)
+	}
+}
+
+func TestIsCollaboratorBackoff(t *testing.T) {
+	cache := newMemCollaborators()
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+	withCollaborators(t, cache)
+	oldNow, oldBackoffs := collaboratorNow, collaboratorBackoffs
+	collaboratorNow = func() time.Time { return now }
+	collaboratorBackoffs = make(map[string]*collaboratorBackoff)
+	t.Cleanup(func() { collaboratorNow, collaboratorBackoffs = oldNow, oldBackoffs })
+
+	repos := &countingRepositories{
+		fakeRepositories: fakeRepositories{collaborators: map[string]bool{"maintainer": true}},
+		err:              errors.New("502 Bad Gateway"),
+	}
+	client := &apiClient{Repositories: repos}
+	check := func(wantErr bool, wantCalls int) {
+		t.Helper()
+		_, err := isCollaborator(context.Background(), client, "i3", "i3", "maintainer")
+		if gotErr := err != nil; gotErr != wantErr {
+			t.Fatalf("isCollaborator: got err %v, want error: %v", err, wantErr)
+		}
+		if got := repos.count(); got != wantCalls {
+			t.Fatalf("unexpected number of API requests: got %d, want %d", got, wantCalls)
+		}
+	}
+
+	check(true, 1)
+	check(true, 1) // backing off
+	now = now.Add(collaboratorMinBackoff)
+	check(true, 2)
+	// The delay doubles with each consecutive failure.
+	now = now.Add(collaboratorMinBackoff)
+	check(true, 2)
+	now = now.Add(collaboratorMinBackoff)
+	check(true, 3)
+	now = now.Add(3 * collaboratorMinBackoff)
+	check(true, 3)
+	now = now.Add(collaboratorMinBackoff)
+	repos.err = nil
+	check(false, 4)
+
+	// A successful lookup resets the backoff.
+	now = now.Add(collaboratorTTL + time.Second)
+	repos.err = errors.New("502 Bad Gateway")
+	check(true, 5)
+	now = now.Add(collaboratorMinBackoff)
+	check(true, 6)
+
+	// The delay is capped.
+	for i := 0; i < 20; i++ {
+		now = now.Add(collaboratorMaxBackoff)
+		check(true, 7+i)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	t.Parallel()
+
+	issue := newIssuesEvent("i3 crashes all the time", "missing-log")
+	repos := &fakeRepositories{
+		collaborators: map[string]bool{"maintainer": true},
+	}
+
+	for _, tt := range []struct {
+		name    string
+		payload github.IssueCommentEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "collaborator",
+			payload: newIssueCommentEvent(issue, "maintainer", "Thanks!\n/label needs-reproduction\n/unlabel missing-log\n/close not_planned\n/frobnicate"),
+			want: issueOutcome{
+				added:   []string{"needs-reproduction"},
+				removed: []string{"missing-log"},
+				closed:  true,
+			},
+		},
+
+		{
+			name:    "non-collaborator",
+			payload: newIssueCommentEvent(issue, "bystander", "/label needs-reproduction\n/close"),
+			want:    issueOutcome{},
+		},
+
+		{
+			name:    "command not on its own line",
+			payload: newIssueCommentEvent(issue, "maintainer", "Please do not /close this"),
+			want:    issueOutcome{},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			rec := httptest.NewRecorder()
+			handleIssueCommentEvent(context.Background(), rec, &apiClient{Issues: fake, Repositories: repos}, defaultConfig(), tt.payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecheckCommand(t *testing.T) {
+	t.Parallel()
+
+	repos := &fakeRepositories{
+		collaborators: map[string]bool{"maintainer": true},
+	}
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	body := "i3 version 4.20 (2021-10-19), log: " + logLink
+
+	for _, tt := range []struct {
+		name  string
+		issue github.IssuesEvent
+		want  issueOutcome
+	}{
+		{
+			name:  "bug report",
+			issue: newIssuesEvent(body, "missing-version", "missing-log"),
+			want: issueOutcome{
+				added:   []string{"4.20"},
+				removed: []string{"missing-log", "missing-version"},
+			},
+		},
+
+		{
+			name:  "feature request",
+			issue: newIssuesEvent(body, "enhancement", "missing-version", "missing-log"),
+			want:  issueOutcome{},
+		},
+
+		{
+			name:  "documentation request",
+			issue: newIssuesEvent(body, "documentation", "missing-version", "missing-log"),
+			want:  issueOutcome{},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = milestones
+			rec := httptest.NewRecorder()
+			payload := newIssueCommentEvent(tt.issue, "maintainer", "/recheck")
+			handleIssueCommentEvent(context.Background(), rec, &apiClient{Issues: fake, Repositories: repos}, defaultConfig(), payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBucketFromEnv(t *testing.T) {
+	t.Setenv("LOGS_BUCKET", "")
+	if got, want := bucketFromEnv(), defaultBucket; got != want {
+		t.Fatalf("unexpected bucket: got %q, want %q", got, want)
+	}
+	t.Setenv("LOGS_BUCKET", "i3-github-bot-staging")
+	if got, want := bucketFromEnv(), "i3-github-bot-staging"; got != want {
+		t.Fatalf("unexpected bucket: got %q, want %q", got, want)
+	}
+}
+
+func TestVersionRPM(t *testing.T) {
+	t.Parallel()
+
+	body := `
+$ rpm -q i3
+i3-4.20.1-1.fc38.x86_64
+`
+	matches := extractVersion(body)
+	if len(matches) < 4 || matches[1] != "i3" || matches[2] != "4.20" || matches[3] != "4.20.1" {
+		t.Fatalf("rpm -q output not recognized properly, matches = %+v", matches)
+	}
+}
+
+func TestVersionDebianPackage(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body     string
+		wantFull string
+	}{
+		{body: "i3-wm 4.23-1", wantFull: "4.23"},
+		{body: "i3 4.23.1-1ubuntu1", wantFull: "4.23.1"},
+		{body: "$ dpkg -l i3-wm\nii  i3-wm          4.23-1       amd64        improved dynamic tiling window manager", wantFull: "4.23"},
+	} {
+		matches := extractVersion(tt.body)
+		if len(matches) < 4 || matches[1] != "i3" || matches[2] != "4.23" || matches[3] != tt.wantFull {
+			t.Fatalf("%q not recognized properly, matches = %+v", tt.body, matches)
+		}
+	}
+}
+
+func TestVersionMarkdownTable(t *testing.T) {
+	t.Parallel()
+
+	const table = `### Environment
+
+| Component | Version |
+|-----------|---------|
+| i3 version | 4.23 |
+| i3status | ` + "`2.14`" + ` |
+| Distribution | Arch Linux 2024.01.01 |
+| Xorg | 21.1.11 |
+`
+	if got, want := extractProgramVersion(table, "i3"), []string{"", "i3", "4.23", "4.23"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractProgramVersion(table) = %q, want %q", got, want)
+	}
+	if got, want := extractVersions(table), map[string]string{"i3": "4.23", "i3status": "2.14"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractVersions(table) = %v, want %v", got, want)
+	}
+
+	for _, body := range []string{
+		// Without the row’s version, the next row must not be used.
+		"| i3 version | |\n| Xorg | 21.1 |\n",
+		"| i3 version |\n| 21.1 |\n",
+		"| i3 |\n4.23\n",
+	} {
+		if got := extractVersion(body); len(got) != 0 {
+			t.Fatalf("extractVersion(%q) = %q, want no version", body, got)
+		}
+	}
+
+	rows := []string{
+		"| i3 version | 4.23 (2023-10-24) |",
+		"i3 | v4.23",
+		"|i3|4.23.1|",
+		"| i3 | version 4.23 |",
+	}
+	for _, row := range rows {
+		if got := extractVersion(row); len(got) < 4 || got[1] != "i3" || got[2] != "4.23" {
+			t.Fatalf("extractVersion(%q) = %q, want i3 4.23", row, got)
+		}
+	}
+}
+
+func TestVersionCrashBacktrace(t *testing.T) {
+	t.Parallel()
+
+	const crash = `i3 crashed on me when moving a window to another output.
+
+` + "```" + `
+Program received signal SIGSEGV, Segmentation fault.
+#0  0x00007f3a1c2b1e57 in __strlen_avx2 () from /usr/lib/libc.so.6
+#1  0x0000555f2a1d3c41 in con_move_to_output (con=0x555f2b0e4a30, output=0x0) at ../i3-4.23/src/con.c:1472
+#2  0x0000555f2a1f0a12 in cmd_move_con_to_output (current_match=…) at ../i3-4.23/src/commands.c:1081
+#3  0x0000555f2a1c2b3f in main (argc=1, argv=0x7ffd6a3b0c48) at ../i3-4.23/src/main.c:1040
+` + "```" + `
+`
+	if got, want := extractProgramVersion(crash, "i3"), []string{"", "i3", "4.23", "4.23"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("extractProgramVersion(crash) = %q, want %q", got, want)
+	}
+	fake := newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(crash))
+	for _, label := range outcome(fake).added {
+		if label == "missing-version" {
+			t.Fatalf("crash report labeled missing-version (outcome %+v)", outcome(fake))
+		}
+	}
+
+	for _, frame := range []string{
+		"#1  0x0000555f2a1d3c41 in tree_render () at /build/i3-wm-4.22/build/../src/render.c:42",
+		"#4  0x0000555f2a1c2b3f in main () at ../i3-4.22-51-g9a4c6b4/src/main.c:1040",
+		"#2  0x0000555f2a1c2b3f in child_handle_button () at ../../i3-4.22.1/i3bar/src/child.c:517",
+	} {
+		if got := extractVersion(frame); len(got) < 4 || got[1] != "i3" || got[2] != "4.22" {
+			t.Fatalf("extractVersion(%q) = %q, want i3 4.22", frame, got)
+		}
+	}
+
+	for _, body := range []string{
+		// Not a backtrace frame.
+		"I built ../i3-4.23/src/main.c myself",
+		// Not in i3’s source layout.
+		"#1  0x00007f3a1c2b1e57 in xcb_wait_for_reply () at ../libxcb-1.15/src/xcb_in.c:4",
+		"#1  0x00007f3a1c2b1e57 in foo () at ../i3-4.23.tar.gz",
+	} {
+		if got := extractVersion(body); len(got) != 0 {
+			t.Fatalf("extractVersion(%q) = %q, want no version", body, got)
+		}
+	}
+}
+
+func TestVersionOtherPrograms(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body    string
+		program string
+		want    []string
+	}{
+		{
+			body:    "i3status 2.13 © 2008 Michael Stapelberg and contributors",
+			program: "i3status",
+			want:    []string{"", "i3status", "2.13", "2.13"},
+		},
+		{
+			body:    "$ i3bar --version\ni3bar version 4.22 (2023-01-02) © 2010 Axel Wagner and contributors",
+			program: "i3",
+			want:    []string{"", "i3bar", "4.22", "4.22"},
+		},
+		{
+			body:    "i3lock: version 2.15 © 2010 Michael Stapelberg",
+			program: "i3lock",
+			want:    []string{"", "i3lock", "2.15", "2.15"},
+		},
+	} {
+		if got := extractProgramVersion(tt.body, tt.program); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%q not recognized properly: got %q, want %q", tt.body, got, tt.want)
+		}
+	}
+
+	// i3bar is released with i3, so its version is verified like i3’s.
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.22")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(),
+		newIssuesEvent("i3bar version 4.22 (2023-01-02) draws over my windows, see "+logLink))
+	if got, want := outcome(fake), (issueOutcome{added: []string{"4.22"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestExplainVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body           string
+		program        string
+		normalizations []string
+		candidates     []string
+		reason         string
+	}{
+		{
+			body:           "$ rpm -q i3\ni3-4.20.1-1.fc38.x86_64\ni3 version 4.19",
+			program:        "i3",
+			normalizations: []string{`read RPM package "i3-4.20.1-1.fc38.x86_64"`},
+			candidates:     []string{"i3 4.20.1", "i3 4.19"},
+			reason:         "highest i3 version",
+		},
+		{
+			body:       "i3lock version 2.13, i3status version 2.14",
+			program:    "i3",
+			candidates: []string{"i3lock 2.13", "i3status 2.14"},
+			reason:     `multiple programs, none of which is "i3": used the first candidate`,
+		},
+		{
+			body:    "it crashes",
+			program: "i3",
+			reason:  "no version found",
+		},
+		{
+			body:           "$ rpm -q i3\ni3-4.18-1.fc33.x86_64\n$ i3 --moreversion\nRunning i3 version: 4.23 (pid 1234)",
+			program:        "i3",
+			normalizations: []string{`read RPM package "i3-4.18-1.fc33.x86_64"`},
+			candidates:     []string{"i3 4.18", "i3 4.23"},
+			reason:         "running i3 version",
+		},
+	} {
+		_, d := explainProgramVersion(tt.body, tt.program)
+		if !reflect.DeepEqual(d.Normalizations, tt.normalizations) ||
+			!reflect.DeepEqual(d.Candidates, tt.candidates) ||
+			d.Reason != tt.reason {
+			t.Fatalf("%q: unexpected decision %v", tt.body, d)
+		}
+	}
+}
+
+func TestRunningVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body string
+		want []string
+	}{
+		{
+			// The package is stale, i3 runs a newer version.
+			body: "ii  i3-wm  4.18-1  amd64  improved dynamic tiling window manager\n" +
+				"Binary i3 version:  4.23 (2023-10-29) © 2009 Michael Stapelberg and contributors\n" +
+				"Running i3 version: 4.23 (2023-10-29) (pid 1234)",
+			want: []string{"", "i3", "4.23", "4.23"},
+		},
+		{
+			// The running version is preferred even if it is lower.
+			body: "i3 version 4.23 (2023-10-29)\nRunning i3 version: 4.22 (pid 1234)",
+			want: []string{"", "i3", "4.22", "4.22"},
+		},
+		{
+			// Of multiple pastes, the last one counts.
+			body: "Running i3 version: 4.22 (pid 1234)\nafter restarting:\nRunning i3 version: 4.23.1 (pid 1235)",
+			want: []string{"", "i3", "4.23", "4.23.1"},
+		},
+		{
+			// Without a running version, the highest version is used.
+			body: "ii  i3-wm  4.18-1  amd64\ni3 version 4.23",
+			want: []string{"", "i3", "4.23", "4.23"},
+		},
+	} {
+		if got := extractVersion(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%q not recognized properly: got %q, want %q", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestIsDevBuild(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body string
+		want bool
+	}{
+		{`i3 version 4.23 (2023-10-24, branch "next")`, true},
+		{`i3 version 4.10.1 (2015-03-29, branch "master")`, true},
+		{`i3 version 4.22 (2023-01-02, branch "my-fix")`, true},
+		{`i3 version 4.20.1-51-g9a4c6b4`, true},
+		{`i3 version 4.23 (2023-10-24, branch "4.23")`, false},
+		{`i3 version 4.22.1 (2023-01-02, branch "4.22.1")`, false},
+		{`i3 version 4.23`, false},
+	} {
+		if got := isDevBuild(tt.body); got != tt.want {
+			t.Fatalf("isDevBuild(%q) = %v, want %v", tt.body, got, tt.want)
+		}
+	}
+}
+
+func TestCollapsibleSections(t *testing.T) {
+	t.Parallel()
+
+	body := `Moving a floating window to another output crashes i3.
+
+<details><summary>i3 --version</summary>i3 version 4.20 (2021-10-19) © 2009 Michael Stapelberg and contributors</details>
+<details>
+<summary>Log</summary>
+
+` + logLink + `</details>`
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(body))
+	if got, want := outcome(fake), (issueOutcome{added: []string{"4.20"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+
+	if got, want := defaultConfig().matchText("<details><summary>Version</summary>i3 4.20</DETAILS>"), "\n\nVersion\ni3 4.20\n"; got != want {
+		t.Fatalf("matchText = %q, want %q", got, want)
+	}
+}
+
+func TestDateOnlyVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		text string
+		want bool
+	}{
+		{text: "i3 version (2023-10-24) © 2009 Michael Stapelberg and contributors", want: true},
+		{text: "$ i3 --version\ni3 version 2023-10-24", want: true},
+		{text: "i3 version 4.23 (2023-10-24) © 2009 Michael Stapelberg and contributors", want: false},
+		{text: "i3 crashed on 2023-10-24", want: false},
+	} {
+		if got := reDateOnlyVersion.MatchString(tt.text); got != tt.want {
+			t.Fatalf("reDateOnlyVersion.MatchString(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+
+	fake := newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(),
+		newIssuesEvent("i3 version (2023-10-24) © 2009 Michael Stapelberg and contributors\ncrashes, see "+logLink))
+	if got, want := fake.added[1], []string{"packaging-issue"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %v, want %v", got, want)
+	}
+	if got, want := fake.comments[1], []string{dateOnlyVersionComment}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected comments: got %q, want %q", got, want)
+	}
+}
+
+func TestTruncatedVersion(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body         string
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			body:         "i3 version 4\ncrashes, see " + logLink,
+			wantAdded:    []string{"needs-full-version"},
+			wantComments: []string{truncatedVersionComment},
+		},
+		{
+			body:         "crashes, see " + logLink + "\ni3 version 4.",
+			wantAdded:    []string{"needs-full-version"},
+			wantComments: []string{truncatedVersionComment},
+		},
+		{
+			body:         "i3 crashes 4 times a day, see " + logLink,
+			wantAdded:    []string{"missing-version"},
+			wantComments: []string{missingVersionComment},
+		},
+	} {
+		fake := newFakeIssues()
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(tt.body))
+		if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+			t.Fatalf("%q: unexpected labels: got %v, want %v", tt.body, got, tt.wantAdded)
+		}
+		if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+			t.Fatalf("%q: unexpected comments: got %q, want %q", tt.body, got, tt.wantComments)
+		}
+	}
+
+	// Providing the full version later removes the label.
+	issue := newIssuesEvent("i3 version 4.\ncrashes, see "+logLink, "needs-full-version")
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(),
+		newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19)"))
+	want := issueOutcome{added: []string{"4.20"}, removed: []string{"needs-full-version"}}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestSupportedVersions(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"supported_versions": {"i3/i3": ["4.22"]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	milestones := []*github.Milestone{{Title: github.String("4.23")}}
+
+	for _, tt := range []struct {
+		name string
+		cfg  *Config
+		body string
+		want issueOutcome
+	}{
+		{
+			name: "supported",
+			cfg:  cfg,
+			body: "i3 version 4.22 (2023-01-02) crashes, see " + logLink,
+			want: issueOutcome{},
+		},
+		{
+			name: "not configured",
+			cfg:  defaultConfig(),
+			body: "i3 version 4.22 (2023-01-02) crashes, see " + logLink,
+			want: issueOutcome{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		},
+		{
+			name: "other version",
+			cfg:  cfg,
+			body: "i3 version 4.21 (2022-09-20) crashes, see " + logLink,
+			want: issueOutcome{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, newIssuesEvent(tt.body))
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	// The override is also consulted when the version is provided in a
+	// comment.
+	issue := newIssuesEvent("i3 crashes, see "+logLink, "missing-version")
+	fake := newFakeIssues()
+	fake.milestones = milestones
+	handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg,
+		newIssueCommentEvent(issue, "reporter", "i3 version 4.22.1"))
+	want := issueOutcome{removed: []string{"missing-version"}}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestSupportedVersionsNotLatest(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{
+  "supported_versions": {"i3/i3": ["4.22"]},
+  "next_milestone_patterns": {"i3/i3": "^4\\.[0-9]+$"}
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	milestones := []*github.Milestone{
+		{Title: github.String("4.23"), Number: github.Int(23), State: github.String("closed")},
+		{Title: github.String("4.24"), Number: github.Int(24), State: github.String("open")},
+	}
+
+	for _, tt := range []struct {
+		body      string
+		wantLabel []string
+		milestone *int
+	}{
+		// An accepted older version is neither labeled as the latest
+		// release nor assigned to the next release’s milestone.
+		{body: "i3 version 4.22.1 (2023-01-02) crashes, see " + logLink},
+		{body: "i3 version 4.23 (2023-10-24) crashes, see " + logLink, wantLabel: []string{"4.23"}, milestone: github.Int(24)},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(tt.body))
+		if got := outcome(fake); !reflect.DeepEqual(got, issueOutcome{added: tt.wantLabel}) {
+			t.Fatalf("%q: unexpected outcome: got %+v, want labels %q", tt.body, got, tt.wantLabel)
+		}
+		var got *int
+		for _, edit := range fake.edits[1] {
+			if edit.Milestone != nil {
+				got = edit.Milestone
+			}
+		}
+		if !reflect.DeepEqual(got, tt.milestone) {
+			t.Fatalf("%q: unexpected milestone: got %v, want %v", tt.body, got, tt.milestone)
+		}
+	}
+}
+
+func TestReleasedMilestonePatterns(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"released_milestone_patterns": {"i3/i3": "^\\d+\\.\\d+$"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Ordered by due date, as GitHub returns them: the spurious “Backlog”
+	// milestone was closed most recently, and 4.21 is still open.
+	milestones := []*github.Milestone{
+		{Title: github.String("Backlog"), State: github.String("closed")},
+		{Title: github.String("4.3"), State: github.String("closed")},
+		{Title: github.String("4.21"), State: github.String("open")},
+		{Title: github.String("4.20"), State: github.String("closed")},
+	}
+
+	for _, tt := range []struct {
+		name string
+		cfg  *Config
+		body string
+		want issueOutcome
+	}{
+		{
+			name: "latest",
+			cfg:  cfg,
+			body: "i3 version 4.21 (2022-09-20) crashes, see " + logLink,
+			want: issueOutcome{added: []string{"4.21"}},
+		},
+		{
+			name: "older",
+			cfg:  cfg,
+			body: "i3 version 4.20 (2021-10-19) crashes, see " + logLink,
+			want: issueOutcome{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		},
+		{
+			name: "not configured",
+			cfg:  defaultConfig(),
+			body: "i3 version 4.21 (2022-09-20) crashes, see " + logLink,
+			want: issueOutcome{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, newIssuesEvent(tt.body))
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseConfig([]byte(`{"released_milestone_patterns": {"i3/i3": "("}}`)); err == nil {
+		t.Fatal("parseConfig accepted an invalid pattern")
+	}
+}
+
+func TestLabelComments(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"label_comments": {"needs-config": "@{{.Author}}, please include your config ({{.Label}})."}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeIssues()
+	client := &apiClient{Issues: fake}
+	payload := newIssuesEvent("i3 crashes")
+
+	if !addLabel(context.Background(), client, cfg, payload, httptest.NewRecorder(), "needs-config") {
+		t.Fatal("addLabel(needs-config) = false, want true")
+	}
+	want := []string{"@reporter, please include your config (needs-config).\n\n" + labelCommentMarker("needs-config")}
+	if got := fake.comments[1]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected comments: got %q, want %q", got, want)
+	}
+
+	// Adding the label again (e.g. after a maintainer removed it) does not
+	// repeat the comment.
+	addLabel(context.Background(), client, cfg, payload, httptest.NewRecorder(), "needs-config")
+	// Labels without a template do not result in a comment.
+	addLabel(context.Background(), client, cfg, payload, httptest.NewRecorder(), "missing-log")
+	if got := fake.comments[1]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected comments: got %q, want %q", got, want)
+	}
+	if got, want := fake.added[1], []string{"needs-config", "needs-config", "missing-log"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %q, want %q", got, want)
+	}
+
+	if _, err := parseConfig([]byte(`{"label_comments": {"needs-config": "{{.Author"}}`)); err == nil {
+		t.Fatal("parseConfig accepted an invalid template")
+	}
+}
+
+func TestCommentDedupe(t *testing.T) {
+	t.Parallel()
+
+	bot := &github.User{Login: github.String("i3bot")}
+	app := &github.User{Login: github.String("i3-bot[bot]"), Type: github.String("Bot")}
+	reporter := &github.User{Login: github.String("reporter")}
+	// The bot asked for a log in reply to the issue body, before the reporter
+	// commented.
+	prior := strings.Replace(missingLogComment, " ", "  ", 3)
+
+	for _, tt := range []struct {
+		name     string
+		lookback int
+		existing []*github.IssueComment
+		comment  string
+		want     int
+	}{
+		{
+			name:     "similar bot comment",
+			lookback: 5,
+			existing: []*github.IssueComment{{User: bot, Body: github.String(prior)}, {User: reporter, Body: github.String("ok")}},
+			comment:  missingLogComment,
+			want:     0,
+		},
+		{
+			name:     "similar app comment",
+			lookback: 5,
+			existing: []*github.IssueComment{{User: app, Body: github.String(missingLogComment + " Thanks!")}},
+			comment:  missingLogComment,
+			want:     0,
+		},
+		{
+			name:     "different bot comment",
+			lookback: 5,
+			existing: []*github.IssueComment{{User: bot, Body: github.String(prior)}},
+			comment:  missingVersionComment,
+			want:     1,
+		},
+		{
+			name:     "similar comment by somebody else",
+			lookback: 5,
+			existing: []*github.IssueComment{{User: reporter, Body: github.String(missingLogComment)}},
+			comment:  missingLogComment,
+			want:     1,
+		},
+		{
+			name:     "outside of lookback",
+			lookback: 1,
+			existing: []*github.IssueComment{{User: bot, Body: github.String(prior)}, {User: reporter, Body: github.String("ok")}},
+			comment:  missingLogComment,
+			want:     1,
+		},
+		{
+			name:     "disabled",
+			lookback: 0,
+			existing: []*github.IssueComment{{User: bot, Body: github.String(prior)}},
+			comment:  missingLogComment,
+			want:     1,
+		},
+	} {
+		cfg := defaultConfig()
+		cfg.BotLogin = "i3bot"
+		cfg.CommentDedupeLookback = tt.lookback
+		fake := newFakeIssues()
+		fake.existingComments = map[int][]*github.IssueComment{1: tt.existing}
+		payload := newIssuesEvent("i3 crashes")
+		payload.Issue.Comments = github.Int(len(tt.existing))
+		if !addComment(context.Background(), &apiClient{Issues: fake}, cfg, payload, httptest.NewRecorder(), tt.comment) {
+			t.Fatalf("%s: addComment unexpectedly failed", tt.name)
+		}
+		if got := len(fake.comments[1]); got != tt.want {
+			t.Fatalf("%s: unexpected number of comments: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	if got := defaultConfig().CommentDedupeLookback; got != 0 {
+		t.Fatalf("default comment_dedupe_lookback = %d, want 0 (disabled)", got)
+	}
+}
+
+// memCloseCounter implements closeCounter in memory.
+type memCloseCounter struct {
+	mu     sync.Mutex
+	counts map[time.Time]uint64
+}
+
+func (m *memCloseCounter) Increment(ctx context.Context, window time.Time) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[window]++
+	return m.counts[window], nil
+}
+
+func (m *memCloseCounter) Decrement(ctx context.Context, window time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.counts[window] > 0 {
+		m.counts[window]--
+	}
+	return nil
+}
+
+// failingEdits is a fakeIssues whose Edit requests fail.
+type failingEdits struct {
+	*fakeIssues
+}
+
+func (f failingEdits) Edit(ctx context.Context, owner string, repo string, number int, issue *github.IssueRequest) (*github.Issue, *github.Response, error) {
+	return nil, nil, errors.New("502 Bad Gateway")
+}
+
+// withAutoCloses replaces autoCloses with counter for the duration of the
+// test. Tests using it must not run in parallel.
+func withAutoCloses(t *testing.T, counter closeCounter) {
+	old := autoCloses
+	autoCloses = counter
+	t.Cleanup(func() { autoCloses = old })
+}
+
+func TestAutoCloseThrottle(t *testing.T) {
+	counter := &memCloseCounter{counts: make(map[time.Time]uint64)}
+	withAutoCloses(t, counter)
+	type autoCloseTask struct {
+		number          int
+		version, latest string
+	}
+	var enqueued []autoCloseTask
+	withEnqueueAutoClose(t, func(ctx context.Context, owner, repo string, number int, version, latest string, delay time.Duration) error {
+		if delay <= 0 || delay > autoCloseWindow {
+			t.Errorf("unexpected auto-close delay %v", delay)
+		}
+		enqueued = append(enqueued, autoCloseTask{number, version, latest})
+		return nil
+	})
+	cfg := defaultConfig()
+	cfg.MaxAutoClosesPerHour = 2
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	const body = "i3 version 4.18 crashes, see " + logLink
+
+	// A failed close does not count against the limit, and does not leave
+	// a comment asking to re-open the (still open) issue.
+	fake := newFakeIssues()
+	fake.milestones = milestones
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: failingEdits{fake}}, cfg,
+		newIssuesEvent(body))
+	if got, want := outcome(fake), (issueOutcome{added: []string{"unsupported-version"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("failed close: unexpected outcome: got %+v, want %+v", got, want)
+	}
+
+	want := []issueOutcome{
+		{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		{added: []string{"unsupported-version"}, comments: 1, closed: true},
+		// The third close within the hour is deferred.
+		{added: []string{"unsupported-version", autoCloseDeferredLabel}},
+	}
+	for i, want := range want {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg,
+			newIssuesEvent(body))
+		if got := outcome(fake); !reflect.DeepEqual(got, want) {
+			t.Fatalf("issue %d: unexpected outcome: got %+v, want %+v", i+1, got, want)
+		}
+	}
+	if want := []autoCloseTask{{1, "4.18", "4.20"}}; !reflect.DeepEqual(enqueued, want) {
+		t.Fatalf("unexpected auto-close tasks: got %+v, want %+v", enqueued, want)
+	}
+
+	// In the next window, the deferred issue is closed. Its version may have
+	// been reported in a comment, so the issue body is not evaluated again.
+	for window := range counter.counts {
+		counter.counts[window] = 0
+	}
+	deferredIssue := func(state string, labels ...string) *github.Issue {
+		issue := newIssuesEvent("i3 crashes", labels...).Issue
+		issue.State = github.String(state)
+		return issue
+	}
+	for _, tt := range []struct {
+		name  string
+		issue *github.Issue
+		want  issueOutcome
+	}{
+		{
+			name:  "deferred",
+			issue: deferredIssue("open", "unsupported-version", autoCloseDeferredLabel),
+			want:  issueOutcome{removed: []string{autoCloseDeferredLabel}, comments: 1, closed: true},
+		},
+		{
+			name:  "deferral removed by maintainers",
+			issue: deferredIssue("open", "unsupported-version"),
+		},
+		{
+			name:  "closed in the meantime",
+			issue: deferredIssue("closed", "unsupported-version", autoCloseDeferredLabel),
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		fake.issues = []*github.Issue{tt.issue}
+		rec := httptest.NewRecorder()
+		autoCloseDeferred(context.Background(), rec, &apiClient{Issues: fake}, cfg, "i3", "i3", 1, "4.18", "4.20")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected HTTP status: got %d (%s), want %d", tt.name, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+		if tt.want.closed && !strings.Contains(fake.comments[1][0], "from 4.18 to 4.20") {
+			t.Fatalf("%s: unexpected comment %q", tt.name, fake.comments[1][0])
+		}
+	}
+}
+
+func TestWrongProject(t *testing.T) {
+	t.Parallel()
+
+	swayComment := defaultWrongProjects["i3/i3"][0].Comment
+	for _, tt := range []struct {
+		name         string
+		payload      github.IssuesEvent
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name: "sway issue",
+			payload: newIssuesEvent(`Windows flicker when switching workspaces.
+
+$ sway --version
+sway version 1.8.1
+
+I am using wlroots 0.16 and the config from ~/.config/sway/config, see ` + logLink),
+			wantAdded:    []string{"wrong-project"},
+			wantComments: []string{swayComment},
+		},
+		{
+			name: "comparison with sway",
+			payload: newIssuesEvent(`i3 version 4.20 (2021-10-19): floating windows are not centered.
+This works in sway (and other Wayland compositors), but not in i3. Log: ` + logLink),
+			wantAdded: []string{"4.20"},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+			handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), tt.payload)
+			if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+				t.Fatalf("unexpected labels: got %v, want %v", got, tt.wantAdded)
+			}
+			if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+				t.Fatalf("unexpected comments: got %q, want %q", got, tt.wantComments)
+			}
+		})
+	}
+}
+
+func TestTriageRules(t *testing.T) {
+	t.Parallel()
+
+	const rules = `"triage_rules": {"i3/i3": [
+		{"pattern": "nvidia.*(?:proprietary|[0-9]{3}\\.[0-9]+)", "label": "nvidia", "comment": "Please try the nouveau driver."},
+		{"pattern": "\\btearing\\b", "label": "tearing"}
+	]}`
+	const body = "i3 version 4.20 (2021-10-19): screen tearing with the NVIDIA 525.60 driver, see " + logLink
+	for _, tt := range []struct {
+		name         string
+		config       string
+		wantAdded    []string
+		wantComments []string
+	}{
+		{
+			name:         "first match",
+			config:       `{` + rules + `}`,
+			wantAdded:    []string{"nvidia", "4.20"},
+			wantComments: []string{"Please try the nouveau driver."},
+		},
+		{
+			name:         "all matches",
+			config:       `{"triage_rules_apply_all": true, ` + rules + `}`,
+			wantAdded:    []string{"nvidia", "tearing", "4.20"},
+			wantComments: []string{"Please try the nouveau driver."},
+		},
+		{
+			name:      "other repository",
+			config:    `{"triage_rules": {"i3/i3status": [{"pattern": "nvidia", "label": "nvidia"}]}}`,
+			wantAdded: []string{"4.20"},
+		},
+	} {
+		cfg, err := parseConfig([]byte(tt.config))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(body))
+		if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+			t.Fatalf("%s: unexpected labels: got %v, want %v", tt.name, got, tt.wantAdded)
+		}
+		if got := fake.comments[1]; !reflect.DeepEqual(got, tt.wantComments) {
+			t.Fatalf("%s: unexpected comments: got %q, want %q", tt.name, got, tt.wantComments)
+		}
+	}
+
+	for _, config := range []string{
+		`{"triage_rules": {"i3/i3": [{"pattern": "(", "label": "broken"}]}}`,
+		`{"triage_rules": {"i3/i3": [{"pattern": "nvidia"}]}}`,
+	} {
+		if _, err := parseConfig([]byte(config)); err == nil {
+			t.Fatalf("parseConfig(%s) unexpectedly succeeded", config)
+		}
+	}
+}
+
+func TestTransferredIssue(t *testing.T) {
+	t.Parallel()
+
+	// Transfer payloads lack e.g. the issue’s author and labels.
+	var payload github.IssuesEvent
+	if err := json.Unmarshal([]byte(`{
+  "action": "transferred",
+  "changes": {},
+  "issue": {
+    "number": 1,
+    "title": "Crash when moving windows",
+    "body": "i3 crashes when moving a floating window to another output."
+  },
+  "repository": {"name": "i3", "owner": {"login": "i3"}}
+}`), &payload); err != nil {
+		t.Fatal(err)
+	}
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	rec := httptest.NewRecorder()
+	handleIssuesEvent(context.Background(), rec, &apiClient{Issues: fake}, defaultConfig(), payload)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected HTTP status: got %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+	want := issueOutcome{added: []string{"missing-log", "missing-version"}, comments: 2}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestNextMilestone(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{
+		{Title: github.String("4.20"), Number: github.Int(20), State: github.String("closed")},
+		{Title: github.String("wishlist"), Number: github.Int(99), State: github.String("open")},
+		{Title: github.String("4.21"), Number: github.Int(21), State: github.String("open")},
+	}
+	enabled, err := parseConfig([]byte(`{"next_milestone_patterns": {"i3/i3": "^4\\.[0-9]+$"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	alreadyAssigned := newIssuesEvent("i3 version 4.20 (2021-10-19) crashes, see " + logLink)
+	alreadyAssigned.Issue.Milestone = milestones[1]
+
+	for _, tt := range []struct {
+		name    string
+		cfg     *Config
+		payload github.IssuesEvent
+		want    *int
+	}{
+		{
+			name:    "enabled",
+			cfg:     enabled,
+			payload: newIssuesEvent("i3 version 4.20 (2021-10-19) crashes, see " + logLink),
+			want:    github.Int(21),
+		},
+		{
+			name:    "disabled",
+			cfg:     defaultConfig(),
+			payload: newIssuesEvent("i3 version 4.20 (2021-10-19) crashes, see " + logLink),
+		},
+		{
+			name:    "milestone already assigned",
+			cfg:     enabled,
+			payload: alreadyAssigned,
+		},
+		{
+			name:    "unsupported version",
+			cfg:     enabled,
+			payload: newIssuesEvent("i3 version 4.19 (2020-11-15) crashes, see " + logLink),
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, tt.payload)
+		var got *int
+		for _, edit := range fake.edits[1] {
+			if edit.Milestone != nil {
+				got = edit.Milestone
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected milestone: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestKeepOpenLabel(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	want := issueOutcome{added: []string{"unsupported-version"}}
+
+	fake := newFakeIssues()
+	fake.milestones = milestones
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(),
+		newIssuesEvent("i3 version 4.18 crashes, see "+logLink, "keep-open"))
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("issues event: unexpected outcome: got %+v, want %+v", got, want)
+	}
+
+	fake = newFakeIssues()
+	fake.milestones = milestones
+	issue := newIssuesEvent("i3 crashes all the time, see "+logLink, "missing-version", "keep-open")
+	handleIssueCommentEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(),
+		newIssueCommentEvent(issue, "reporter", "i3 version 4.18"))
+	want.removed = []string{"missing-version"}
+	if got := outcome(fake); !reflect.DeepEqual(got, want) {
+		t.Fatalf("issue_comment event: unexpected outcome: got %+v, want %+v", got, want)
+	}
+}
+
+func TestOptInLabel(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{"opt_in_labels": {"i3/i3": "bot:manage"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const body = "i3 version 4.20 (2021-10-19) crashes when switching workspaces"
+	opened := func(labels ...string) github.IssuesEvent {
+		return newIssuesEvent(body, labels...)
+	}
+	labeled := func(label string, labels ...string) github.IssuesEvent {
+		payload := newIssuesEvent(body, labels...)
+		payload.Action = github.String("labeled")
+		payload.Label = &github.Label{Name: github.String(label)}
+		return payload
+	}
+	evaluated := issueOutcome{added: []string{"missing-log", "4.20"}, comments: 1}
+
+	for _, tt := range []struct {
+		name    string
+		cfg     *Config
+		payload github.IssuesEvent
+		want    issueOutcome
+	}{
+		{
+			name:    "opened without label",
+			cfg:     cfg,
+			payload: opened(),
+			want:    issueOutcome{},
+		},
+		{
+			name:    "opened with label",
+			cfg:     cfg,
+			payload: opened("bot:manage"),
+			want:    evaluated,
+		},
+		{
+			name:    "opt-in label added",
+			cfg:     cfg,
+			payload: labeled("bot:manage", "bot:manage"),
+			want:    evaluated,
+		},
+		{
+			name:    "other label added",
+			cfg:     cfg,
+			payload: labeled("bug", "bug"),
+			want:    issueOutcome{},
+		},
+		{
+			name:    "disabled",
+			cfg:     defaultConfig(),
+			payload: opened(),
+			want:    evaluated,
+		},
+		{
+			name:    "disabled, label added",
+			cfg:     defaultConfig(),
+			payload: labeled("bot:manage", "bot:manage"),
+			want:    issueOutcome{},
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, tt.payload)
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCloseNotification(t *testing.T) {
+	var enqueued [][]byte
+	oldEnqueue := enqueueNotification
+	enqueueNotification = func(ctx context.Context, body []byte) error {
+		enqueued = append(enqueued, body)
+		return nil
+	}
+	t.Cleanup(func() { enqueueNotification = oldEnqueue })
+
+	notifications := make(chan closeNotification, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n closeNotification
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("decoding notification: %v", err)
+		}
+		notifications <- n
+	}))
+	defer srv.Close()
+
+	cfg := defaultConfig()
+	cfg.CloseNotificationURL = srv.URL
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	payload := newIssuesEvent("i3 version 4.18 crashes, see " + logLink)
+	payload.Issue.HTMLURL = github.String("https://github.com/i3/i3/issues/1")
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, payload)
+	if got := outcome(fake); !got.closed {
+		t.Fatalf("issue unexpectedly not closed: %+v", got)
+	}
+	if len(enqueued) != 1 {
+		t.Fatalf("unexpected number of notifications enqueued: got %d, want 1", len(enqueued))
+	}
+
+	// The notification is sent by the task, outside of the webhook request.
+	withConfig(t, cfg)
+	req := httptest.NewRequest("POST", "/tasks/notify", bytes.NewReader(enqueued[0]))
+	req.Header.Set("X-AppEngine-QueueName", "default")
+	rec := httptest.NewRecorder()
+	notifyTaskHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: got %d (%s), want %d", rec.Code, rec.Body.String(), http.StatusOK)
+	}
+
+	select {
+	case got := <-notifications:
+		want := closeNotification{
+			Text:    "Closed i3/i3#1 (version 4.18, latest is 4.20): https://github.com/i3/i3/issues/1",
+			Repo:    "i3/i3",
+			Issue:   1,
+			Version: "4.18",
+			Latest:  "4.20",
+			URL:     "https://github.com/i3/i3/issues/1",
+		}
+		if got != want {
+			t.Fatalf("unexpected notification: got %+v, want %+v", got, want)
+		}
+	case <-time.After(notifyTimeout):
+		t.Fatalf("no notification received")
+	}
+}
+
+func TestExtractDistro(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		body string
+		want string
+	}{
+		{body: "i3 version: 4.23\nKernel: 6.1\nDistro: Arch Linux", want: "arch"},
+		{body: "**OS:** Ubuntu 22.04 LTS", want: "ubuntu"},
+		{body: "- Distribution: Linux Mint 21", want: "mint"},
+		{body: "OS: EndeavourOS (Arch-based)", want: "endeavouros"},
+		{body: "Operating system: my own distro", want: ""},
+		{body: "I use Arch, btw", want: ""},
+	} {
+		if got := extractDistro(tt.body); got != tt.want {
+			t.Fatalf("extractDistro(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+
+	cfg := defaultConfig()
+	cfg.DistroLabelRepos = []string{"i3/i3"}
+	body := "i3 version 4.20 (2021-10-19) crashes, see " + logLink + "\nDistro: Fedora 38"
+	for _, tt := range []struct {
+		cfg  *Config
+		want []string
+	}{
+		{cfg: cfg, want: []string{"distro:fedora", "4.20"}},
+		{cfg: defaultConfig(), want: []string{"4.20"}},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, tt.cfg, newIssuesEvent(body))
+		if got := fake.added[1]; !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("unexpected labels: got %v, want %v", got, tt.want)
+		}
+	}
+}
+
+func TestReopenedIssue(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	body := "i3 version 4.19 (2020-11-15) crashes, see " + logLink
+
+	for _, tt := range []struct {
+		name    string
+		created time.Time
+		want    issueOutcome
+	}{
+		{
+			name:    "recent issue",
+			created: time.Now().AddDate(0, -1, 0),
+			want: issueOutcome{
+				added:    []string{"unsupported-version"},
+				comments: 1,
+				closed:   true,
+			},
+		},
+
+		{
+			name:    "old issue",
+			created: time.Now().AddDate(-2, 0, 0),
+			want: issueOutcome{
+				added: []string{"needs-manual-triage"},
+			},
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			fake := newFakeIssues()
+			fake.milestones = milestones
+			payload := newIssuesEvent(body)
+			payload.Action = github.String("reopened")
+			payload.Issue.CreatedAt = &tt.created
+			rec := httptest.NewRecorder()
+			handleIssuesEvent(context.Background(), rec, &apiClient{Issues: fake}, defaultConfig(), payload)
+			if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("unexpected outcome: got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionIPC(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name     string
+		body     string
+		wantFull string
+	}{
+		{
+			name: "code fence",
+			body: "Output of `i3-msg -t get_version`:\n\n```json\n" +
+				`{"major":4,"minor":23,"patch":0,"human_readable":"4.23 (2023-10-24)","loaded_config_file_name":"/home/user/.config/i3/config"}` +
+				"\n```\n",
+			wantFull: "4.23",
+		},
+
+		{
+			name: "pretty-printed with patch",
+			body: `{
+  "major": 4,
+  "minor": 22,
+  "patch": 1,
+  "human_readable": "4.22.1 (2023-01-02)"
+}`,
+			wantFull: "4.22.1",
+		},
+
+		{
+			name:     "human_readable only",
+			body:     `{"human_readable":"4.21.1 (2022-02-06)"}`,
+			wantFull: "4.21.1",
+		},
+	} {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			matches := extractVersion(tt.body)
+			if len(matches) < 4 || matches[1] != "i3" || matches[3] != tt.wantFull {
+				t.Fatalf("get_version reply not recognized properly, matches = %+v", matches)
+			}
+		})
+	}
+}
+
+func TestVersionPreferredProgram(t *testing.T) {
+	t.Parallel()
+
+	body := `
+i3lock version: 2.4 © 2010 Michael Stapelberg
+i3 version 4.20 (2021-10-19) © 2009 Michael Stapelberg and contributors
+`
+	// Without a preference, the first program is used.
+	matches := extractVersion(body)
+	if len(matches) < 3 || matches[1] != "i3lock" || matches[2] != "2.4" {
+		t.Fatalf("first program not used, matches = %+v", matches)
+	}
+
+	matches = extractProgramVersion(body, "i3")
+	if len(matches) < 3 || matches[1] != "i3" || matches[2] != "4.20" {
+		t.Fatalf("preferred program not used, matches = %+v", matches)
+	}
+
+	// The issue is filed in the i3 repository.
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	rec := httptest.NewRecorder()
+	handleIssuesEvent(context.Background(), rec, &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(body+logLink))
+	if got, want := fake.added[1], []string{"4.20"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected labels: got %v, want %v", got, want)
+	}
+}
+
+func TestVersionSystemctlStatus(t *testing.T) {
+	t.Parallel()
+
+	body := `Output of systemctl --user status i3:
+
+` + "```" + `
+● i3.service - i3 window manager
+     Loaded: loaded (/home/user/.config/systemd/user/i3.service; enabled; vendor preset: enabled)
+     Active: active (running) since Tue 2023-03-14 09:12:44 CET; 2h 3min ago
+   Main PID: 2345 (i3)
+      Tasks: 3 (limit: 18985)
+     Memory: 14.2M
+        CPU: 5.873s
+     CGroup: /user.slice/user-1000.slice/user@1000.service/app.slice/i3.service
+             ├─2345 /usr/bin/i3
+             └─2399 i3bar --bar_id=bar-0
+
+Mar 14 09:12:44 laptop systemd[1000]: Started i3 window manager.
+Mar 14 09:12:44 laptop i3[2345]: i3 version 4.22 (2023-01-02) © 2009 Michael Stapelberg and contributors
+` + "```"
+
+	matches := extractVersion(body)
+	if len(matches) < 4 || matches[1] != "i3" || matches[3] != "4.22" {
+		t.Fatalf("unexpected version: got %q, want i3 4.22", matches)
+	}
+}
+
+func TestExtractVersions(t *testing.T) {
+	t.Parallel()
+
+	for _, tt := range []struct {
+		name string
+		body string
+		want map[string]string
+	}{
+		{
+			name: "i3 and i3lock",
+			body: "Using i3 4.22 (upgraded to i3 version 4.23 yesterday) and i3lock 2.14, the screen does not lock.",
+			want: map[string]string{"i3": "4.23", "i3lock": "2.14"},
+		},
+		{
+			name: "rpm",
+			body: "i3-4.20.1-1.fc38.x86_64\ni3status-2.14-2.fc38.x86_64",
+			want: map[string]string{"i3": "4.20.1", "i3status": "2.14"},
+		},
+		{
+			name: "ipc",
+			body: `{"major":4,"minor":23,"patch":0,"human_readable":"4.23 (2023-10-24)"}`,
+			want: map[string]string{"i3": "4.23"},
+		},
+		{
+			name: "none",
+			body: "it crashes",
+			want: map[string]string{},
+		},
+	} {
+		if got := extractVersions(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: extractVersions = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// memInstallations implements installationStore in memory.
+type memInstallations map[string]Installation
+
+func (m memInstallations) Get(ctx context.Context, account string) (*Installation, error) {
+	inst, ok := m[account]
+	if !ok {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	return &inst, nil
+}
+
+func (m memInstallations) Put(ctx context.Context, inst *Installation) error {
+	m[inst.Account] = *inst
+	return nil
+}
+
+func (m memInstallations) Delete(ctx context.Context, account string) error {
+	if _, ok := m[account]; !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	delete(m, account)
+	return nil
+}
+
+func TestInstallationEvents(t *testing.T) {
+	oldInstallations, oldAppID := installations, githubAppID
+	defer func() {
+		installations, githubAppID = oldInstallations, oldAppID
+		githubToken, githubTokenLoaded = GitHubToken{}, time.Time{}
+	}()
+	githubToken, githubTokenLoaded = GitHubToken{Token: "token", Secret: "secret"}, time.Now()
+	withConfig(t, defaultConfig())
+	store := memInstallations{}
+	installations = store
+
+	installation := &github.Installation{
+		ID:      github.Int64(42),
+		Account: &github.User{Login: github.String("i3")},
+	}
+	repos := func(names ...string) []*github.Repository {
+		var res []*github.Repository
+		for _, name := range names {
+			res = append(res, &github.Repository{FullName: github.String(name)})
+		}
+		return res
+	}
+	send := func(event string, payload interface{}) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		installationHandler(rec, newWebhookRequest(t, event, payload))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected HTTP status: got %d (%s), want %d", event, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+	}
+	created := github.InstallationEvent{
+		Action:       github.String("created"),
+		Installation: installation,
+		Repositories: repos("i3/i3", "i3/i3status"),
+	}
+
+	// Without App mode, events are acknowledged but ignored.
+	githubAppID = ""
+	send("installation", created)
+	if len(store) != 0 {
+		t.Fatalf("installation unexpectedly recorded outside of App mode: %v", store)
+	}
+
+	githubAppID = "1234"
+	send("installation", created)
+	want := Installation{ID: 42, Account: "i3", Repositories: []string{"i3/i3", "i3/i3status"}}
+	if got := store["i3"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected installation: got %+v, want %+v", got, want)
+	}
+
+	send("installation_repositories", github.InstallationRepositoriesEvent{
+		Action:              github.String("added"),
+		Installation:        installation,
+		RepositoriesAdded:   repos("i3/i3lock"),
+		RepositoriesRemoved: repos("i3/i3status"),
+	})
+	want.Repositories = []string{"i3/i3", "i3/i3lock"}
+	if got := store["i3"]; !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected installation: got %+v, want %+v", got, want)
+	}
+
+	send("installation", github.InstallationEvent{
+		Action:       github.String("deleted"),
+		Installation: installation,
+	})
+	if _, ok := store["i3"]; ok {
+		t.Fatalf("installation not deleted: %v", store)
+	}
+	// Deleting twice (e.g. on redelivery) must not fail.
+	send("installation", github.InstallationEvent{
+		Action:       github.String("deleted"),
+		Installation: installation,
+	})
+}
+
+func TestInstallationToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldInstallations, oldAppID, oldLoadAppKey, oldCreate := installations, githubAppID, loadAppKey, createInstallationToken
+	defer func() {
+		installations, githubAppID, loadAppKey, createInstallationToken = oldInstallations, oldAppID, oldLoadAppKey, oldCreate
+		installationTokens = make(map[int64]cachedInstallationToken)
+	}()
+	githubAppID = "1234"
+	installations = memInstallations{"i3": {ID: 42, Account: "i3"}}
+	installationTokens = make(map[int64]cachedInstallationToken)
+	loadAppKey = func(ctx context.Context) (*rsa.PrivateKey, error) { return key, nil }
+
+	// The key is parsed from the PEM file GitHub provides.
+	parsed, err := parseAppKey(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}))
+	if err != nil || !parsed.Equal(key) {
+		t.Fatalf("parseAppKey = %v, %v, want the key", parsed, err)
+	}
+
+	var created []int64
+	expires := time.Now().Add(time.Hour)
+	createInstallationToken = func(ctx context.Context, jwt string, id int64) (*github.InstallationToken, error) {
+		parts := strings.Split(jwt, ".")
+		if len(parts) != 3 {
+			t.Fatalf("malformed JWT %q", jwt)
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			t.Fatalf("JWT signature: %v", err)
+		}
+		b, err := base64.RawURLEncoding.DecodeString(parts[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		var claims struct {
+			Iss      string
+			Iat, Exp int64
+		}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			t.Fatal(err)
+		}
+		if claims.Iss != "1234" || claims.Exp-claims.Iat > 10*60 {
+			t.Fatalf("unexpected JWT claims %+v", claims)
+		}
+		created = append(created, id)
+		return &github.InstallationToken{Token: github.String(fmt.Sprintf("token-%d", len(created))), ExpiresAt: &expires}, nil
+	}
+
+	check := func(want string, wantCreated int) {
+		t.Helper()
+		got, err := installationToken(context.Background(), "i3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("installationToken = %q, want %q", got, want)
+		}
+		if len(created) != wantCreated {
+			t.Fatalf("unexpected number of created tokens: got %d, want %d", len(created), wantCreated)
+		}
+	}
+	check("token-1", 1)
+	check("token-1", 1) // cached
+	// Tokens about to expire are replaced.
+	expires = time.Now().Add(time.Minute)
+	installationTokens[42] = cachedInstallationToken{token: "token-1", expires: expires}
+	check("token-2", 2)
+	if want := []int64{42, 42}; !reflect.DeepEqual(created, want) {
+		t.Fatalf("tokens created for unexpected installations: got %v, want %v", created, want)
+	}
+
+	if _, err := installationToken(context.Background(), "other-org"); err == nil {
+		t.Fatal("installationToken unexpectedly succeeded for an owner without installation")
+	}
+	if _, err := clientFor(context.Background(), "other-org"); err == nil {
+		t.Fatal("clientFor unexpectedly succeeded for an owner without installation")
+	}
+}
+
+// hugeBody returns an issue body as filed by crash reporters: the version near
+// the top, followed by megabytes of backtrace, mentioning i3 on every line.
+func hugeBody() string {
+	return "i3 version 4.20 (2021-10-19) crashed, see " + logLink + "\n" +
+		strings.Repeat("#3  0x000055d5 in i3: i3 tree_render () at ../src/render.c:42 v\n", 1<<16)
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	body := hugeBody()
+	window := cfg.regexpWindow(body)
+	if got, max := len(window), cfg.MaxBodyBytes+1; got > max {
+		t.Fatalf("regexpWindow returned %d bytes, want at most %d", got, max)
+	}
+	if !utf8.ValidString(cfg.regexpWindow(strings.Repeat("ä", cfg.MaxBodyBytes))) {
+		t.Fatalf("regexpWindow split a character")
+	}
+	if got := cfg.regexpWindow("short"); got != "short" {
+		t.Fatalf("regexpWindow(%q) = %q", "short", got)
+	}
+
+	fake := newFakeIssues()
+	fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, newIssuesEvent(body))
+	if got, want := outcome(fake), (issueOutcome{added: []string{"4.20"}}); !reflect.DeepEqual(got, want) {
+		t.Fatalf("unexpected outcome: got %+v, want %+v", got, want)
+	}
+
+	// Versions in the middle of a huge body are not found.
+	middle := strings.Repeat("crash\n", 1<<16) + "i3 version 4.20\n" + strings.Repeat("crash\n", 1<<16)
+	if got := extractVersion(cfg.regexpWindow(middle)); len(got) != 0 {
+		t.Fatalf("unexpectedly found version %q in the middle of a huge body", got)
+	}
+}
+
+func BenchmarkEvaluateHugeIssue(b *testing.B) {
+	cfg := defaultConfig()
+	payload := newIssuesEvent(hugeBody())
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fake := newFakeIssues()
+		fake.milestones = []*github.Milestone{{Title: github.String("4.20")}}
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, payload)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	defer func() { githubToken, githubTokenLoaded = GitHubToken{}, time.Time{} }()
+	githubToken = GitHubToken{Secret: "new", SecretPrevious: "old"}
+
+	body := []byte(`{"zen": "Keep it logically awesome."}`)
+	sign := func(hash func() hash.Hash, prefix, secret string) string {
+		h := hmac.New(hash, []byte(secret))
+		h.Write(body)
+		return prefix + hex.EncodeToString(h.Sum(nil))
+	}
+	for _, tt := range []struct {
+		sha1, sha256 string
+		wantStatus   int
+	}{
+		{sha1: sign(sha1.New, "sha1=", "new"), wantStatus: http.StatusOK},
+		{sha1: sign(sha1.New, "sha1=", "old"), wantStatus: http.StatusOK},
+		{sha1: sign(sha1.New, "sha1=", "wrong"), wantStatus: http.StatusBadRequest},
+		{sha1: "sha256=0123", wantStatus: http.StatusBadRequest},
+		{sha256: sign(sha256.New, "sha256=", "new"), wantStatus: http.StatusOK},
+		{sha256: sign(sha256.New, "sha256=", "old"), wantStatus: http.StatusOK},
+		{sha256: sign(sha256.New, "sha256=", "wrong"), wantStatus: http.StatusBadRequest},
+		{sha256: sign(sha1.New, "sha1=", "new"), wantStatus: http.StatusBadRequest},
+		// The SHA-256 signature takes precedence.
+		{sha1: sign(sha1.New, "sha1=", "new"), sha256: sign(sha256.New, "sha256=", "wrong"), wantStatus: http.StatusBadRequest},
+		{sha1: sign(sha1.New, "sha1=", "wrong"), sha256: sign(sha256.New, "sha256=", "new"), wantStatus: http.StatusOK},
+		{wantStatus: http.StatusBadRequest},
+	} {
+		r := httptest.NewRequest("POST", "/debug/verify-signature", bytes.NewReader(body))
+		if tt.sha1 != "" {
+			r.Header.Set("X-Hub-Signature", tt.sha1)
+		}
+		if tt.sha256 != "" {
+			r.Header.Set("X-Hub-Signature-256", tt.sha256)
+		}
+		rec := httptest.NewRecorder()
+		verifySignature(rec, r)
+		if rec.Code != tt.wantStatus {
+			t.Fatalf("signatures %q, %q: unexpected HTTP status: got %d (%s), want %d",
+				tt.sha1, tt.sha256, rec.Code, rec.Body.String(), tt.wantStatus)
+		}
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	t.Parallel()
+
+	cfg, err := parseConfig([]byte(`{
+  "short_log_urls": true,
+  "close_notification_url": "https://hooks.slack.com/services/T000/B000/s3cr3tt0k3n"
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	writeConfig(rec, cfg)
+	body := rec.Body.String()
+	if strings.Contains(body, "s3cr3tt0k3n") {
+		t.Fatalf("config contains the close notification token: %s", body)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]interface{}{
+		"short_log_urls":         true,
+		"close_notification_url": "(redacted)",
+		"min_log_line_percent":   float64(0),
+		"keep_open_label":        "keep-open",
+		"scope":                  "all",
+	} {
+		if got[key] != want {
+			t.Fatalf("config[%q] = %v, want %v", key, got[key], want)
+		}
+	}
+	// The configuration itself is not modified.
+	if cfg.CloseNotificationURL == "(redacted)" {
+		t.Fatalf("writeConfig modified the configuration")
+	}
+}
+
+// withEnqueueEvaluation replaces enqueueEvaluation with enqueue for the
+// duration of the test. Tests using it must not run in parallel.
+func withEnqueueEvaluation(t *testing.T, enqueue func(ctx context.Context, owner, repo string, number int, delay time.Duration) error) {
+	old := enqueueEvaluation
+	enqueueEvaluation = enqueue
+	t.Cleanup(func() { enqueueEvaluation = old })
+}
+
+// withEnqueueAutoClose replaces enqueueAutoClose with enqueue for the
+// duration of the test. Tests using it must not run in parallel.
+func withEnqueueAutoClose(t *testing.T, enqueue func(ctx context.Context, owner, repo string, number int, version, latest string, delay time.Duration) error) {
+	old := enqueueAutoClose
+	enqueueAutoClose = enqueue
+	t.Cleanup(func() { enqueueAutoClose = old })
+}
+
+func TestEvaluationDelay(t *testing.T) {
+	var delays []time.Duration
+	withEnqueueEvaluation(t, func(ctx context.Context, owner, repo string, number int, delay time.Duration) error {
+		delays = append(delays, delay)
+		return nil
+	})
+	cfg := defaultConfig()
+	cfg.EvaluationDelaySeconds = 60
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+
+	// Opening the issue only schedules its evaluation.
+	fake := newFakeIssues()
+	fake.milestones = milestones
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg,
+		newIssuesEvent("i3 crashes when moving a floating window to another output"))
+	if got := outcome(fake); !reflect.DeepEqual(got, issueOutcome{}) {
+		t.Fatalf("unexpected outcome before the delay: %+v", got)
+	}
+	if want := []time.Duration{time.Minute}; !reflect.DeepEqual(delays, want) {
+		t.Fatalf("unexpected delays: got %v, want %v", delays, want)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		issue *github.Issue
+		want  issueOutcome
+	}{
+		{
+			name: "edited to add version and log",
+			issue: &github.Issue{
+				Number: github.Int(1),
+				State:  github.String("open"),
+				Body:   github.String("i3 version 4.20 crashes when moving a floating window, log: " + logLink),
+			},
+			want: issueOutcome{added: []string{"4.20"}},
+		},
+		{
+			name: "unchanged",
+			issue: &github.Issue{
+				Number: github.Int(1),
+				State:  github.String("open"),
+				Body:   github.String("i3 crashes when moving a floating window to another output"),
+			},
+			want: issueOutcome{added: []string{"missing-log", "missing-version"}, comments: 2},
+		},
+		{
+			name: "closed in the meantime",
+			issue: &github.Issue{
+				Number: github.Int(1),
+				State:  github.String("closed"),
+				Body:   github.String("i3 crashes when moving a floating window to another output"),
+			},
+		},
+	} {
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		fake.issues = []*github.Issue{tt.issue}
+		rec := httptest.NewRecorder()
+		evaluateDelayed(context.Background(), rec, &apiClient{Issues: fake}, cfg, "i3", "i3", 1)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected HTTP status: got %d (%s), want %d", tt.name, rec.Code, rec.Body.String(), http.StatusOK)
+		}
+		if got := outcome(fake); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%s: unexpected outcome: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCommentGuard(t *testing.T) {
+	withPostedComments(t, &memCommentGuard{keys: make(map[string]bool)})
+	cfg := defaultConfig()
+	// Without comments on the issue, the similarity check does not run.
+	cfg.CommentDedupeLookback = 0
+
+	fake := newFakeIssues()
+	client := &apiClient{Issues: fake}
+	payload := newIssuesEvent("i3 crashes")
+	for _, comment := range []string{
+		"Please provide a log.",
+		// The same logical comment, e.g. from another code path.
+		"please  provide a log.\n",
+	} {
+		if !addComment(context.Background(), client, cfg, payload, httptest.NewRecorder(), comment) {
+			t.Fatalf("addComment(%q) = false, want true", comment)
+		}
+	}
+	if got, want := len(fake.comments[1]), 1; got != want {
+		t.Fatalf("unexpected number of comments: got %d, want %d", got, want)
+	}
+
+	// The same comment may still be posted on another issue.
+	other := newIssuesEvent("i3 crashes, too")
+	other.Issue.Number = github.Int(2)
+	addComment(context.Background(), client, cfg, other, httptest.NewRecorder(), "Please provide a log.")
+	if got, want := len(fake.comments[2]), 1; got != want {
+		t.Fatalf("unexpected number of comments on issue #2: got %d, want %d", got, want)
+	}
+
+	// A comment which could not be posted is not recorded, so that posting
+	// it again (e.g. when GitHub retries the webhook) succeeds.
+	third := newIssuesEvent("i3 crashes, three")
+	third.Issue.Number = github.Int(3)
+	if addComment(context.Background(), &apiClient{Issues: failingComments{fake}}, cfg, third, httptest.NewRecorder(), "Please provide a log.") {
+		t.Fatal("addComment unexpectedly succeeded")
+	}
+	if !addComment(context.Background(), client, cfg, third, httptest.NewRecorder(), "Please provide a log.") {
+		t.Fatal("addComment = false, want true")
+	}
+	if got, want := len(fake.comments[3]), 1; got != want {
+		t.Fatalf("unexpected number of comments on issue #3: got %d, want %d", got, want)
+	}
+}
+
+// failingComments is a fakeIssues whose CreateComment requests fail.
+type failingComments struct {
+	*fakeIssues
+}
+
+func (f failingComments) CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error) {
+	return nil, nil, errors.New("502 Bad Gateway")
+}
+
+// withEnqueueReproductionCheck replaces enqueueReproductionCheck with enqueue
+// for the duration of the test. Tests using it must not run in parallel.
+func withEnqueueReproductionCheck(t *testing.T, enqueue func(ctx context.Context, owner, repo string, number int, due time.Time) error) {
+	old := enqueueReproductionCheck
+	enqueueReproductionCheck = enqueue
+	t.Cleanup(func() { enqueueReproductionCheck = old })
+}
+
+func TestReproductionDue(t *testing.T) {
+	t.Parallel()
+
+	cfg := defaultConfig()
+	cfg.ReproductionReminderDays = 14
+	cfg.BotLogin = "i3-bot"
+	opened := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	day := 24 * time.Hour
+	comment := func(login string, after time.Duration) *github.IssueComment {
+		created := opened.Add(after)
+		return &github.IssueComment{
+			User:      &github.User{Login: github.String(login)},
+			CreatedAt: &created,
+		}
+	}
+	maintainers := map[string]bool{"stapelberg": true, "orestisfl": true}
+
+	for _, tt := range []struct {
+		name     string
+		comments []*github.IssueComment
+		want     time.Time
+	}{
+		{
+			name: "no comments",
+			want: opened.Add(14 * day),
+		},
+		{
+			name:     "only the reporter and others commented",
+			comments: []*github.IssueComment{comment("reporter", day), comment("bystander", 2*day)},
+			want:     opened.Add(14 * day),
+		},
+		{
+			name:     "bot comments do not count",
+			comments: []*github.IssueComment{comment("i3-bot", 3*day)},
+			want:     opened.Add(14 * day),
+		},
+		{
+			name: "last maintainer comment",
+			comments: []*github.IssueComment{
+				comment("orestisfl", day),
+				comment("reporter", 2*day),
+				comment("stapelberg", 5*day),
+				comment("reporter", 6*day),
+			},
+			want: opened.Add(19 * day),
+		},
+	} {
+		var looked []string
+		got, err := cfg.reproductionDue(&github.Issue{CreatedAt: &opened}, tt.comments, func(login string) (bool, error) {
+			looked = append(looked, login)
+			return maintainers[login], nil
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !got.Equal(tt.want) {
+			t.Fatalf("%s: unexpected due date: got %v, want %v", tt.name, got, tt.want)
+		}
+		for _, login := range looked {
+			if login == "i3-bot" {
+				t.Fatalf("%s: looked up whether the bot is a maintainer", tt.name)
+			}
+		}
+	}
+
+	// Older comments are not looked at once a maintainer comment was found.
+	var looked []string
+	if _, err := cfg.reproductionDue(&github.Issue{CreatedAt: &opened},
+		[]*github.IssueComment{comment("reporter", day), comment("stapelberg", 2*day)},
+		func(login string) (bool, error) {
+			looked = append(looked, login)
+			return maintainers[login], nil
+		}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"stapelberg"}; !reflect.DeepEqual(looked, want) {
+		t.Fatalf("unexpected lookups: got %v, want %v", looked, want)
+	}
+}
+
+func TestReproductionReminder(t *testing.T) {
+	var dues []time.Time
+	withEnqueueReproductionCheck(t, func(ctx context.Context, owner, repo string, number int, due time.Time) error {
+		dues = append(dues, due)
+		return nil
+	})
+	withCollaborators(t, newMemCollaborators())
+	cfg := defaultConfig()
+	cfg.ReproductionReminderDays = 7
+	day := 24 * time.Hour
+
+	// Labeling a bug report schedules the first check.
+	payload := newIssuesEvent("i3 version 4.20 crashes, see " + logLink)
+	payload.Action = github.String("labeled")
+	payload.Label = &github.Label{Name: github.String("bug")}
+	payload.Issue.Labels = []*github.Label{{Name: github.String("bug")}}
+	before := time.Now()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: newFakeIssues()}, cfg, payload)
+	if len(dues) != 1 || dues[0].Before(before.Add(7*day-time.Minute)) || dues[0].After(time.Now().Add(7*day)) {
+		t.Fatalf("unexpected due dates: got %v, want about 7 days from now", dues)
+	}
+
+	opened := time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)
+	commentAt := func(login string, after time.Duration) *github.IssueComment {
+		created := opened.Add(after)
+		return &github.IssueComment{User: &github.User{Login: github.String(login)}, CreatedAt: &created}
+	}
+	for _, tt := range []struct {
+		name      string
+		labels    []string
+		comments  []*github.IssueComment
+		wantAdded []string
+		wantDues  []time.Time
+	}{
+		{
+			name:      "no maintainer comment",
+			labels:    []string{"bug"},
+			comments:  []*github.IssueComment{commentAt("reporter", day)},
+			wantAdded: []string{"needs-reproduction"},
+		},
+		{
+			name:     "recent maintainer comment",
+			labels:   []string{"bug"},
+			comments: []*github.IssueComment{commentAt("stapelberg", 8*day)},
+			wantDues: []time.Time{opened.Add(15 * day)},
+		},
+		{
+			name:   "already reminded",
+			labels: []string{"bug", "needs-reproduction"},
+		},
+		{
+			name: "no longer a bug",
+		},
+	} {
+		dues = nil
+		fake := newFakeIssues()
+		issue := &github.Issue{
+			Number:    github.Int(1),
+			State:     github.String("open"),
+			CreatedAt: &opened,
+		}
+		for _, label := range tt.labels {
+			issue.Labels = append(issue.Labels, &github.Label{Name: github.String(label)})
+		}
+		fake.issues = []*github.Issue{issue}
+		fake.existingComments = map[int][]*github.IssueComment{1: tt.comments}
+		repos := &fakeRepositories{collaborators: map[string]bool{"stapelberg": true}}
+		checkReproduction(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake, Repositories: repos}, cfg,
+			"i3", "i3", 1, opened.Add(10*day))
+		if got := fake.added[1]; !reflect.DeepEqual(got, tt.wantAdded) {
+			t.Fatalf("%s: unexpected labels: got %v, want %v", tt.name, got, tt.wantAdded)
+		}
+		if !reflect.DeepEqual(dues, tt.wantDues) {
+			t.Fatalf("%s: unexpected due dates: got %v, want %v", tt.name, dues, tt.wantDues)
+		}
+	}
+
+	if _, err := parseConfig([]byte(`{"reproduction_reminder_days": 31}`)); err == nil {
+		t.Fatal("parseConfig unexpectedly accepted a reminder beyond the task queue's limit")
+	}
+}
+
+func TestReproductionTaskName(t *testing.T) {
+	t.Parallel()
+
+	due := time.Date(2023, 3, 15, 12, 0, 0, 0, time.UTC)
+	if got, want := reproductionTaskName("i3", "i3.github.io", 42, due), "repro-i3-i3_github_io-42-1678881600"; got != want {
+		t.Fatalf("unexpected task name: got %q, want %q", got, want)
+	}
+	// Checks which are due later (e.g. after a maintainer commented) are
+	// new tasks.
+	if reproductionTaskName("i3", "i3", 42, due) == reproductionTaskName("i3", "i3", 42, due.Add(time.Second)) {
+		t.Fatal("checks due at different times share a task name")
+	}
+}
+
+func TestScope(t *testing.T) {
+	t.Parallel()
+
+	milestones := []*github.Milestone{{Title: github.String("4.20")}}
+	issue := newIssuesEvent("i3 crashes all the time", "missing-version", "missing-log")
+	comment := newIssueCommentEvent(issue, "reporter", "i3 version 4.20 (2021-10-19), log: "+logLink)
+	opened := newIssuesEvent("i3 version 4.18 crashes, see " + logLink)
+
+	for _, tt := range []struct {
+		scope        string
+		wantComments bool
+		wantIssues   bool
+	}{
+		{scope: "all", wantComments: true, wantIssues: true},
+		{scope: "issues", wantIssues: true},
+		{scope: "comments", wantComments: true},
+	} {
+		cfg, err := parseConfig([]byte(`{"scope": "` + tt.scope + `"}`))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.scope, err)
+		}
+
+		fake := newFakeIssues()
+		fake.milestones = milestones
+		rec := httptest.NewRecorder()
+		handleIssueCommentEvent(context.Background(), rec, &apiClient{Issues: fake}, cfg, comment)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: unexpected status for the comment: %d", tt.scope, rec.Code)
+		}
+		if got := !reflect.DeepEqual(outcome(fake), issueOutcome{}); got != tt.wantComments {
+			t.Fatalf("%s: comment handled: got %v, want %v (outcome %+v)", tt.scope, got, tt.wantComments, outcome(fake))
+		}
+
+		fake = newFakeIssues()
+		fake.milestones = milestones
+		handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, cfg, opened)
+		if got := !reflect.DeepEqual(outcome(fake), issueOutcome{}); got != tt.wantIssues {
+			t.Fatalf("%s: issue handled: got %v, want %v (outcome %+v)", tt.scope, got, tt.wantIssues, outcome(fake))
+		}
+
+		rec = httptest.NewRecorder()
+		writeHealthz(rec, cfg)
+		if got, want := rec.Body.String(), "scope: "+tt.scope+"\n"; !strings.Contains(got, want) {
+			t.Fatalf("%s: healthz = %q, want it to contain %q", tt.scope, got, want)
+		}
+	}
+
+	if got := defaultConfig().Scope; got != "all" {
+		t.Fatalf("default scope = %q, want all", got)
+	}
+	if _, err := parseConfig([]byte(`{"scope": "pull_requests"}`)); err == nil {
+		t.Fatal("parseConfig(invalid scope) unexpectedly succeeded")
+	}
+}
+
+func withFetchTokenHeaders(t *testing.T, fetch func(ctx context.Context) (http.Header, error)) {
+	old := fetchTokenHeaders
+	fetchTokenHeaders = fetch
+	t.Cleanup(func() { fetchTokenHeaders = old })
+}
+
+func TestTokenScopes(t *testing.T) {
+	for _, tt := range []struct {
+		header http.Header
+		ok     bool
+		want   string
+	}{
+		{header: http.Header{"X-Oauth-Scopes": {"read:org, repo"}}, ok: true, want: `["read:org" "repo"]`},
+		{header: http.Header{"X-Oauth-Scopes": {"public_repo"}}, ok: true, want: `["public_repo"]`},
+		{header: http.Header{"X-Oauth-Scopes": {"read:user, gist"}}, want: "WARNING"},
+		{header: http.Header{"X-Oauth-Scopes": {""}}, want: "WARNING"},
+		{header: http.Header{}, ok: true, want: "fine-grained"},
+	} {
+		got, ok := describeTokenScopes(tt.header)
+		if ok != tt.ok || !strings.Contains(got, tt.want) {
+			t.Errorf("describeTokenScopes(%v) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+
+	withFetchTokenHeaders(t, func(ctx context.Context) (http.Header, error) {
+		return http.Header{"X-Oauth-Scopes": {"read:user, gist"}}, nil
+	})
+	checkTokenScopes(context.Background())
+	rec := httptest.NewRecorder()
+	writeHealthz(rec, defaultConfig())
+	if got, want := rec.Body.String(), `token: WARNING: scopes ["gist" "read:user"] lack repo`; !strings.Contains(got, want) {
+		t.Fatalf("healthz = %q, want it to contain %q", got, want)
+	}
+
+	withFetchTokenHeaders(t, func(ctx context.Context) (http.Header, error) {
+		return nil, errors.New("401 Bad credentials")
+	})
+	if got, want := checkTokenScopes(context.Background()), "scope check failed: 401 Bad credentials"; got != want {
+		t.Fatalf("checkTokenScopes = %q, want %q", got, want)
+	}
+}
+
+func TestUserAgent(t *testing.T) {
+	t.Parallel()
+
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("User-Agent")
+	}))
+	defer srv.Close()
+
+	for _, tt := range []struct {
+		config string
+		want   string
+	}{
+		{config: `{}`, want: "i3-github-bot (run by github.com/stapelberg)"},
+		{config: `{"user_agent_contact": "github.com/orestisfl"}`, want: "i3-github-bot (run by github.com/orestisfl)"},
+		{config: `{"user_agent_contact": " "}`, want: "i3-github-bot (run by github.com/stapelberg)"},
+	} {
+		cfg, err := parseConfig([]byte(tt.config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: &githubTransport{base: http.DefaultTransport, userAgent: cfg.userAgent()}}
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got != tt.want {
+			t.Fatalf("%s: User-Agent = %q, want %q", tt.config, got, tt.want)
+		}
+	}
+
+	if _, err := parseConfig([]byte(`{"user_agent_contact": "me\r\nX-Evil: 1"}`)); err == nil {
+		t.Fatal("parseConfig(user_agent_contact with newline) unexpectedly succeeded")
+	}
+}
+
+func TestFormLabels(t *testing.T) {
+	t.Parallel()
+
+	const form = `### Type of issue
+
+Bug
+
+### Severity
+
+Crash
+
+### Frequency
+
+Always
+
+### i3 version
+
+i3 version 4.23 (2023-10-24) © 2009 Michael Stapelberg and contributors
+
+### Logfile
+
+` + logLink + `
+`
+	fake := newFakeIssues()
+	handleIssuesEvent(context.Background(), httptest.NewRecorder(), &apiClient{Issues: fake}, defaultConfig(), newIssuesEvent(form))
+	added := make(map[string]bool)
+	for _, label := range outcome(fake).added {
+		added[label] = true
+	}
+	for _, want := range []string{"severity:crash", "frequency:always"} {
+		if !added[want] {
+			t.Fatalf("label %q not added (outcome %+v)", want, outcome(fake))
+		}
+	}
+
+	cfg := defaultConfig()
+	repo := &github.Repository{Name: github.String("i3"), Owner: &github.User{Login: github.String("i3")}}
+	for _, tt := range []struct {
+		body string
+		want []string
+	}{
+		// Free-text answers must not create labels.
+		{body: "### Severity\n\nMy laptop caught fire\n\n### Frequency\n\nSometimes\n", want: []string{"frequency:sometimes"}},
+		{body: "### Severity\n\n annoyance \n\n### Frequency\n\n_No response_\n", want: []string{"severity:annoyance"}},
+		{body: "Severity: crash, frequency: always"},
+	} {
+		if got := cfg.formLabels(repo, tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("formLabels(%q) = %q, want %q", tt.body, got, tt.want)
+		}
+	}
+	other := &github.Repository{Name: github.String("i3lock"), Owner: &github.User{Login: github.String("i3")}}
+	if got := cfg.formLabels(other, form); len(got) != 0 {
+		t.Errorf("formLabels(i3lock) = %q, want none", got)
+	}
+
+	custom, err := parseConfig([]byte(`{"form_labels": {"i3/i3lock": [{"field": "Severity", "labels": {"Crash": "crash"}}]}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := custom.formLabels(other, form), []string{"crash"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("custom formLabels = %q, want %q", got, want)
+	}
+	if got := custom.formLabels(repo, form); len(got) != 0 {
+		t.Errorf("custom formLabels(i3) = %q, want none", got)
+	}
+	if _, err := parseConfig([]byte(`{"form_labels": {"i3/i3": [{"field": "severity", "labels": {"crash": ""}}]}}`)); err == nil {
+		t.Fatal("parseConfig(empty form label) unexpectedly succeeded")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+)
+
+// minRateRemaining is the number of GitHub API requests which bulkLabel
+// leaves for the webhook handlers.
+const minRateRemaining = 500
+
+// bulkLabel applies label to all open issues in owner/repo whose extracted
+// major version is version. It returns the numbers of all matching issues,
+// which are not modified when dryRun is true. At most limit issues are
+// labeled.
+func bulkLabel(ctx context.Context, issues issueService, owner, repo, version, label string, dryRun bool, limit int) ([]int, error) {
+	var matching []int
+	opts := &github.IssueListByRepoOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, listResp, err := issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return matching, fmt.Errorf("ListByRepo: %v", err)
+		}
+		discardResponse(listResp)
+		for _, issue := range page {
+			if issue.IsPullRequest() {
+				continue
+			}
+			matches := extractVersion(issue.GetBody())
+			if len(matches) == 0 || matches[1] != "i3" || matches[2] != version {
+				continue
+			}
+			if hasLabel(issue, label) {
+				continue
+			}
+			if len(matching) >= limit {
+				return matching, nil
+			}
+			if rate := listResp.Rate; rate.Limit > 0 && rate.Remaining < minRateRemaining {
+				return matching, fmt.Errorf("stopping early: only %d GitHub API requests remaining", rate.Remaining)
+			}
+			matching = append(matching, issue.GetNumber())
+			if dryRun {
+				continue
+			}
+			_, resp, err := issues.AddLabelsToIssue(ctx, owner, repo, issue.GetNumber(), []string{label})
+			if err != nil {
+				return matching, fmt.Errorf("AddLabelsToIssue(#%d): %v", issue.GetNumber(), err)
+			}
+			discardResponse(resp)
+			// Keep our view of the remaining rate limit up to date.
+			listResp.Rate = resp.Rate
+		}
+		if listResp.NextPage == 0 {
+			return matching, nil
+		}
+		opts.Page = listResp.NextPage
+	}
+}
+
+// bulkLabelHandler labels all open issues reporting a specific i3 version,
+// e.g. /bulk_label?repo=i3/i3&version=4.19&label=4.19&dry_run=1
+func bulkLabelHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
+
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	owner, repo, ok := splitRepo(r.FormValue("repo"))
+	if !ok {
+		http.Error(w, "repo parameter must be of the form owner/repo", http.StatusBadRequest)
+		return
+	}
+	version := r.FormValue("version")
+	label := r.FormValue("label")
+	if version == "" || label == "" {
+		http.Error(w, "version and label parameters are required", http.StatusBadRequest)
+		return
+	}
+	dryRun := r.FormValue("dry_run") != "" && r.FormValue("dry_run") != "0"
+	if !dryRun && r.Method != "POST" {
+		http.Error(w, "Use POST (or dry_run=1)", http.StatusMethodNotAllowed)
+		return
+	}
+	limit := 100
+	if v := r.FormValue("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil {
+			http.Error(w, fmt.Sprintf("limit: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	client, err := clientFor(ctx, owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	matching, err := bulkLabel(ctx, client.Issues, owner, repo, version, label, dryRun, limit)
+	infof(ctx, "bulk label %q on %s/%s (version %s, dry run %v): %v", label, owner, repo, version, dryRun, matching)
+	for _, number := range matching {
+		fmt.Fprintf(w, "https://github.com/%s/%s/issues/%d\n", owner, repo, number)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "error: %v\n", err)
+	}
+}
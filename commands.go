@@ -0,0 +1,274 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine/memcache"
+)
+
+// commandRegexp matches maintainer commands in comments. Commands must be on
+// a line of their own, e.g. “/label needs-reproduction”.
+var commandRegexp = regexp.MustCompile(`(?m)^/([a-z]+)(?:[ \t]+(.*?))?[ \t]*\r?$`)
+
+type command struct {
+	name string
+	arg  string
+}
+
+// commandFuncs implements the supported commands. Functions return false if
+// the command could not be executed.
+var commandFuncs = map[string]func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool{
+	"label": func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool {
+		if arg == "" {
+			return false
+		}
+		return addLabel(ctx, client, cfg, payload, w, arg)
+	},
+
+	"unlabel": func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool {
+		if arg == "" {
+			return false
+		}
+		return deleteLabel(ctx, client, payload, w, arg)
+	},
+
+	"close": func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool {
+		reason := strings.Replace(strings.ToLower(arg), " ", "_", -1)
+		if reason == "" {
+			reason = "completed"
+		}
+		if reason != "completed" && reason != "not_planned" {
+			return false
+		}
+		return closeIssue(ctx, client, payload, w, reason)
+	},
+
+	// recheck looks for a log link and version in the issue body again, e.g.
+	// after the reporter edited the issue.
+	"recheck": func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool {
+		return recheckVersionAndLog(ctx, w, client, cfg, payload, payload.GetIssue().GetBody())
+	},
+
+	// loganalyze quotes the first error in the log linked in the issue (or
+	// in the argument), so that triage can start without downloading it.
+	"loganalyze": func(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent, arg string) bool {
+		match := logLinkRegexp.FindStringSubmatch(arg)
+		if match == nil {
+			match = logLinkRegexp.FindStringSubmatch(payload.GetIssue().GetBody())
+		}
+		if match == nil {
+			return false
+		}
+		excerpt, err := analyzeLog(ctx, match[1])
+		if err != nil {
+			errorf(ctx, "analyzeLog(%q): %v", match[1], err)
+			return false
+		}
+		if excerpt == "" {
+			return addComment(ctx, client, cfg, payload, w, fmt.Sprintf("I could not find an error in log %s.", match[1]))
+		}
+		return addComment(ctx, client, cfg, payload, w, fmt.Sprintf("First error in log %s:\n\n```\n%s\n```", match[1], excerpt))
+	},
+}
+
+// parseCommands returns all supported commands contained in |body|.
+func parseCommands(body string) []command {
+	var commands []command
+	for _, match := range commandRegexp.FindAllStringSubmatch(body, -1) {
+		if _, ok := commandFuncs[match[1]]; !ok {
+			continue // silently ignore unknown commands
+		}
+		commands = append(commands, command{name: match[1], arg: match[2]})
+	}
+	return commands
+}
+
+// runCommands executes the commands contained in the comment, provided the
+// commenter is a collaborator of the repository.
+func runCommands(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssueCommentEvent) {
+	commands := parseCommands(payload.GetComment().GetBody())
+	if len(commands) == 0 {
+		return
+	}
+	repo := payload.GetRepo()
+	login := payload.GetComment().GetUser().GetLogin()
+	collaborator, err := isCollaborator(ctx, client, repo.GetOwner().GetLogin(), repo.GetName(), login)
+	if err != nil {
+		errorf(ctx, "IsCollaborator(%q): %v", login, err)
+		return
+	}
+	if !collaborator {
+		infof(ctx, "ignoring commands by non-collaborator %q", login)
+		return
+	}
+	for _, cmd := range commands {
+		infof(ctx, "running command %q (argument %q) by %q", cmd.name, cmd.arg, login)
+		if !commandFuncs[cmd.name](ctx, w, client, cfg, payload, cmd.arg) {
+			infof(ctx, "command %q (argument %q) had no effect", cmd.name, cmd.arg)
+		}
+	}
+}
+
+const (
+	// collaboratorTTL and nonCollaboratorTTL are how long the collaborator
+	// status of a user is cached. Negative results expire sooner, so that new
+	// collaborators can use commands soon.
+	collaboratorTTL    = 1 * time.Hour
+	nonCollaboratorTTL = 5 * time.Minute
+
+	// After a failed lookup, lookups of the same user are not retried for
+	// collaboratorMinBackoff, doubling with each consecutive failure up to
+	// collaboratorMaxBackoff, so that a GitHub outage is not made worse.
+	collaboratorMinBackoff = 5 * time.Second
+	collaboratorMaxBackoff = 10 * time.Minute
+)
+
+// collaboratorCache caches the collaborator status of users, keyed by
+// “owner/repo/login”. Tests replace collaborators with an in-memory
+// implementation.
+type collaboratorCache interface {
+	// Get returns the cached status and whether there was a cache entry.
+	Get(ctx context.Context, key string) (collaborator, ok bool)
+	Set(ctx context.Context, key string, collaborator bool, ttl time.Duration)
+}
+
+var collaborators collaboratorCache = memcacheCollaborators{}
+
+// memcacheCollaborators implements collaboratorCache using App Engine
+// memcache, so that the cache is shared between instances.
+type memcacheCollaborators struct{}
+
+func (memcacheCollaborators) Get(ctx context.Context, key string) (bool, bool) {
+	item, err := memcache.Get(ctx, "collaborator:"+key)
+	if err != nil {
+		if err != memcache.ErrCacheMiss {
+			errorf(ctx, "memcache.Get(%q): %v", key, err)
+		}
+		return false, false
+	}
+	return string(item.Value) == "1", true
+}
+
+func (memcacheCollaborators) Set(ctx context.Context, key string, collaborator bool, ttl time.Duration) {
+	value := "0"
+	if collaborator {
+		value = "1"
+	}
+	item := &memcache.Item{
+		Key:        "collaborator:" + key,
+		Value:      []byte(value),
+		Expiration: ttl,
+	}
+	if err := memcache.Set(ctx, item); err != nil {
+		errorf(ctx, "memcache.Set(%q): %v", key, err)
+	}
+}
+
+// collaboratorCall is an in-flight collaborator lookup, which concurrent
+// events for the same user wait for instead of making their own API request.
+type collaboratorCall struct {
+	done         chan struct{}
+	collaborator bool
+	err          error
+}
+
+// collaboratorBackoff records consecutive failed lookups of a user.
+type collaboratorBackoff struct {
+	failures int
+	until    time.Time
+	err      error
+}
+
+var (
+	collaboratorCallsMu sync.Mutex
+	collaboratorCalls   = make(map[string]*collaboratorCall)
+	// collaboratorBackoffs is guarded by collaboratorCallsMu.
+	collaboratorBackoffs = make(map[string]*collaboratorBackoff)
+
+	// collaboratorNow returns the current time. Tests replace it.
+	collaboratorNow = time.Now
+)
+
+// delay returns how long to back off after b.failures consecutive failures.
+func (b *collaboratorBackoff) delay() time.Duration {
+	delay := collaboratorMinBackoff
+	for i := 1; i < b.failures && delay < collaboratorMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > collaboratorMaxBackoff {
+		delay = collaboratorMaxBackoff
+	}
+	return delay
+}
+
+// isCollaborator returns whether |login| is a collaborator of owner/repo.
+// Results are cached for collaboratorTTL (or nonCollaboratorTTL), errors are
+// not cached, but back off lookups (see collaboratorMinBackoff).
+func isCollaborator(ctx context.Context, client *apiClient, owner, repo, login string) (bool, error) {
+	key := owner + "/" + repo + "/" + login
+	if collaborator, ok := collaborators.Get(ctx, key); ok {
+		return collaborator, nil
+	}
+
+	collaboratorCallsMu.Lock()
+	if backoff, ok := collaboratorBackoffs[key]; ok && collaboratorNow().Before(backoff.until) {
+		collaboratorCallsMu.Unlock()
+		return false, fmt.Errorf("not retrying until %s after %d failed lookups: %w",
+			backoff.until.Format(time.RFC3339), backoff.failures, backoff.err)
+	}
+	if call, ok := collaboratorCalls[key]; ok {
+		collaboratorCallsMu.Unlock()
+		select {
+		case <-call.done:
+			return call.collaborator, call.err
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+	call := &collaboratorCall{done: make(chan struct{})}
+	collaboratorCalls[key] = call
+	collaboratorCallsMu.Unlock()
+
+	defer func() {
+		collaboratorCallsMu.Lock()
+		delete(collaboratorCalls, key)
+		collaboratorCallsMu.Unlock()
+		close(call.done)
+	}()
+
+	collaborator, resp, err := client.Repositories.IsCollaborator(ctx, owner, repo, login)
+	collaboratorCallsMu.Lock()
+	if err != nil {
+		backoff, ok := collaboratorBackoffs[key]
+		if !ok {
+			backoff = &collaboratorBackoff{}
+			collaboratorBackoffs[key] = backoff
+		}
+		backoff.failures++
+		backoff.until = collaboratorNow().Add(backoff.delay())
+		backoff.err = err
+	} else {
+		delete(collaboratorBackoffs, key)
+	}
+	collaboratorCallsMu.Unlock()
+	if err != nil {
+		call.err = err
+		return false, err
+	}
+	discardResponse(resp)
+
+	ttl := nonCollaboratorTTL
+	if collaborator {
+		ttl = collaboratorTTL
+	}
+	collaborators.Set(ctx, key, collaborator, ttl)
+	call.collaborator = collaborator
+	return collaborator, nil
+}
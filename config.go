@@ -0,0 +1,1077 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+	"unicode"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// Config contains the settings which can be changed at runtime via
+// /update_config, i.e. without a redeploy. It is stored as JSON in datastore.
+type Config struct {
+	// BacktracePatterns are regular expressions matching i3 crash output
+	// (e.g. backtraces) in uploaded log files. Defaults to
+	// defaultBacktracePatterns when empty.
+	BacktracePatterns []string `json:"backtrace_patterns,omitempty"`
+
+	// ClassificationPatterns are regular expressions matched against the
+	// lower-cased issue body to classify issues, keyed by “enhancement”,
+	// “documentation” and “new_configuration” (the “requires new
+	// configuration” checkbox of feature requests). Patterns which are not
+	// specified use defaultClassificationPatterns, so that e.g. template
+	// changes can be followed without a redeploy.
+	ClassificationPatterns map[string]string `json:"classification_patterns,omitempty"`
+
+	// ReopenedMaxAgeMonths is the age (in months) beyond which reopened
+	// issues are labeled needs-manual-triage instead of being re-evaluated
+	// (and possibly closed again). 0 disables the limit.
+	ReopenedMaxAgeMonths int `json:"reopened_max_age_months"`
+
+	// ReopenSupportedVersions re-opens issues which were closed because of an
+	// unsupported version when the reporter comments with a supported one.
+	ReopenSupportedVersions bool `json:"reopen_supported_versions"`
+
+	// MinLogLinePercent is the percentage of lines which need to look like i3
+	// log lines for an upload to be accepted. By default (0), uploads are
+	// accepted if any line looks like an i3 log line, so that logs with many
+	// other lines (e.g. journald exports) are not rejected.
+	MinLogLinePercent int `json:"min_log_line_percent"`
+
+	// OldIssuesBefore (e.g. “2016-01-01”) and OldIssueVersion (e.g. “4.11”)
+	// identify ancient issues: issues opened before the date about a version
+	// older than OldIssueVersion. Comments on them get the stale-old-version
+	// label and a comment asking for a new issue, instead of going through
+	// the version checks. Empty disables.
+	OldIssuesBefore string `json:"old_issues_before,omitempty"`
+	OldIssueVersion string `json:"old_issue_version,omitempty"`
+
+	// ReleasedMilestonePatterns are, per repository (e.g. “i3/i3”), regular
+	// expressions (e.g. “^\d+\.\d+$”) matching the titles of milestones
+	// which correspond to releases. The highest matching milestone (open or
+	// closed) is the latest release. Repositories without a pattern use the
+	// most recently due closed milestone.
+	ReleasedMilestonePatterns map[string]string `json:"released_milestone_patterns,omitempty"`
+
+	// NextMilestonePatterns are, per repository (e.g. “i3/i3”), regular
+	// expressions matching the titles of open milestones (e.g. “^4\.24$” or
+	// “^next$”). Bug reports for the latest release are assigned the matching
+	// open milestone which is due first, unless they have a milestone
+	// already. Repositories without a pattern are not assigned milestones.
+	NextMilestonePatterns map[string]string `json:"next_milestone_patterns,omitempty"`
+
+	// SupportedVersions are, per repository (e.g. “i3/i3”), versions which
+	// are supported in addition to the latest release, e.g. “4.22.1” when a
+	// fix was backported. Entries match the reported major version (“4.22”)
+	// or full version (“4.22.1”).
+	SupportedVersions map[string][]string `json:"supported_versions,omitempty"`
+
+	// CloseNotificationURL receives a JSON notification (compatible with
+	// Slack incoming webhooks) whenever the bot closes an issue because of an
+	// unsupported version, so that false positives are noticed quickly. Empty
+	// disables notifications.
+	CloseNotificationURL string `json:"close_notification_url,omitempty"`
+
+	// ShortLogURLs makes uploaded logs available under a short random slug
+	// instead of their (long and sequential) datastore ID.
+	ShortLogURLs bool `json:"short_log_urls"`
+
+	// RequiresConfigurationComment is posted (in addition to the general
+	// feature request comment) when the requires-configuration label is
+	// applied. Empty disables the comment.
+	RequiresConfigurationComment string `json:"requires_configuration_comment,omitempty"`
+
+	// FeatureTriageLabel (e.g. “triage/feature”) is additionally applied to
+	// feature requests, so that they show up in a filtered view. Empty
+	// disables it.
+	FeatureTriageLabel string `json:"feature_triage_label,omitempty"`
+
+	// SyncVersionLabels removes the missing-version and unsupported-version
+	// labels when a maintainer adds a version label (e.g. “4.23”), and adds
+	// missing-version back when the version label is removed again.
+	SyncVersionLabels bool `json:"sync_version_labels"`
+
+	// OnboardingComment posts a single comment explaining how to obtain the
+	// version and a debug log for bug reports which lack both, instead of
+	// one comment for each.
+	OnboardingComment bool `json:"onboarding_comment"`
+
+	// ReaddMissingLog adds the missing-log label back when the reporter edits
+	// the log link out of the body of an open bug report.
+	ReaddMissingLog bool `json:"readd_missing_log"`
+
+	// TriageSummary posts a single comment with a checklist of everything
+	// that is missing from a new bug report (e.g. config, log and version)
+	// instead of one comment each.
+	TriageSummary bool `json:"triage_summary"`
+
+	// SkipTitlePrefixes and SkipLabels exclude issues from processing, e.g.
+	// issues opened by automation with an “[auto]” title prefix. Prefixes
+	// are matched case-insensitively.
+	SkipTitlePrefixes []string `json:"skip_title_prefixes,omitempty"`
+	SkipLabels        []string `json:"skip_labels,omitempty"`
+
+	// RedirectWrongRepository closes issues about another program (e.g. an
+	// i3lock issue filed in the i3 repository), pointing reporters to the
+	// right tracker (see ProgramRepos) using RedirectComments.
+	RedirectWrongRepository bool `json:"redirect_wrong_repository"`
+
+	// RedirectComments are the comments used by RedirectWrongRepository,
+	// keyed by program. Programs which are not specified get a comment
+	// linking to their ProgramRepos entry.
+	RedirectComments map[string]string `json:"redirect_comments,omitempty"`
+
+	// ProgramRepos maps programs (as found by extractVersions, e.g.
+	// “i3lock”) to the repository in which their issues are filed, see
+	// RedirectWrongRepository. Defaults to defaultProgramRepos when nil.
+	ProgramRepos map[string]ProgramRepo `json:"program_repos,omitempty"`
+
+	// NeedsConfigRepos are the repositories (e.g. “i3/i3”) in which bug
+	// reports without an i3 config block get the needs-config label.
+	NeedsConfigRepos []string `json:"needs_config_repos,omitempty"`
+
+	// OptInLabels are, per repository (e.g. “i3/i3”), labels (e.g.
+	// “bot:manage”) which issues must carry before the bot acts on issue
+	// events. Issues are evaluated once a maintainer adds the label.
+	// Repositories without an entry are managed without opt-in.
+	OptInLabels map[string]string `json:"opt_in_labels,omitempty"`
+
+	// DistroLabelRepos are the repositories (e.g. “i3/i3”) in which bug
+	// reports naming a known distribution (e.g. “Distro: Arch Linux”) get a
+	// label such as “distro:arch”.
+	DistroLabelRepos []string `json:"distro_label_repos,omitempty"`
+
+	// GreetingRepos are the repositories (e.g. “i3/i3”) in which issues by
+	// first-time contributors are greeted with GreetingComment.
+	GreetingRepos   []string `json:"greeting_repos,omitempty"`
+	GreetingComment string   `json:"greeting_comment"`
+
+	// ComponentMarkers are, per repository (e.g. “i3/i3”), markers of issue
+	// templates for other components, such as a ticked “[x] i3status”
+	// checkbox. Issues containing a marker get the wrong-component label and
+	// the marker’s comment. Defaults to defaultComponentMarkers when nil.
+	ComponentMarkers map[string][]ComponentMarker `json:"component_markers,omitempty"`
+
+	// WrongProjects are, per repository (e.g. “i3/i3”), other projects whose
+	// issues are sometimes filed in the repository, such as Sway. Issues
+	// showing enough of a project’s signals get the wrong-project label and
+	// its comment, and their version is not verified. Defaults to
+	// defaultWrongProjects when nil.
+	WrongProjects map[string][]WrongProject `json:"wrong_projects,omitempty"`
+
+	// TriageRules are, per repository (e.g. “i3/i3”), recurring triage
+	// responses, e.g. for known problems with the NVIDIA proprietary
+	// driver. Newly opened issues matching a rule get its label and, when
+	// the label was added, its comment. Only the first matching rule is
+	// applied unless TriageRulesApplyAll is set.
+	TriageRules map[string][]TriageRule `json:"triage_rules,omitempty"`
+
+	// TriageRulesApplyAll applies all matching TriageRules instead of only
+	// the first one.
+	TriageRulesApplyAll bool `json:"triage_rules_apply_all"`
+
+	// FormLabels are, per repository (e.g. “i3/i3”), issue form fields
+	// whose answers are turned into labels when an issue is opened, e.g.
+	// severity “Crash” into “severity:crash”. Defaults to defaultFormLabels
+	// when nil.
+	FormLabels map[string][]FormLabel `json:"form_labels,omitempty"`
+
+	// LabelComments maps label names (e.g. “needs-config”) to comment
+	// templates (text/template, see labelCommentData) which the bot posts
+	// once per issue when it adds the label.
+	LabelComments map[string]string `json:"label_comments,omitempty"`
+
+	// CommentDedupeLookback is the number of most recent comments on an
+	// issue which are checked before the bot comments: if one of them is a
+	// comment by the bot which is (nearly) identical, e.g. because the same
+	// check was triggered by the issue body and a comment, the new comment is
+	// not posted. The check costs an API request per comment, so it is
+	// disabled (0) by default.
+	CommentDedupeLookback int `json:"comment_dedupe_lookback"`
+
+	// BotLogin is the login of the account as which the bot comments. Comments
+	// by GitHub Apps are recognized as bot comments regardless.
+	BotLogin string `json:"bot_login,omitempty"`
+
+	// EvaluationDelaySeconds defers evaluating newly opened issues (using
+	// the task queue), so that reporters who edit their issue right away to
+	// add the version or log are not asked for them. The issue is fetched
+	// again after the delay. 0 evaluates issues immediately.
+	EvaluationDelaySeconds int `json:"evaluation_delay_seconds"`
+
+	// ReproductionReminderDays is how long bug reports (issues with the
+	// “bug” label) may go without a comment by a maintainer before the bot
+	// adds the needs-reproduction label (using the task queue), at most 30.
+	// 0 disables the reminder.
+	ReproductionReminderDays int `json:"reproduction_reminder_days"`
+
+	// Scope limits which webhook events the bot acts on, e.g. for staged
+	// rollouts: “issues” (issue events only), “comments” (issue_comment
+	// events only) or “all” (the default). Events outside of the scope are
+	// verified and acknowledged, but ignored.
+	Scope string `json:"scope"`
+
+	// KeepOpenLabel is a label (default “keep-open”) with which maintainers
+	// mark issues that must not be closed for reporting an unsupported
+	// version, e.g. long-standing design bugs. Such issues are only labeled.
+	// The empty string disables the check.
+	KeepOpenLabel string `json:"keep_open_label"`
+
+	// MaxAutoClosesPerHour caps the number of issues closed per hour (across
+	// all repositories) for reporting an unsupported version, e.g. when a new
+	// release makes many issues outdated at once. Beyond the cap, issues get
+	// the auto-close-deferred label and are closed in a later hour (using the
+	// task queue). 0 means no cap.
+	MaxAutoClosesPerHour int `json:"max_auto_closes_per_hour"`
+
+	// FileStatuses are, per repository (e.g. “i3/i3”), commit statuses which
+	// are set on pull requests modifying matching files, e.g. to remind
+	// contributors to regenerate the parser.
+	FileStatuses map[string][]FileStatus `json:"file_statuses,omitempty"`
+
+	// RemoteLogs enables downloading links to other hosts than logs.i3wm.org
+	// (e.g. paste services) to check whether they contain an i3 log before
+	// asking the reporter for one.
+	RemoteLogs bool `json:"remote_logs"`
+
+	// RemoteLogHosts are the hosts (e.g. “pastebin.com”, which includes its
+	// subdomains) whose links RemoteLogs downloads. Links to other hosts, IP
+	// addresses, internal hostnames and non-default ports are never
+	// downloaded.
+	RemoteLogHosts []string `json:"remote_log_hosts"`
+
+	// MaxBodyBytes caps the amount of issue or comment text which the
+	// classification and version regexps are run on: of longer texts (e.g.
+	// issues filed by crash reporters), only the first and last MaxBodyBytes/2
+	// bytes are considered, see regexpWindow. Versions or log links in the
+	// middle of such texts are not found. 0 disables the cap.
+	MaxBodyBytes int `json:"max_body_bytes"`
+
+	// RequestBudgetSeconds bounds the time spent on outbound operations
+	// (GitHub API calls, Cloud Storage reads and writes) per request, so that
+	// slow dependencies result in a 503 instead of hitting the App Engine
+	// request deadline. 0 disables the limit.
+	RequestBudgetSeconds int `json:"request_budget_seconds"`
+
+	// LogIssueRepos are the repositories (e.g. “i3/i3”) whose issues the log
+	// link can be posted to when uploading a log with the issue parameter.
+	LogIssueRepos []string `json:"log_issue_repos"`
+
+	// UserAgentContact is who runs the bot, e.g. “github.com/stapelberg”,
+	// as stated in the User-Agent of requests to GitHub, which GitHub uses
+	// to contact the operator about problematic requests. Empty uses the
+	// default.
+	UserAgentContact string `json:"user_agent_contact"`
+
+	backtraceRegexps      []*regexp.Regexp
+	classificationRegexps map[string]*regexp.Regexp
+	oldIssuesBefore       time.Time
+	releasedMilestones    map[string]*regexp.Regexp
+	nextMilestones        map[string]*regexp.Regexp
+	labelComments         map[string]*template.Template
+}
+
+// ComponentMarker identifies issues about another component, see
+// Config.ComponentMarkers.
+type ComponentMarker struct {
+	// Pattern is a regular expression matched against the lower-cased issue
+	// body.
+	Pattern string `json:"pattern"`
+	Comment string `json:"comment"`
+
+	re *regexp.Regexp
+}
+
+// WrongProject identifies issues about another project, see
+// Config.WrongProjects.
+type WrongProject struct {
+	// Signals are regular expressions matched against the lower-cased issue
+	// title and body.
+	Signals []string `json:"signals"`
+	// MinSignals is the number of different signals which need to match
+	// (default 2), so that e.g. comparing i3 to Sway does not count.
+	MinSignals int    `json:"min_signals"`
+	Comment    string `json:"comment"`
+
+	res []*regexp.Regexp
+}
+
+// ProgramRepo is where issues about a program are filed, see
+// Config.ProgramRepos.
+type ProgramRepo struct {
+	// Repo is the repository, e.g. “i3/i3lock”.
+	Repo string `json:"repo"`
+	// Name is the program’s name as used in comments, e.g. “i3lock”.
+	Name string `json:"name"`
+}
+
+// TriageRule is a recurring triage response, see Config.TriageRules.
+type TriageRule struct {
+	// Pattern is a regular expression matched against the lower-cased
+	// issue title and body.
+	Pattern string `json:"pattern"`
+	Label   string `json:"label"`
+	// Comment is optional.
+	Comment string `json:"comment"`
+
+	re *regexp.Regexp
+}
+
+// FormLabel labels issues by their answer to an issue form field, see
+// Config.FormLabels.
+type FormLabel struct {
+	// Field is matched against the field headings like formField does, e.g.
+	// “severity” matches “### Severity of the problem”.
+	Field string `json:"field"`
+	// Labels maps the allowed answers (case-insensitively) to the label they
+	// add. Other answers, e.g. free text, are ignored, so that reporters
+	// cannot create arbitrary labels.
+	Labels map[string]string `json:"labels"`
+}
+
+// FileStatus is a commit status for pull requests modifying certain files, see
+// Config.FileStatuses. Commit statuses cannot be neutral, so the status is
+// “success”: it informs, but never blocks merging.
+type FileStatus struct {
+	// Pattern is a regular expression matched against the paths of the
+	// modified files, e.g. “^parser-specs/”.
+	Pattern string `json:"pattern"`
+	// Context names the status. Statuses with the same context replace each
+	// other. Defaults to “i3-github-bot”.
+	Context string `json:"context"`
+	// Description is shown next to the status (at most 140 characters).
+	Description string `json:"description"`
+	// TargetURL is linked from the status, e.g. to contribution guidelines.
+	TargetURL string `json:"target_url"`
+
+	re *regexp.Regexp
+}
+
+// maxStatusDescriptionLength is the maximum length of a commit status
+// description accepted by GitHub.
+const maxStatusDescriptionLength = 140
+
+// configEntity is how Config is stored in datastore.
+type configEntity struct {
+	JSON string `datastore:",noindex"`
+}
+
+var defaultBacktracePatterns = []string{
+	// i3’s own crash handler
+	`i3 just crashed`,
+	`Received signal [0-9]+`,
+	// gdb backtrace frames, e.g. “#0  0x00007f… in raise () from …”
+	`(?m)^#[0-9]+\s+0x[0-9a-fA-F]+ in `,
+	`Assertion .+ failed`,
+}
+
+// defaultClassificationPatterns match the checkboxes of our issue template.
+// There is no default enhancement pattern: feature requests are recognized by
+// their label or issue form.
+var defaultClassificationPatterns = map[string]string{
+	"documentation":     `\[\s*x\s*\]\s*documentation\s*request`,
+	"new_configuration": `\[\s*x\s*\]\s*this\s*feature\s*requires\s*new\s*configuration`,
+}
+
+var defaultComponentMarkers = map[string][]ComponentMarker{
+	"i3/i3": {
+		{
+			Pattern: `\[\s*x\s*\]\s*i3status`,
+			Comment: "This looks like an issue with i3status, which is developed in a separate repository. " +
+				"Please file it at https://github.com/i3/i3status/issues instead.",
+		},
+		{
+			Pattern: `\[\s*x\s*\]\s*i3bar`,
+			Comment: "This looks like an issue with i3bar. Please make sure to describe your bar " +
+				"configuration and the status line program (e.g. i3status) you are using.",
+		},
+	},
+}
+
+var defaultFormLabels = map[string][]FormLabel{
+	"i3/i3": {
+		{
+			Field: "severity",
+			Labels: map[string]string{
+				"crash":     "severity:crash",
+				"annoyance": "severity:annoyance",
+			},
+		},
+		{
+			Field: "frequency",
+			Labels: map[string]string{
+				"always":    "frequency:always",
+				"sometimes": "frequency:sometimes",
+			},
+		},
+	},
+}
+
+var defaultWrongProjects = map[string][]WrongProject{
+	"i3/i3": {
+		{
+			Signals: []string{
+				// e.g. “sway version 1.8.1”
+				`\bsway(?:msg|bar|bg|idle|lock|nag)?:?\s+(?:version\s+)?v?1\.[0-9]+`,
+				`\bswaymsg\b`,
+				`(?:\.config|/etc)/sway\b`,
+				`\bwlroots\b`,
+				`xdg_session_type=wayland|wayland_display=`,
+				`\bwayland\b`,
+			},
+			Comment: "This looks like an issue with Sway (or another Wayland compositor) rather than i3. " +
+				"i3 only supports X11. Please file Sway issues at https://github.com/swaywm/sway/issues instead.",
+		},
+	},
+}
+
+// defaultUserAgentContact is the default Config.UserAgentContact.
+const defaultUserAgentContact = "github.com/stapelberg"
+
+// Values of Config.Scope.
+const (
+	scopeAll      = "all"
+	scopeIssues   = "issues"
+	scopeComments = "comments"
+)
+
+var defaultProgramRepos = map[string]ProgramRepo{
+	"i3":       {Repo: "i3/i3", Name: "i3"},
+	"i3bar":    {Repo: "i3/i3", Name: "i3bar"},
+	"i3status": {Repo: "i3/i3status", Name: "i3status"},
+	"i3lock":   {Repo: "i3/i3lock", Name: "i3lock"},
+}
+
+// redirectCommentTemplate is the RedirectWrongRepository comment for programs
+// without RedirectComments entry, formatted with the program’s ProgramRepo
+// name and repository.
+const redirectCommentTemplate = "This looks like an issue with %s, which is developed in a separate repository. " +
+	"Please file it at https://github.com/%s/issues instead."
+
+// configMaxAge is how long the cached configuration is used before it is
+// read from datastore again, so that updates made on other instances (see
+// updateConfigHandler) are picked up.
+const configMaxAge = 10 * time.Minute
+
+var (
+	configMu sync.RWMutex
+	// config is the configuration cached by getConfig, read from datastore at
+	// configLoaded.
+	config       *Config
+	configLoaded time.Time
+)
+
+// setConfig caches |cfg| for getConfig.
+func setConfig(cfg *Config) {
+	configMu.Lock()
+	defer configMu.Unlock()
+	config = cfg
+	configLoaded = time.Now()
+}
+
+// loadConfigEntity reads the stored configuration from datastore. Tests
+// replace it.
+var loadConfigEntity = func(ctx context.Context) (configEntity, error) {
+	var e configEntity
+	err := datastore.Get(ctx, configKey(ctx), &e)
+	return e, err
+}
+
+const updateConfigForm = `
+<html>
+<body>
+<p>%s</p>
+<form action="/update_config" method="post">
+<label for="config">Config (JSON):</label><br>
+<textarea name="config" id="config" rows="30" cols="100">%s</textarea><br>
+<input type="submit" value="Update config">
+</form>
+</body>
+</html>
+`
+
+// compileRegexps compiles all patterns, returning an error which names the
+// first pattern that does not compile.
+func compileRegexps(patterns []string) ([]*regexp.Regexp, error) {
+	res := make([]*regexp.Regexp, len(patterns))
+	for idx, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+		res[idx] = re
+	}
+	return res, nil
+}
+
+// parseConfig parses the JSON-encoded configuration in b and validates it,
+// i.e. makes sure all patterns compile.
+func parseConfig(b []byte) (*Config, error) {
+	// Defaults for settings which are not specified:
+	cfg := &Config{
+		ReopenedMaxAgeMonths: 12,
+		LogIssueRepos:        []string{"i3/i3"},
+		// A copy, as json.Unmarshal reuses the backing array.
+		RemoteLogHosts:       append([]string(nil), defaultRemoteLogHosts...),
+		RequestBudgetSeconds: 50,
+		MaxBodyBytes:         64 << 10,
+		KeepOpenLabel:        "keep-open",
+		Scope:                scopeAll,
+		UserAgentContact:     defaultUserAgentContact,
+		GreetingComment: "Welcome, and thanks for your first contribution to i3! " +
+			"The comments below are automated checks which make sure we have everything " +
+			"we need to look into this.",
+	}
+	if len(bytes.TrimSpace(b)) > 0 {
+		if err := json.Unmarshal(b, cfg); err != nil {
+			return nil, fmt.Errorf("Cannot parse JSON: %v", err)
+		}
+	}
+	if err := cfg.compile(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (c *Config) compile() error {
+	if c.OldIssuesBefore != "" {
+		var err error
+		if c.oldIssuesBefore, err = time.Parse("2006-01-02", c.OldIssuesBefore); err != nil {
+			return fmt.Errorf("old_issues_before: %v", err)
+		}
+		if c.OldIssueVersion == "" {
+			return fmt.Errorf("old_issues_before requires old_issue_version")
+		}
+	}
+	if c.MinLogLinePercent < 0 || c.MinLogLinePercent > 100 {
+		return fmt.Errorf("min_log_line_percent: %d is not a percentage", c.MinLogLinePercent)
+	}
+	if c.EvaluationDelaySeconds < 0 {
+		return fmt.Errorf("evaluation_delay_seconds: must not be negative")
+	}
+	switch c.Scope {
+	case scopeAll, scopeIssues, scopeComments:
+	default:
+		return fmt.Errorf("scope: %q is not one of %q, %q or %q", c.Scope, scopeAll, scopeIssues, scopeComments)
+	}
+	if strings.IndexFunc(c.UserAgentContact, unicode.IsControl) != -1 {
+		return fmt.Errorf("user_agent_contact: %q contains control characters", c.UserAgentContact)
+	}
+	hosts := make([]string, 0, len(c.RemoteLogHosts))
+	for _, host := range c.RemoteLogHosts {
+		normalized := strings.ToLower(strings.TrimSpace(host))
+		if normalized == "" || strings.ContainsAny(normalized, "/:@") {
+			return fmt.Errorf("remote_log_hosts: %q is not a hostname", host)
+		}
+		hosts = append(hosts, normalized)
+	}
+	c.RemoteLogHosts = hosts
+	if c.ReproductionReminderDays < 0 || c.ReproductionReminderDays > maxReproductionReminderDays {
+		return fmt.Errorf("reproduction_reminder_days: must be between 0 and %d", maxReproductionReminderDays)
+	}
+	if c.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes: must not be negative")
+	}
+	if c.CommentDedupeLookback < 0 {
+		return fmt.Errorf("comment_dedupe_lookback: must not be negative")
+	}
+	patterns := c.BacktracePatterns
+	if len(patterns) == 0 {
+		patterns = defaultBacktracePatterns
+	}
+	var err error
+	if c.backtraceRegexps, err = compileRegexps(patterns); err != nil {
+		return fmt.Errorf("backtrace_patterns: %v", err)
+	}
+	for kind := range c.ClassificationPatterns {
+		switch kind {
+		case "enhancement", "documentation", "new_configuration":
+		default:
+			return fmt.Errorf("classification_patterns: unknown kind %q", kind)
+		}
+	}
+	c.classificationRegexps = make(map[string]*regexp.Regexp)
+	for _, kind := range []string{"enhancement", "documentation", "new_configuration"} {
+		pattern, ok := c.ClassificationPatterns[kind]
+		if !ok {
+			pattern = defaultClassificationPatterns[kind]
+		}
+		if pattern == "" {
+			continue
+		}
+		if c.classificationRegexps[kind], err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("classification_patterns: invalid %s pattern %q: %v", kind, pattern, err)
+		}
+	}
+	c.releasedMilestones = make(map[string]*regexp.Regexp, len(c.ReleasedMilestonePatterns))
+	for repo, pattern := range c.ReleasedMilestonePatterns {
+		if c.releasedMilestones[repo], err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("released_milestone_patterns: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	c.nextMilestones = make(map[string]*regexp.Regexp, len(c.NextMilestonePatterns))
+	for repo, pattern := range c.NextMilestonePatterns {
+		if c.nextMilestones[repo], err = regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("next_milestone_patterns: invalid pattern %q: %v", pattern, err)
+		}
+	}
+	c.labelComments = make(map[string]*template.Template, len(c.LabelComments))
+	for label, text := range c.LabelComments {
+		if c.labelComments[label], err = template.New(label).Parse(text); err != nil {
+			return fmt.Errorf("label_comments: invalid template for %q: %v", label, err)
+		}
+	}
+	fileStatuses := make(map[string][]FileStatus, len(c.FileStatuses))
+	for repo, statuses := range c.FileStatuses {
+		for _, status := range statuses {
+			if status.re, err = regexp.Compile(status.Pattern); err != nil {
+				return fmt.Errorf("file_statuses: invalid pattern %q: %v", status.Pattern, err)
+			}
+			if len(status.Description) > maxStatusDescriptionLength {
+				return fmt.Errorf("file_statuses: description %q is longer than %d characters", status.Description, maxStatusDescriptionLength)
+			}
+			if status.Context == "" {
+				status.Context = "i3-github-bot"
+			}
+			fileStatuses[repo] = append(fileStatuses[repo], status)
+		}
+	}
+	c.FileStatuses = fileStatuses
+	if c.ComponentMarkers == nil {
+		c.ComponentMarkers = defaultComponentMarkers
+	}
+	compiled := make(map[string][]ComponentMarker, len(c.ComponentMarkers))
+	for repo, markers := range c.ComponentMarkers {
+		for _, marker := range markers {
+			if marker.re, err = regexp.Compile(marker.Pattern); err != nil {
+				return fmt.Errorf("component_markers: invalid pattern %q: %v", marker.Pattern, err)
+			}
+			compiled[repo] = append(compiled[repo], marker)
+		}
+	}
+	c.ComponentMarkers = compiled
+	if c.WrongProjects == nil {
+		c.WrongProjects = defaultWrongProjects
+	}
+	wrongProjects := make(map[string][]WrongProject, len(c.WrongProjects))
+	for repo, projects := range c.WrongProjects {
+		for _, project := range projects {
+			if project.res, err = compileRegexps(project.Signals); err != nil {
+				return fmt.Errorf("wrong_projects: %v", err)
+			}
+			if project.MinSignals == 0 {
+				project.MinSignals = 2
+			}
+			wrongProjects[repo] = append(wrongProjects[repo], project)
+		}
+	}
+	c.WrongProjects = wrongProjects
+	if c.ProgramRepos == nil {
+		c.ProgramRepos = defaultProgramRepos
+	}
+	for program, target := range c.ProgramRepos {
+		if _, _, ok := splitRepo(target.Repo); !ok {
+			return fmt.Errorf("program_repos: invalid repo %q for %q, expected e.g. i3/i3", target.Repo, program)
+		}
+	}
+	triageRules := make(map[string][]TriageRule, len(c.TriageRules))
+	for repo, rules := range c.TriageRules {
+		for _, rule := range rules {
+			if rule.Label == "" {
+				return fmt.Errorf("triage_rules: rule %q has no label", rule.Pattern)
+			}
+			if rule.re, err = regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("triage_rules: invalid pattern %q: %v", rule.Pattern, err)
+			}
+			triageRules[repo] = append(triageRules[repo], rule)
+		}
+	}
+	c.TriageRules = triageRules
+	if c.FormLabels == nil {
+		c.FormLabels = defaultFormLabels
+	}
+	formLabels := make(map[string][]FormLabel, len(c.FormLabels))
+	for repo, fields := range c.FormLabels {
+		for _, field := range fields {
+			if field.Field == "" {
+				return fmt.Errorf("form_labels: %s: field name missing", repo)
+			}
+			labels := make(map[string]string, len(field.Labels))
+			for answer, label := range field.Labels {
+				if label == "" {
+					return fmt.Errorf("form_labels: %s: no label for %q answer %q", repo, field.Field, answer)
+				}
+				labels[strings.ToLower(strings.TrimSpace(answer))] = label
+			}
+			formLabels[repo] = append(formLabels[repo], FormLabel{
+				Field:  strings.ToLower(field.Field),
+				Labels: labels,
+			})
+		}
+	}
+	c.FormLabels = formLabels
+	return nil
+}
+
+// formLabels returns the labels for the answers in |body|, if it was created
+// from an issue form, according to |repo|’s FormLabels.
+func (c *Config) formLabels(repo *github.Repository, body string) []string {
+	fields := parseIssueForm(body)
+	if fields == nil {
+		return nil
+	}
+	var labels []string
+	for _, field := range c.FormLabels[repoKey(repo)] {
+		answer, ok := formField(fields, field.Field)
+		if !ok {
+			continue
+		}
+		if label, ok := field.Labels[strings.ToLower(answer)]; ok {
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// componentMarker returns the first of |repo|’s ComponentMarkers which matches
+// |body|, or nil.
+func (c *Config) componentMarker(repo *github.Repository, body string) *ComponentMarker {
+	lcBody := strings.ToLower(body)
+	for _, marker := range c.ComponentMarkers[repoKey(repo)] {
+		if marker.re.MatchString(lcBody) {
+			return &marker
+		}
+	}
+	return nil
+}
+
+// wrongProject returns the first of |repo|’s WrongProjects of which |issue|
+// shows at least MinSignals signals, or nil.
+func (c *Config) wrongProject(repo *github.Repository, issue *github.Issue, body string) *WrongProject {
+	text := strings.ToLower(issue.GetTitle() + "\n" + body)
+	for _, project := range c.WrongProjects[repoKey(repo)] {
+		matched := 0
+		for _, re := range project.res {
+			if re.MatchString(text) {
+				matched++
+			}
+		}
+		if matched >= project.MinSignals {
+			return &project
+		}
+	}
+	return nil
+}
+
+// triageRules returns |repo|’s TriageRules which match |issue| (see
+// TriageRulesApplyAll).
+func (c *Config) triageRules(repo *github.Repository, issue *github.Issue, body string) []TriageRule {
+	text := strings.ToLower(issue.GetTitle() + "\n" + body)
+	var matched []TriageRule
+	for _, rule := range c.TriageRules[repoKey(repo)] {
+		if !rule.re.MatchString(text) {
+			continue
+		}
+		matched = append(matched, rule)
+		if !c.TriageRulesApplyAll {
+			break
+		}
+	}
+	return matched
+}
+
+// classificationMatches returns whether the ClassificationPatterns entry for
+// |kind| matches |lcBody|, the lower-cased issue body.
+func (c *Config) classificationMatches(kind, lcBody string) bool {
+	re, ok := c.classificationRegexps[kind]
+	return ok && re.MatchString(lcBody)
+}
+
+// isOldIssue returns whether |issue| (filed in the repository of |program|)
+// is an ancient issue, see OldIssuesBefore.
+func (c *Config) isOldIssue(issue *github.Issue, program string) bool {
+	if c.oldIssuesBefore.IsZero() || !issue.GetCreatedAt().Before(c.oldIssuesBefore) {
+		return false
+	}
+	matches := extractIssueVersion(c.matchText(issue.GetBody()), program)
+	return len(matches) > 0 && compareVersions(matches[2], c.OldIssueVersion) < 0
+}
+
+// releasedMilestoneRegexp returns |repo|’s compiled ReleasedMilestonePatterns
+// entry, or nil.
+func (c *Config) releasedMilestoneRegexp(repo *github.Repository) *regexp.Regexp {
+	return c.releasedMilestones[repoKey(repo)]
+}
+
+// nextMilestoneRegexp returns |repo|’s compiled NextMilestonePatterns entry,
+// or nil.
+func (c *Config) nextMilestoneRegexp(repo *github.Repository) *regexp.Regexp {
+	return c.nextMilestones[repoKey(repo)]
+}
+
+// acceptsVersion returns whether the version in |matches| (as returned by
+// extractVersion) is one of |repo|’s SupportedVersions.
+func (c *Config) acceptsVersion(repo *github.Repository, matches []string) bool {
+	for _, version := range c.SupportedVersions[repoKey(repo)] {
+		if version == matches[2] || version == matches[3] {
+			return true
+		}
+	}
+	return false
+}
+
+// hasBacktrace returns whether the (uncompressed) log contains i3 crash
+// output.
+func (c *Config) hasBacktrace(log []byte) bool {
+	for _, re := range c.backtraceRegexps {
+		if re.Match(log) {
+			return true
+		}
+	}
+	return false
+}
+
+// skipIssue returns whether the bot should leave |issue| alone, see
+// SkipTitlePrefixes and SkipLabels.
+func (c *Config) skipIssue(issue *github.Issue) bool {
+	title := strings.ToLower(issue.GetTitle())
+	for _, prefix := range c.SkipTitlePrefixes {
+		if strings.HasPrefix(title, strings.ToLower(prefix)) {
+			return true
+		}
+	}
+	for _, label := range c.SkipLabels {
+		if hasLabel(issue, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// repoKey returns the full name of |repo| (e.g. “i3/i3”), which keys the
+// per-repository configuration.
+func repoKey(repo *github.Repository) string {
+	return repo.GetOwner().GetLogin() + "/" + repo.GetName()
+}
+
+// inRepoList returns whether |repo| is contained in |list| (of full names,
+// e.g. “i3/i3”).
+func inRepoList(list []string, repo *github.Repository) bool {
+	fullName := repoKey(repo)
+	for _, name := range list {
+		if name == fullName {
+			return true
+		}
+	}
+	return false
+}
+
+// needsConfig returns whether bug reports in |repo| need to include an i3
+// config, see NeedsConfigRepos.
+func (c *Config) needsConfig(repo *github.Repository) bool {
+	return inRepoList(c.NeedsConfigRepos, repo)
+}
+
+// fileStatuses returns |repo|’s FileStatuses entry.
+func (c *Config) fileStatuses(repo *github.Repository) []FileStatus {
+	return c.FileStatuses[repoKey(repo)]
+}
+
+// optInLabel returns |repo|’s OptInLabels entry, or the empty string.
+func (c *Config) optInLabel(repo *github.Repository) string {
+	return c.OptInLabels[repoKey(repo)]
+}
+
+// userAgent returns the User-Agent for requests to GitHub, which requires a
+// meaningful one: an empty UserAgentContact falls back to the default.
+func (c *Config) userAgent() string {
+	contact := strings.TrimSpace(c.UserAgentContact)
+	if contact == "" {
+		contact = defaultUserAgentContact
+	}
+	return "i3-github-bot (run by " + contact + ")"
+}
+
+// handlesIssues returns whether issue events are in the bot’s Scope.
+func (c *Config) handlesIssues() bool {
+	return c.Scope != scopeComments
+}
+
+// handlesComments returns whether issue_comment events are in the bot’s
+// Scope.
+func (c *Config) handlesComments() bool {
+	return c.Scope != scopeIssues
+}
+
+// repoPrograms returns the programs whose issues are filed in |repo|, see
+// ProgramRepos. Repositories without entry are assumed to be named after
+// their program.
+func (c *Config) repoPrograms(repo *github.Repository) []string {
+	var programs []string
+	for program, target := range c.ProgramRepos {
+		if target.Repo == repoKey(repo) {
+			programs = append(programs, program)
+		}
+	}
+	if len(programs) == 0 {
+		programs = []string{repo.GetName()}
+	}
+	return programs
+}
+
+// redirectComment returns the comment pointing reporters to the repository of
+// |program| (see ProgramRepos), or the empty string if issues about |program|
+// are filed in |repo| or it has no ProgramRepos entry.
+func (c *Config) redirectComment(repo *github.Repository, program string) string {
+	target, ok := c.ProgramRepos[program]
+	if !ok || target.Repo == repoKey(repo) {
+		return ""
+	}
+	if comment, ok := c.RedirectComments[program]; ok {
+		return comment
+	}
+	return fmt.Sprintf(redirectCommentTemplate, target.Name, target.Repo)
+}
+
+// defaultConfig returns the configuration to use when none is stored.
+func defaultConfig() *Config {
+	cfg, err := parseConfig(nil)
+	if err != nil {
+		panic(fmt.Sprintf("default config invalid: %v", err))
+	}
+	return cfg
+}
+
+func configKey(ctx context.Context) *datastore.Key {
+	return datastore.NewKey(ctx, "Config", "config", 0, nil)
+}
+
+// getConfig returns the current configuration, loading it from datastore if
+// necessary. When no configuration was stored yet, the defaults are used.
+func getConfig(ctx context.Context) (*Config, error) {
+	configMu.RLock()
+	cached, loaded := config, configLoaded
+	configMu.RUnlock()
+	if cached != nil && time.Since(loaded) < configMaxAge {
+		return cached, nil
+	}
+	cfg, err := loadConfig(ctx)
+	if err != nil {
+		if cached != nil {
+			// Better to use a possibly stale configuration than the
+			// defaults.
+			errorf(ctx, "Could not load config, using cached config: %v", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	setConfig(cfg)
+	return cfg, nil
+}
+
+// loadConfig reads and parses the stored configuration.
+func loadConfig(ctx context.Context) (*Config, error) {
+	e, err := loadConfigEntity(ctx)
+	if err != nil && err != datastore.ErrNoSuchEntity {
+		return nil, err
+	}
+	return parseConfig([]byte(e.JSON))
+}
+
+// configOrDefault is like getConfig, but falls back to the default
+// configuration instead of failing the request.
+func configOrDefault(ctx context.Context) *Config {
+	cfg, err := getConfig(ctx)
+	if err != nil {
+		errorf(ctx, "getConfig: %v (using defaults)", err)
+		return defaultConfig()
+	}
+	return cfg
+}
+
+func updateConfigHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+
+	var e configEntity
+	if err := datastore.Get(ctx, configKey(ctx), &e); err != nil && err != datastore.ErrNoSuchEntity {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var msg string
+	if r.Method == "POST" {
+		e.JSON = r.FormValue("config")
+		cfg, err := parseConfig([]byte(e.JSON))
+		if err != nil {
+			// Show the form again (with the rejected config) so that the
+			// error can be corrected.
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, updateConfigForm, html.EscapeString(err.Error()), html.EscapeString(e.JSON))
+			return
+		}
+		if _, err := datastore.Put(ctx, configKey(ctx), &e); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setConfig(cfg)
+		msg = "Config updated."
+	}
+	fmt.Fprintf(w, updateConfigForm, msg, html.EscapeString(e.JSON))
+}
+
+// configHandler serves the configuration in use, i.e. with defaults applied,
+// as JSON.
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	if r.Method != "GET" {
+		http.Error(w, "Use GET", http.StatusMethodNotAllowed)
+		return
+	}
+	writeConfig(w, configOrDefault(ctx))
+}
+
+// writeConfig writes |cfg| as JSON, with secrets redacted: the
+// CloseNotificationURL contains the token of the Slack incoming webhook.
+func writeConfig(w http.ResponseWriter, cfg *Config) {
+	redacted := *cfg
+	if redacted.CloseNotificationURL != "" {
+		redacted.CloseNotificationURL = "(redacted)"
+	}
+	b, err := json.MarshalIndent(&redacted, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// healthzHandler reports that the bot is up, along with its Scope (so that
+// staged rollouts can be verified without administrator access) and the
+// result of the last token scope check (see checkTokenScopes).
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeHealthz(w, configOrDefault(appengine.NewContext(r)))
+}
+
+func writeHealthz(w http.ResponseWriter, cfg *Config) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "ok\nscope: %s\ntoken: %s\n", cfg.Scope, lastTokenScopes())
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine/memcache"
+)
+
+// commentSimilarityThreshold is the commentSimilarity at or above which a
+// comment is considered a repetition of an earlier one.
+const commentSimilarityThreshold = 0.9
+
+// commentSimilarity returns the Dice coefficient of the (lower-cased) words of
+// |a| and |b|: 1 for comments which differ only in whitespace and case, 0 for
+// comments without words in common.
+func commentSimilarity(a, b string) float64 {
+	wordsA := strings.Fields(strings.ToLower(a))
+	wordsB := strings.Fields(strings.ToLower(b))
+	if len(wordsA)+len(wordsB) == 0 {
+		return 1
+	}
+	counts := make(map[string]int, len(wordsA))
+	for _, word := range wordsA {
+		counts[word]++
+	}
+	common := 0
+	for _, word := range wordsB {
+		if counts[word] > 0 {
+			counts[word]--
+			common++
+		}
+	}
+	return float64(2*common) / float64(len(wordsA)+len(wordsB))
+}
+
+// isBotComment returns whether |comment| was written by the bot (see
+// Config.BotLogin) or another GitHub App.
+func (c *Config) isBotComment(comment *github.IssueComment) bool {
+	user := comment.GetUser()
+	return user.GetType() == "Bot" ||
+		(c.BotLogin != "" && user.GetLogin() == c.BotLogin)
+}
+
+// repeatsRecentComment returns whether one of the last CommentDedupeLookback
+// comments on the issue is a bot comment similar to |comment|.
+func (c *Config) repeatsRecentComment(ctx context.Context, client *apiClient, payload interface{}, comment string) (bool, error) {
+	recent, err := recentComments(ctx, client, payload, c.CommentDedupeLookback)
+	if err != nil {
+		return false, err
+	}
+	for _, prior := range recent {
+		if c.isBotComment(prior) && commentSimilarity(prior.GetBody(), comment) >= commentSimilarityThreshold {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recentComments returns (up to) the last |n| comments on the issue. The API
+// lists issue comments oldest first, so the pages are located using the
+// comment count of the issue.
+func recentComments(ctx context.Context, client *apiClient, payload interface{}, n int) ([]*github.IssueComment, error) {
+	repo, issue := getRepoAndIssue(payload)
+	const perPage = 100
+	var comments []*github.IssueComment
+	for page := (issue.GetComments() + perPage - 1) / perPage; page > 0 && len(comments) < n; page-- {
+		onPage, resp, err := client.Issues.ListComments(
+			ctx,
+			repo.GetOwner().GetLogin(),
+			repo.GetName(),
+			issue.GetNumber(),
+			&github.IssueListCommentsOptions{
+				ListOptions: github.ListOptions{Page: page, PerPage: perPage},
+			})
+		if err != nil {
+			return nil, err
+		}
+		discardResponse(resp)
+		comments = append(onPage, comments...)
+	}
+	if len(comments) > n {
+		comments = comments[len(comments)-n:]
+	}
+	return comments, nil
+}
+
+// commentGuard records which comments were posted on which issue, so that
+// addComment never posts the same comment twice, e.g. when an edited issue
+// triggers the same check again. Tests replace postedComments.
+type commentGuard interface {
+	// Record records |key| and returns false if it was recorded before.
+	Record(ctx context.Context, key string) (bool, error)
+	// Forget removes |key|, e.g. because posting the comment failed.
+	Forget(ctx context.Context, key string)
+}
+
+var postedComments commentGuard = memcacheCommentGuard{}
+
+// postedCommentTTL is how long memcacheCommentGuard remembers a comment. It
+// covers events triggering the same check in quick succession (e.g. opening
+// and immediately editing an issue), but allows deliberate reposts later on.
+const postedCommentTTL = 24 * time.Hour
+
+// memcacheCommentGuard implements commentGuard using App Engine memcache,
+// which is shared between instances. Evicted entries only weaken the guard;
+// the marker and similarity checks still apply.
+type memcacheCommentGuard struct{}
+
+func (memcacheCommentGuard) Record(ctx context.Context, key string) (bool, error) {
+	err := memcache.Add(ctx, &memcache.Item{
+		Key:        key,
+		Value:      []byte("1"),
+		Expiration: postedCommentTTL,
+	})
+	if err == memcache.ErrNotStored {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (memcacheCommentGuard) Forget(ctx context.Context, key string) {
+	if err := memcache.Delete(ctx, key); err != nil && err != memcache.ErrCacheMiss {
+		errorf(ctx, "memcache.Delete(%q): %v", key, err)
+	}
+}
+
+// postedCommentKey returns the commentGuard key for posting |comment| on
+// |issue|. Comments differing only in case and whitespace share a key.
+func postedCommentKey(repo *github.Repository, issue *github.Issue, comment string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(comment)), " ")
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s#%d\n%s",
+		repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), normalized)))
+	return "comment:" + hex.EncodeToString(sum[:])
+}
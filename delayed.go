@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// enqueueEvaluation schedules evaluateTaskHandler to evaluate the issue after
+// |delay|, see Config.EvaluationDelaySeconds. Tests replace it.
+var enqueueEvaluation = func(ctx context.Context, owner, repo string, number int, delay time.Duration) error {
+	t := taskqueue.NewPOSTTask("/tasks/evaluate", url.Values{
+		"owner":  {owner},
+		"repo":   {repo},
+		"number": {strconv.Itoa(number)},
+	})
+	t.Delay = delay
+	_, err := taskqueue.Add(ctx, t, "")
+	return err
+}
+
+func evaluateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	// App Engine removes the X-AppEngine-QueueName header from external
+	// requests.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "Only callable from a task queue", http.StatusForbidden)
+		return
+	}
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
+
+	owner, repo := r.FormValue("owner"), r.FormValue("repo")
+	number, err := strconv.Atoi(r.FormValue("number"))
+	if err != nil || owner == "" || repo == "" {
+		// Retrying will not help, so do not fail the task.
+		errorf(ctx, "invalid evaluation task: owner %q, repo %q, number %q", owner, repo, r.FormValue("number"))
+		return
+	}
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, err := clientFor(ctx, owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	evaluateDelayed(ctx, w, client, cfg, owner, repo, number)
+}
+
+// evaluateDelayed evaluates the issue as it is now, i.e. including edits the
+// reporter made since opening it. Failed requests make the task queue retry
+// the task.
+func evaluateDelayed(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, owner, repo string, number int) {
+	issue, resp, err := client.Issues.Get(ctx, owner, repo, number)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Get: %v", err), errorStatus(ctx, err))
+		return
+	}
+	discardResponse(resp)
+	if issue.GetState() != "open" {
+		// e.g. closed by the reporter in the meantime
+		infof(ctx, "not evaluating %s/%s#%d: no longer open", owner, repo, number)
+		return
+	}
+	evaluateIssue(ctx, w, client, cfg, github.IssuesEvent{
+		Action: github.String("opened"),
+		Issue:  issue,
+		Repo: &github.Repository{
+			Name:  github.String(repo),
+			Owner: &github.User{Login: github.String(owner)},
+		},
+	})
+}
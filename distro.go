@@ -0,0 +1,51 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// distroLine matches lines naming the reporter’s distribution in environment
+// blocks, such as “Distro: Arch Linux” or “**OS:** Ubuntu 22.04”.
+var distroLine = regexp.MustCompile(`(?mi)^[ \t>*-]*(?:distro|distribution|os|operating system)[ \t*]*:[ \t*]*(.+)$`)
+
+// knownDistros maps words identifying a distribution to the name used in its
+// label. Only these distributions are labeled, so that arbitrary text does not
+// end up in label names.
+var knownDistros = map[string]string{
+	"alpine":      "alpine",
+	"arch":        "arch",
+	"archlinux":   "arch",
+	"debian":      "debian",
+	"endeavouros": "endeavouros",
+	"fedora":      "fedora",
+	"freebsd":     "freebsd",
+	"gentoo":      "gentoo",
+	"kubuntu":     "ubuntu",
+	"manjaro":     "manjaro",
+	"mint":        "mint",
+	"netbsd":      "netbsd",
+	"nixos":       "nixos",
+	"openbsd":     "openbsd",
+	"opensuse":    "opensuse",
+	"ubuntu":      "ubuntu",
+	"void":        "void",
+	"xubuntu":     "ubuntu",
+}
+
+// extractDistro returns the name (see knownDistros) of the distribution
+// named in a “Distro:” or “OS:” line of |body|, or the empty string.
+func extractDistro(body string) string {
+	for _, match := range distroLine.FindAllStringSubmatch(body, -1) {
+		words := strings.FieldsFunc(strings.ToLower(match[1]), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+		})
+		for _, word := range words {
+			if distro, ok := knownDistros[word]; ok {
+				return distro
+			}
+		}
+	}
+	return ""
+}
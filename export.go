@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/appengine"
+)
+
+// exportPageSize is the number of Blobrefs read from the datastore at a time
+// while exporting, so that memory usage does not grow with the number of
+// logs. Tests lower it.
+var exportPageSize = 500
+
+// blobrefExport is the exported form of a Blobref.
+type blobrefExport struct {
+	// ID is the identifier under which the log is served, see logID.
+	ID          string `json:"id"`
+	DatastoreID int64  `json:"datastore_id"`
+	Filename    string `json:"filename"`
+	Format      string `json:"format"`
+	Backtrace   bool   `json:"backtrace"`
+	Note        string `json:"note,omitempty"`
+	// Created is the upload time (RFC 3339), empty if unknown.
+	Created string `json:"created,omitempty"`
+	// SHA256 is the hex-encoded hash of the stored log, see Blobref.SHA256.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+var exportCSVHeader = []string{"id", "datastore_id", "filename", "format", "backtrace", "note", "created", "sha256"}
+
+func (e blobrefExport) csvRecord() []string {
+	return []string{
+		e.ID,
+		strconv.FormatInt(e.DatastoreID, 10),
+		e.Filename,
+		e.Format,
+		strconv.FormatBool(e.Backtrace),
+		e.Note,
+		e.Created,
+		e.SHA256,
+	}
+}
+
+func newBlobrefExport(id int64, b *Blobref) blobrefExport {
+	e := blobrefExport{
+		ID:          logID(id, b),
+		DatastoreID: id,
+		Filename:    b.Filename,
+		Format:      formatByName(b.Format).name,
+		Backtrace:   b.Backtrace,
+		Note:        b.Note,
+		SHA256:      b.SHA256,
+	}
+	if created := b.createdAt(); !created.IsZero() {
+		e.Created = created.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// exportBlobrefs writes all Blobrefs to |w| in |format| (“json” or “csv”),
+// reading them one page at a time.
+func exportBlobrefs(ctx context.Context, w http.ResponseWriter, format string) error {
+	var write func(e blobrefExport) error
+	var finish func() error
+	switch format {
+	case "json":
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		sep := "["
+		write = func(e blobrefExport) error {
+			if _, err := fmt.Fprint(w, sep); err != nil {
+				return err
+			}
+			sep = ","
+			return enc.Encode(e)
+		}
+		finish = func() error {
+			if sep == "[" {
+				_, err := fmt.Fprint(w, "[]\n")
+				return err
+			}
+			_, err := fmt.Fprint(w, "]\n")
+			return err
+		}
+
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv")
+		cw := csv.NewWriter(w)
+		if err := cw.Write(exportCSVHeader); err != nil {
+			return err
+		}
+		write = func(e blobrefExport) error {
+			return cw.Write(e.csvRecord())
+		}
+		finish = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+
+	default:
+		return fmt.Errorf("unknown format %q (use json or csv)", format)
+	}
+
+	cursor := ""
+	for {
+		ids, refs, next, err := blobrefs.List(ctx, cursor, exportPageSize)
+		if err != nil {
+			return err
+		}
+		for i, b := range refs {
+			if err := write(newBlobrefExport(ids[i], b)); err != nil {
+				return err
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+		if next == "" {
+			return finish()
+		}
+		cursor = next
+	}
+}
+
+// exportHandler serves /logs/export, which lists the metadata of all stored
+// logs for bot administrators.
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+
+	format := r.FormValue("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "format parameter must be json or csv", http.StatusBadRequest)
+		return
+	}
+	if err := exportBlobrefs(ctx, w, format); err != nil {
+		// Part of the response may already have been sent, so writing an
+		// error message would corrupt it. The export ends prematurely
+		// instead, i.e. it is not valid JSON or lacks records.
+		errorf(ctx, "exportBlobrefs: %v", err)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	// githubAppID is the ID of the GitHub App as which the bot runs. When
+	// empty (the default), the bot uses githubToken and installation events
+	// are ignored.
+	githubAppID = os.Getenv("GITHUB_APP_ID")
+
+	// githubAppKeySecret is the resource name of a Secret Manager secret
+	// version containing the private key (PEM) of the GitHub App, which is
+	// needed to create installation tokens.
+	githubAppKeySecret = os.Getenv("GITHUB_APP_KEY_SECRET")
+)
+
+// Installation records a GitHub App installation, so that clientFor can
+// authenticate as the installation which covers a repository owner.
+type Installation struct {
+	ID int64
+	// Account is the login of the user or organization the app is installed
+	// for.
+	Account string
+	// Repositories are the full names (e.g. “i3/i3”) of the repositories the
+	// installation has access to.
+	Repositories []string
+}
+
+// installationStore stores Installation entities, keyed by account. Tests
+// replace installations with an in-memory implementation.
+type installationStore interface {
+	Get(ctx context.Context, account string) (*Installation, error)
+	Put(ctx context.Context, inst *Installation) error
+	Delete(ctx context.Context, account string) error
+}
+
+var installations installationStore = datastoreInstallations{}
+
+// datastoreInstallations implements installationStore using the App Engine
+// datastore.
+type datastoreInstallations struct{}
+
+func installationKey(ctx context.Context, account string) *datastore.Key {
+	return datastore.NewKey(ctx, "Installation", account, 0, nil)
+}
+
+func (datastoreInstallations) Get(ctx context.Context, account string) (*Installation, error) {
+	var inst Installation
+	if err := datastore.Get(ctx, installationKey(ctx, account), &inst); err != nil {
+		return nil, err
+	}
+	return &inst, nil
+}
+
+func (datastoreInstallations) Put(ctx context.Context, inst *Installation) error {
+	_, err := datastore.Put(ctx, installationKey(ctx, inst.Account), inst)
+	return err
+}
+
+func (datastoreInstallations) Delete(ctx context.Context, account string) error {
+	return datastore.Delete(ctx, installationKey(ctx, account))
+}
+
+func repoNames(repos []*github.Repository) []string {
+	names := make([]string, 0, len(repos))
+	for _, repo := range repos {
+		names = append(names, repo.GetFullName())
+	}
+	return names
+}
+
+// handleInstallationEvent records installations being created or deleted.
+func handleInstallationEvent(ctx context.Context, payload github.InstallationEvent) error {
+	account := payload.GetInstallation().GetAccount().GetLogin()
+	switch payload.GetAction() {
+	case "created":
+		return installations.Put(ctx, &Installation{
+			ID:           payload.GetInstallation().GetID(),
+			Account:      account,
+			Repositories: repoNames(payload.Repositories),
+		})
+	case "deleted":
+		if err := installations.Delete(ctx, account); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleInstallationRepositoriesEvent records repositories being added to or
+// removed from an installation.
+func handleInstallationRepositoriesEvent(ctx context.Context, payload github.InstallationRepositoriesEvent) error {
+	account := payload.GetInstallation().GetAccount().GetLogin()
+	inst, err := installations.Get(ctx, account)
+	if err == datastore.ErrNoSuchEntity {
+		// We missed the installation event, e.g. because the bot was not yet
+		// running in App mode.
+		inst = &Installation{Account: account}
+	} else if err != nil {
+		return err
+	}
+	inst.ID = payload.GetInstallation().GetID()
+
+	repos := make(map[string]bool)
+	for _, name := range inst.Repositories {
+		repos[name] = true
+	}
+	for _, name := range repoNames(payload.RepositoriesAdded) {
+		repos[name] = true
+	}
+	for _, name := range repoNames(payload.RepositoriesRemoved) {
+		delete(repos, name)
+	}
+	inst.Repositories = inst.Repositories[:0]
+	for name := range repos {
+		inst.Repositories = append(inst.Repositories, name)
+	}
+	sort.Strings(inst.Repositories)
+	return installations.Put(ctx, inst)
+}
+
+// handleInstallationWebhook handles the installation and
+// installation_repositories events, which GitHub sends to GitHub Apps. Outside
+// of App mode, the events are acknowledged but ignored.
+func handleInstallationWebhook(ctx context.Context, w http.ResponseWriter, event string, body []byte) {
+	if githubAppID == "" {
+		return
+	}
+
+	var err error
+	switch event {
+	case "installation":
+		var payload github.InstallationEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		err = handleInstallationEvent(ctx, payload)
+
+	case "installation_repositories":
+		var payload github.InstallationRepositoriesEvent
+		if err := json.Unmarshal(body, &payload); err != nil {
+			http.Error(w, fmt.Sprintf("Cannot parse JSON: %v", err), http.StatusBadRequest)
+			return
+		}
+		err = handleInstallationRepositoriesEvent(ctx, payload)
+
+	default:
+		http.Error(w, "Expected X-GitHub-Event: installation or installation_repositories", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		errorf(ctx, "%s event: %v", event, err)
+		http.Error(w, err.Error(), errorStatus(ctx, err))
+	}
+}
+
+// isInstallationEvent returns whether |event| is handled by
+// handleInstallationWebhook.
+func isInstallationEvent(event string) bool {
+	return event == "installation" || event == "installation_repositories"
+}
+
+func installationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
+
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, event, err := readAndVerifyBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), verifyErrorStatus(err))
+		return
+	}
+
+	if event == "ping" {
+		return
+	}
+
+	handleInstallationWebhook(ctx, w, event, body)
+}
+
+// loadAppKey reads the private key of the GitHub App, see
+// githubAppKeySecret. Tests replace it.
+var loadAppKey = func(ctx context.Context) (*rsa.PrivateKey, error) {
+	if githubAppKeySecret == "" {
+		return nil, fmt.Errorf("GITHUB_APP_KEY_SECRET is not set")
+	}
+	b, err := accessSecret(ctx, githubAppKeySecret)
+	if err != nil {
+		return nil, err
+	}
+	return parseAppKey(b)
+}
+
+// parseAppKey parses a GitHub App private key, which GitHub provides in
+// PKCS #1 form.
+func parseAppKey(b []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("app key: no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("app key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("app key: %T is not an RSA key", parsed)
+	}
+	return key, nil
+}
+
+// appJWT returns the JSON Web Token authenticating as the GitHub App |appID|
+// at |now|, see
+// https://docs.github.com/en/apps/creating-github-apps/authenticating-with-a-github-app/generating-a-json-web-token-jwt-for-a-github-app
+func appJWT(appID string, key *rsa.PrivateKey, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		// Allow for clock drift, as GitHub recommends.
+		"iat": now.Add(-time.Minute).Unix(),
+		"exp": now.Add(9 * time.Minute).Unix(),
+		"iss": appID,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// createInstallationToken creates an access token for the installation |id|,
+// authenticating as the GitHub App with |jwt|. Tests replace it.
+var createInstallationToken = func(ctx context.Context, jwt string, id int64) (*github.InstallationToken, error) {
+	token, resp, err := newGitHubClient(ctx, "Bearer "+jwt).Apps.CreateInstallationToken(ctx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	discardResponse(resp)
+	return token, nil
+}
+
+// installationTokenMinValidity is how long a cached installation token must
+// remain valid to be used. GitHub issues tokens valid for an hour.
+const installationTokenMinValidity = 5 * time.Minute
+
+type cachedInstallationToken struct {
+	token   string
+	expires time.Time
+}
+
+var (
+	installationTokensMu sync.Mutex
+	// installationTokens caches installation tokens by installation ID.
+	installationTokens = make(map[int64]cachedInstallationToken)
+)
+
+// installationToken returns an access token for the installation recorded
+// for |owner| (see handleInstallationEvent), creating one if there is no
+// cached token.
+func installationToken(ctx context.Context, owner string) (string, error) {
+	inst, err := installations.Get(ctx, owner)
+	if err == datastore.ErrNoSuchEntity {
+		return "", fmt.Errorf("the GitHub App is not installed for %q", owner)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	installationTokensMu.Lock()
+	cached, ok := installationTokens[inst.ID]
+	installationTokensMu.Unlock()
+	if ok && time.Until(cached.expires) > installationTokenMinValidity {
+		return cached.token, nil
+	}
+
+	key, err := loadAppKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	jwt, err := appJWT(githubAppID, key, time.Now())
+	if err != nil {
+		return "", err
+	}
+	token, err := createInstallationToken(ctx, jwt, inst.ID)
+	if err != nil {
+		return "", fmt.Errorf("CreateInstallationToken(%d): %v", inst.ID, err)
+	}
+	installationTokensMu.Lock()
+	defer installationTokensMu.Unlock()
+	installationTokens[inst.ID] = cachedInstallationToken{
+		token:   token.GetToken(),
+		expires: token.GetExpiresAt(),
+	}
+	return token.GetToken(), nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// formHeadingRegexp matches the “### Heading” lines which GitHub renders
+	// for each field of an issue form.
+	formHeadingRegexp = regexp.MustCompile(`(?m)^###[ \t]+(.+?)[ \t]*$`)
+
+	// bareVersionRegexp matches a version field which contains just the
+	// version number, e.g. “4.23” or “4.23.1”.
+	bareVersionRegexp = regexp.MustCompile(`^v?(([0-9]\.[0-9]+)(?:\.[0-9]+)*)$`)
+)
+
+// formNoResponse is what GitHub renders for issue form fields which were left
+// empty.
+const formNoResponse = "_No response_"
+
+// parseIssueForm splits an issue body which was created from a GitHub issue
+// form into its fields, keyed by lower-cased heading. It returns nil if |body|
+// does not look like an issue form.
+func parseIssueForm(body string) map[string]string {
+	headings := formHeadingRegexp.FindAllStringSubmatchIndex(body, -1)
+	if len(headings) == 0 {
+		return nil
+	}
+	fields := make(map[string]string, len(headings))
+	for idx, heading := range headings {
+		end := len(body)
+		if idx < len(headings)-1 {
+			end = headings[idx+1][0]
+		}
+		name := strings.ToLower(body[heading[2]:heading[3]])
+		value := strings.TrimSpace(body[heading[1]:end])
+		if value == formNoResponse {
+			value = ""
+		}
+		fields[name] = value
+	}
+	return fields
+}
+
+// formField returns the value of the first field whose heading contains
+// |name|, and whether such a field exists.
+func formField(fields map[string]string, name string) (string, bool) {
+	// Iterate in a stable order, map iteration order is random.
+	var match string
+	found := false
+	for heading := range fields {
+		if strings.Contains(heading, name) && (!found || heading < match) {
+			match = heading
+			found = true
+		}
+	}
+	return fields[match], found
+}
+
+// formIssueType returns “bug”, “enhancement” or “documentation” if the issue
+// form specifies the type of the issue, or the empty string otherwise.
+func formIssueType(fields map[string]string) string {
+	value, ok := formField(fields, "type")
+	if !ok {
+		return ""
+	}
+	value = strings.ToLower(value)
+	switch {
+	case strings.Contains(value, "feature"), strings.Contains(value, "enhancement"):
+		return "enhancement"
+	case strings.Contains(value, "documentation"):
+		return "documentation"
+	case strings.Contains(value, "bug"):
+		return "bug"
+	}
+	return ""
+}
+
+// extractIssueVersion is like extractProgramVersion, but if |body| was created
+// from an issue form, the version field takes precedence. The whole body is
+// only considered if the version field does not contain a version (e.g.
+// because the reporter pasted the version elsewhere).
+func extractIssueVersion(body, program string) []string {
+	matches, _ := explainIssueVersion(body, program)
+	return matches
+}
+
+// explainIssueVersion is like extractIssueVersion, but also returns how the
+// version was chosen.
+func explainIssueVersion(body, program string) ([]string, *versionDecision) {
+	fields := parseIssueForm(body)
+	value, ok := formField(fields, "version")
+	if !ok {
+		return explainProgramVersion(body, program)
+	}
+	if matches, d := explainProgramVersion(value, program); len(matches) > 0 {
+		d.Reason = "issue form version field: " + d.Reason
+		return matches, d
+	}
+	// The field asks for the i3 version, so a bare version number refers to
+	// i3.
+	if matches := bareVersionRegexp.FindStringSubmatch(value); matches != nil {
+		d := &versionDecision{
+			Candidates: []string{value},
+			Reason:     "bare version number in the issue form version field",
+			Result:     []string{"", "i3", matches[2], matches[1]},
+		}
+		return d.Result, d
+	}
+	matches, d := explainProgramVersion(body, program)
+	d.Reason = "no version in the issue form version field, whole body: " + d.Reason
+	return matches, d
+}
@@ -1,23 +1,32 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"compress/bzip2"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"os"
 	"path"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"cloud.google.com/go/storage"
+	"github.com/google/go-github/v47/github"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine"
+	"google.golang.org/appengine/blobstore"
 	"google.golang.org/appengine/datastore"
-	"google.golang.org/appengine/log"
 )
 
 const (
@@ -28,112 +37,1105 @@ const (
 	defaultBucket = `i3-github-bot.appspot.com`
 )
 
+// bucket is the Cloud Storage bucket in which logs are stored. It defaults to
+// defaultBucket and can be overridden using the LOGS_BUCKET environment
+// variable, e.g. for a staging environment.
+var bucket = bucketFromEnv()
+
+func bucketFromEnv() string {
+	if b := os.Getenv("LOGS_BUCKET"); b != "" {
+		return b
+	}
+	return defaultBucket
+}
+
 // Matches an i3 log line, such as:
 // 2015-02-01 17:21:48 - ../i3-4.8/src/handlers.c:handle_event:1231 - blah
 // (cannot match the date/time since that is locale-specific)
 var i3LogLine = regexp.MustCompile(` - ` + fileName + `:` + identifier + `:` + lineNumber + ` - `)
 
 type Blobref struct {
-	// TODO: remove this now-unused attribute (we are storing objects in Google
-	// Cloud Storage now, not blobstore).
+	// Blobkey is only set for logs uploaded before we stored objects in
+	// Google Cloud Storage instead of blobstore. These logs have no Filename
+	// and are served from blobstore, see serveLog.
 	Blobkey  appengine.BlobKey
 	Filename string
+	// Backtrace is true if the log contains i3 crash output, see
+	// Config.BacktracePatterns.
+	Backtrace bool
+	// Slug is a short random identifier under which the log is served
+	// (instead of the datastore ID), see Config.ShortLogURLs.
+	Slug string
+	// Format is the name of the logFormat in which the log was uploaded.
+	// Empty for logs uploaded before gzip was supported, which are bzip2.
+	Format string
+	// Note is an optional description provided by the uploader, see
+	// sanitizeNote.
+	Note string `datastore:",noindex"`
+	// SHA256 is the hex-encoded SHA-256 hash of the stored (compressed) log.
+	// Empty for logs uploaded before hashes were recorded which were not yet
+	// processed by backfillBlobrefs.
+	SHA256 string
+	// Private is true if the log was taken down, but kept (see setLogPublic):
+	// its object is not world-readable and only administrators are served
+	// the log.
+	Private bool
+	// Created is when the log was uploaded. Zero for logs uploaded before
+	// it was recorded, see createdAt.
+	Created time.Time
+}
+
+// createdAt returns when the log was uploaded, or the zero time if unknown.
+// Logs stored in Google Cloud Storage before Created was recorded are named
+// after their upload time (in nanoseconds since the epoch).
+func (b *Blobref) createdAt() time.Time {
+	if !b.Created.IsZero() {
+		return b.Created
+	}
+	if nsec, err := strconv.ParseInt(b.Filename, 10, 64); err == nil {
+		return time.Unix(0, nsec).UTC()
+	}
+	return time.Time{}
+}
+
+// maxNoteLength is the maximum length (in characters) of Blobref.Note.
+const maxNoteLength = 200
+
+// sanitizeNote removes control characters (including newlines) from an
+// uploader-provided note and shortens it to maxNoteLength characters.
+func sanitizeNote(note string) string {
+	note = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, note)
+	note = strings.Join(strings.Fields(note), " ")
+	if runes := []rune(note); len(runes) > maxNoteLength {
+		note = string(runes[:maxNoteLength])
+	}
+	return note
+}
+
+// markdownNote returns |note| (see sanitizeNote) as a markdown code span for
+// bot comments. Notes are provided by anonymous uploaders, so characters
+// which could end the code span or form mentions and links are replaced by
+// their fullwidth forms.
+func markdownNote(note string) string {
+	return "`" + strings.NewReplacer(
+		"`", "｀",
+		"@", "＠",
+		"[", "［",
+		"]", "］",
+		"(", "（",
+		")", "）",
+	).Replace(note) + "`"
+}
+
+// logLinePercent returns the percentage (rounded down) of non-empty lines in
+// |log| which look like i3 log lines.
+func logLinePercent(log []byte) int {
+	var lines, matched int
+	for len(log) > 0 {
+		line := log
+		if idx := bytes.IndexByte(log, '\n'); idx > -1 {
+			line, log = log[:idx], log[idx+1:]
+		} else {
+			log = nil
+		}
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		lines++
+		if i3LogLine.Match(line) {
+			matched++
+		}
+	}
+	if lines == 0 {
+		return 0
+	}
+	return matched * 100 / lines
+}
+
+// sensitivePattern describes secrets which must not end up in publicly
+// hosted logs, e.g. because a password was typed into a window title.
+type sensitivePattern struct {
+	description string
+	re          *regexp.Regexp
+}
+
+// sensitivePatterns are checked against uploaded logs. They are deliberately
+// specific, as false positives prevent people from uploading their logs.
+var sensitivePatterns = []sensitivePattern{
+	{"an AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"a password", regexp.MustCompile(`(?i)\bpass(?:word|wd)=[^\s&]+`)},
+	{"a bearer token", regexp.MustCompile(`(?i)\bbearer [A-Za-z0-9._~+/-]{20,}`)},
+	{"a private key", regexp.MustCompile(`-----BEGIN (?:RSA |DSA |EC |OPENSSH )?PRIVATE KEY-----`)},
+}
+
+// findSensitive returns the description of the first sensitive pattern which
+// |log| contains, or the empty string.
+func findSensitive(log []byte) string {
+	for _, p := range sensitivePatterns {
+		if p.re.Match(log) {
+			return p.description
+		}
+	}
+	return ""
+}
+
+// logFormat is a compression format in which logs can be uploaded.
+type logFormat struct {
+	name        string
+	ext         string
+	contentType string
+	magic       []byte
+	newReader   func(r io.Reader) (io.Reader, error)
+}
+
+// logFormats are the accepted upload formats. The first one is the default.
+var logFormats = []logFormat{
+	{
+		name:        "bzip2",
+		ext:         "bz2",
+		contentType: "application/x-bzip2",
+		magic:       []byte("BZh"),
+		newReader: func(r io.Reader) (io.Reader, error) {
+			return bzip2.NewReader(r), nil
+		},
+	},
+	{
+		name:        "gzip",
+		ext:         "gz",
+		contentType: "application/gzip",
+		magic:       []byte{0x1f, 0x8b},
+		newReader: func(r io.Reader) (io.Reader, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+// formatByName returns the logFormat called |name|, defaulting to bzip2.
+func formatByName(name string) logFormat {
+	for _, f := range logFormats {
+		if f.name == name {
+			return f
+		}
+	}
+	return logFormats[0]
+}
+
+// sniffFormat returns the logFormat of |data| based on its magic number.
+func sniffFormat(data []byte) (logFormat, bool) {
+	for _, f := range logFormats {
+		if bytes.HasPrefix(data, f.magic) {
+			return f, true
+		}
+	}
+	return logFormat{}, false
+}
+
+// objectStore stores the uploaded log files. Tests replace objects with an
+// in-memory implementation.
+type objectStore interface {
+	NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error)
+	// NewRangeReader reads |length| bytes of the object, starting at
+	// |offset|.
+	NewRangeReader(ctx context.Context, bucket, name string, offset, length int64) (io.ReadCloser, error)
+	// Size returns the size of the object in bytes.
+	Size(ctx context.Context, bucket, name string) (int64, error)
+	// NewWriter creates a world-readable object.
+	NewWriter(ctx context.Context, bucket, name, contentType string) (io.WriteCloser, error)
+	// Delete deletes the object.
+	Delete(ctx context.Context, bucket, name string) error
+	// SetPublic makes the object world-readable or readable only by its
+	// owner.
+	SetPublic(ctx context.Context, bucket, name string, public bool) error
+}
+
+var objects objectStore = gcsObjects{}
+
+// gcsObjects implements objectStore using Google Cloud Storage.
+type gcsObjects struct{}
+
+var (
+	sharedStorageClientMu sync.Mutex
+	sharedStorageClient   *storage.Client
+)
+
+// newStorageClient creates a Cloud Storage client. Tests replace it.
+var newStorageClient = func(ctx context.Context) (*storage.Client, error) {
+	return storage.NewClient(ctx)
+}
+
+// storageClient returns the Cloud Storage client shared by all requests,
+// creating it on first use. Errors are not cached.
+//
+// Creating a client takes about 20µs (measured without credentials), but a
+// new client also starts without an OAuth token and without connections, so
+// with a client per request, each log read or write fetched a token from the
+// metadata server and made a new TLS connection to Cloud Storage before
+// transferring any data. The shared client keeps both.
+func storageClient(ctx context.Context) (*storage.Client, error) {
+	sharedStorageClientMu.Lock()
+	defer sharedStorageClientMu.Unlock()
+	if sharedStorageClient == nil {
+		// The client outlives the request, so it must not be bound to
+		// the request context. The second generation App Engine runtime
+		// does not require per-request contexts for outgoing requests.
+		client, err := newStorageClient(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		sharedStorageClient = client
+	}
+	return sharedStorageClient, nil
+}
+
+func (gcsObjects) NewReader(ctx context.Context, bucket, name string) (io.ReadCloser, error) {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Bucket(bucket).Object(name).NewReader(ctx)
+}
+
+func (gcsObjects) NewRangeReader(ctx context.Context, bucket, name string, offset, length int64) (io.ReadCloser, error) {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Bucket(bucket).Object(name).NewRangeReader(ctx, offset, length)
+}
+
+func (gcsObjects) Size(ctx context.Context, bucket, name string) (int64, error) {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return 0, err
+	}
+	attrs, err := client.Bucket(bucket).Object(name).Attrs(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return attrs.Size, nil
+}
+
+func (gcsObjects) NewWriter(ctx context.Context, bucket, name, contentType string) (io.WriteCloser, error) {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	bw := client.Bucket(bucket).Object(name).NewWriter(ctx)
+	bw.ContentType = contentType
+	bw.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
+	return bw, nil
+}
+
+func (gcsObjects) Delete(ctx context.Context, bucket, name string) error {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.Bucket(bucket).Object(name).Delete(ctx)
+	if err == storage.ErrObjectNotExist {
+		return os.ErrNotExist
+	}
+	return err
+}
+
+func (gcsObjects) SetPublic(ctx context.Context, bucket, name string, public bool) error {
+	client, err := storageClient(ctx)
+	if err != nil {
+		return err
+	}
+	acl := client.Bucket(bucket).Object(name).ACL()
+	if public {
+		err = acl.Set(ctx, storage.AllUsers, storage.RoleReader)
+	} else {
+		err = acl.Delete(ctx, storage.AllUsers)
+	}
+	if err == storage.ErrObjectNotExist {
+		return os.ErrNotExist
+	}
+	return err
 }
 
 func logsHandler(w http.ResponseWriter, r *http.Request) {
-	var blobref Blobref
+	serveLog(w, r, false)
+}
+
+// immutableLogsHandler serves logs under /logs/immutable/, which a CDN can
+// cache forever: the response does not depend on request headers (such as
+// Range).
+func immutableLogsHandler(w http.ResponseWriter, r *http.Request) {
+	serveLog(w, r, true)
+}
 
+// deleteLogHandler serves /logs/delete, which deletes a log (e.g. for privacy
+// requests) for bot administrators. The log is answered with 410 Gone
+// afterwards.
+func deleteLogHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("id") == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
 
-	strid := path.Base(r.URL.Path)
-	if strings.HasSuffix(strid, ".bz2") {
-		strid = strid[:len(strid)-len(".bz2")]
+	if err := deleteLog(ctx, r.FormValue("id")); err != nil {
+		errorf(ctx, "deleteLog(%q): %v", r.FormValue("id"), err)
+		status := errorStatus(ctx, err)
+		if err == datastore.ErrNoSuchEntity {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
 	}
+	fmt.Fprintf(w, "Log %s deleted.\n", r.FormValue("id"))
+}
 
-	intid, err := strconv.ParseInt(strid, 0, 64)
+func logACLHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	if r.Method != "POST" {
+		http.Error(w, "Use POST", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.FormValue("id") == "" {
+		http.Error(w, "id parameter is required", http.StatusBadRequest)
+		return
+	}
+	public, err := strconv.ParseBool(r.FormValue("public"))
 	if err != nil {
-		log.Errorf(ctx, "strconv.ParseInt: %v", err)
-		http.Error(w, err.Error(), http.StatusNotFound)
+		http.Error(w, "public parameter must be true or false", http.StatusBadRequest)
 		return
 	}
-	if err := datastore.Get(ctx, datastore.NewKey(ctx, "blobref", "", intid, nil), &blobref); err != nil {
-		log.Errorf(ctx, "datastore.Get: %v", err)
-		http.Error(w, err.Error(), http.StatusNotFound)
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
+
+	if err := setLogPublic(ctx, r.FormValue("id"), public); err != nil {
+		errorf(ctx, "setLogPublic(%q, %v): %v", r.FormValue("id"), public, err)
+		status := errorStatus(ctx, err)
+		if err == datastore.ErrNoSuchEntity {
+			status = http.StatusNotFound
+		} else if err == errLegacyLog {
+			status = http.StatusBadRequest
+		}
+		http.Error(w, err.Error(), status)
 		return
 	}
+	fmt.Fprintf(w, "Log %s is now public: %v.\n", r.FormValue("id"), public)
+}
 
-	client, err := storage.NewClient(ctx)
+// errLegacyLog is returned by setLogPublic for logs stored in blobstore, whose
+// access cannot be restricted.
+var errLegacyLog = errors.New("Legacy blobstore logs cannot be made private.")
+
+// setLogPublic makes the log with the specified identifier (see logID)
+// world-readable or private, see Blobref.Private.
+func setLogPublic(ctx context.Context, logid string, public bool) error {
+	id, blobref, err := lookupBlobrefID(ctx, logid)
 	if err != nil {
-		log.Errorf(ctx, "NewReader: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		if _, ok := err.(*strconv.NumError); ok {
+			return datastore.ErrNoSuchEntity
+		}
+		return err
+	}
+	if blobref.Filename == "" {
+		return errLegacyLog
+	}
+	if err := objects.SetPublic(ctx, bucket, blobref.Filename, public); err != nil {
+		return err
+	}
+	blobref.Private = !public
+	return blobrefs.Update(ctx, id, blobref)
+}
+
+// deleteLog deletes the log with the specified identifier (see logID) from
+// Cloud Storage and the datastore.
+func deleteLog(ctx context.Context, logid string) error {
+	id, blobref, err := lookupBlobrefID(ctx, logid)
+	if err != nil {
+		if _, ok := err.(*strconv.NumError); ok {
+			return datastore.ErrNoSuchEntity
+		}
+		return err
 	}
-	rc, err := client.Bucket(defaultBucket).Object(blobref.Filename).NewReader(ctx)
+	// The object may be gone already, e.g. when retrying after the datastore
+	// deletion failed.
+	if err := objects.Delete(ctx, bucket, blobref.Filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return blobrefs.Delete(ctx, id, blobref)
+}
+
+func serveLog(w http.ResponseWriter, r *http.Request, immutable bool) {
+	ctx := appengine.NewContext(r)
+	ctx, cancel := withRequestBudget(ctx, configOrDefault(ctx))
+	defer cancel()
+
+	strid := path.Base(r.URL.Path)
+	for _, f := range logFormats {
+		strid = strings.TrimSuffix(strid, "."+f.ext)
+	}
+
+	blobref, err := lookupBlobref(ctx, strid)
 	if err != nil {
-		log.Errorf(ctx, "NewReader: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		errorf(ctx, "lookupBlobref(%q): %v", strid, err)
+		if deleted, err := blobrefs.Deleted(ctx, strings.ToLower(strid)); err == nil && deleted {
+			http.Error(w, "This log has been deleted.", http.StatusGone)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	if blobref.Private && !isAdmin(ctx) {
+		http.Error(w, "This log is private.", http.StatusForbidden)
 		return
 	}
+
+	format := formatByName(blobref.Format)
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="i3log-%s.%s"`, strid, format.ext))
+	// Logs never change once uploaded.
+	if immutable {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=31536000")
+		w.Header().Set("Accept-Ranges", "bytes")
+	}
+	if blobref.Private {
+		// Served to administrators only, which caches must not remember.
+		w.Header().Set("Cache-Control", "private, no-store")
+	}
+
+	if blobref.Filename == "" && blobref.Blobkey != "" {
+		// App Engine serves legacy logs from blobstore itself, in full.
+		infof(ctx, "serving log %s from blobstore (blobkey %q)", strid, blobref.Blobkey)
+		w.Header().Del("Accept-Ranges")
+		blobstore.Send(w, blobref.Blobkey)
+		return
+	}
+
+	var rc io.ReadCloser
+	if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && !immutable {
+		// Ranges refer to the stored (compressed) log, which allows for
+		// resuming downloads of big logs.
+		size, err := objects.Size(ctx, bucket, blobref.Filename)
+		if err != nil {
+			errorf(ctx, "Size: %v", err)
+			http.Error(w, err.Error(), errorStatus(ctx, err))
+			return
+		}
+		offset, length, ok, err := parseByteRange(rangeHeader, size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if ok {
+			if rc, err = objects.NewRangeReader(ctx, bucket, blobref.Filename, offset, length); err != nil {
+				errorf(ctx, "NewRangeReader: %v", err)
+				http.Error(w, err.Error(), errorStatus(ctx, err))
+				return
+			}
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+length-1, size))
+			w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+			w.WriteHeader(http.StatusPartialContent)
+		}
+	}
+	if rc == nil {
+		if rc, err = objects.NewReader(ctx, bucket, blobref.Filename); err != nil {
+			errorf(ctx, "NewReader: %v", err)
+			http.Error(w, err.Error(), errorStatus(ctx, err))
+			return
+		}
+	}
 	defer rc.Close()
-	w.Header().Set("Content-Type", "application/octet-stream")
 	if _, err := io.Copy(w, rc); err != nil {
-		log.Errorf(ctx, "Copy: %v", err)
+		errorf(ctx, "Copy: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
-func writeBlob(ctx context.Context, r io.Reader) (string, error) {
-	filename := strconv.FormatInt(time.Now().UnixNano(), 10)
-	client, err := storage.NewClient(ctx)
+// errRangeNotSatisfiable is returned by parseByteRange for ranges outside of
+// the object.
+var errRangeNotSatisfiable = errors.New("Requested range not satisfiable.")
+
+// parseByteRange parses a Range header specifying a single byte range, such as
+// “bytes=0-99”, “bytes=100-” or “bytes=-100” (the last 100 bytes), for an
+// object of |size| bytes. ok is false if the header is malformed or specifies
+// multiple ranges, in which case the whole object is served.
+func parseByteRange(header string, size int64) (offset, length int64, ok bool, err error) {
+	spec := strings.TrimPrefix(header, "bytes=")
+	if spec == header || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+	if first == "" {
+		// Suffix range, e.g. the last 100 bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, false, nil
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, false, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+			return 0, 0, false, nil
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	if start >= size {
+		return 0, 0, false, errRangeNotSatisfiable
+	}
+	return start, end - start + 1, true, nil
+}
+
+// maxLogLineLength bounds the memory used per line when scanning a log (see
+// Config.scanLog). Longer lines are scanned in pieces.
+const maxLogLineLength = 64 << 10
+
+// maxCompressionLayers is how many times an upload may be compressed, e.g.
+// when the reporter accidentally ran bzip2 twice.
+const maxCompressionLayers = 3
+
+// recorder passes reads through, copying the data to the writer set using
+// setWriter. Data read before is buffered, which is bounded by how far the
+// decompressor reading from the recorder reads ahead.
+type recorder struct {
+	r   io.Reader
+	buf bytes.Buffer
+	w   io.Writer
+	// werr is the error returned by w, to tell storage errors from errors
+	// reading the upload.
+	werr error
+}
+
+func (rec *recorder) Read(p []byte) (int, error) {
+	n, err := rec.r.Read(p)
+	if n > 0 {
+		if rec.w == nil {
+			rec.buf.Write(p[:n])
+		} else if _, rec.werr = rec.w.Write(p[:n]); rec.werr != nil {
+			return n, rec.werr
+		}
+	}
+	return n, err
+}
+
+// setWriter writes the data read so far to |w| and copies subsequently read
+// data to it.
+func (rec *recorder) setWriter(w io.Writer) error {
+	rec.w = w
+	_, rec.werr = rec.buf.WriteTo(w)
+	rec.buf = bytes.Buffer{}
+	return rec.werr
+}
+
+// logStream is an uploaded log which is decompressed while reading.
+type logStream struct {
+	format logFormat
+	// compressed records the innermost compressed layer, see openLog.
+	compressed *recorder
+	// uncompressed is the log itself.
+	uncompressed io.Reader
+}
+
+// openLog prepares decompressing the upload read from |r|. When the upload was
+// compressed multiple times, the innermost compressed layer (which can be
+// stored instead of the upload) is recorded in s.compressed and s.format is its
+// format.
+func openLog(r io.Reader) (*logStream, error) {
+	br := bufio.NewReader(r)
+	// Peek fails for uploads shorter than the magic numbers, which
+	// sniffFormat handles.
+	head, _ := br.Peek(16)
+	format, ok := sniffFormat(head)
+	if !ok {
+		return nil, fmt.Errorf("Data not bzip2- or gzip-compressed.")
+	}
+	var compressed io.Reader = br
+	for layer := 0; layer < maxCompressionLayers; layer++ {
+		rec := &recorder{r: compressed}
+		rd, err := format.newReader(rec)
+		if err != nil {
+			return nil, fmt.Errorf("Data not %s-compressed.", format.name)
+		}
+		uncompressed := bufio.NewReader(rd)
+		head, err := uncompressed.Peek(16)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("Data not %s-compressed.", format.name)
+		}
+		inner, ok := sniffFormat(head)
+		if !ok {
+			return &logStream{format: format, compressed: rec, uncompressed: uncompressed}, nil
+		}
+		format, compressed = inner, uncompressed
+	}
+	return nil, fmt.Errorf("Data compressed more than %d times.", maxCompressionLayers)
+}
+
+// finish reads the remainder of the compressed data (so that it is recorded
+// completely) after s.uncompressed was read. Errors reading the upload are
+// reported as decompression errors.
+func (s *logStream) finish(err error) error {
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, s.compressed)
+	}
+	if err == nil || s.compressed.werr != nil {
+		return err
+	}
+	return fmt.Errorf("Data not %s-compressed.", s.format.name)
+}
+
+// decompressLog decompresses an uploaded log, see openLog.
+func decompressLog(data []byte) (format logFormat, compressed, uncompressed []byte, err error) {
+	s, err := openLog(bytes.NewReader(data))
+	if err != nil {
+		return logFormat{}, nil, nil, err
+	}
+	var buf bytes.Buffer
+	s.compressed.setWriter(&buf)
+	uncompressed, err = ioutil.ReadAll(s.uncompressed)
+	if err := s.finish(err); err != nil {
+		return logFormat{}, nil, nil, err
+	}
+	return s.format, buf.Bytes(), uncompressed, nil
+}
+
+// logScan summarizes an uncompressed log, see Config.scanLog.
+type logScan struct {
+	// lines is the number of non-empty lines, of which matched look like i3
+	// log lines.
+	lines, matched int
+	// sensitive describes the first secret found, see findSensitive.
+	sensitive string
+	// backtrace is whether the log contains crash output, see
+	// Config.hasBacktrace.
+	backtrace bool
+}
+
+// percent returns the percentage (rounded down) of non-empty lines which look
+// like i3 log lines, see logLinePercent.
+func (s *logScan) percent() int {
+	if s.lines == 0 {
+		return 0
+	}
+	return s.matched * 100 / s.lines
+}
+
+// scanLog reads the uncompressed log from |r| line by line, so that large logs
+// are not held in memory. Lines longer than maxLogLineLength are counted once.
+// To keep matching fast, secrets and crash output are searched for in batches
+// of lines.
+func (c *Config) scanLog(r io.Reader) (logScan, error) {
+	var s logScan
+	br := bufio.NewReaderSize(r, maxLogLineLength)
+	batch := make([]byte, 0, 2*maxLogLineLength)
+	check := func() {
+		if s.sensitive == "" {
+			s.sensitive = findSensitive(batch)
+		}
+		if !s.backtrace {
+			s.backtrace = c.hasBacktrace(batch)
+		}
+		batch = batch[:0]
+	}
+	continued := false
+	for {
+		line, err := br.ReadSlice('\n')
+		if !continued && len(bytes.TrimSpace(line)) > 0 {
+			s.lines++
+			if i3LogLine.Match(line) {
+				s.matched++
+			}
+		}
+		if batch = append(batch, line...); len(batch) >= maxLogLineLength {
+			check()
+		}
+		switch err {
+		case nil:
+			continued = false
+		case bufio.ErrBufferFull:
+			continued = true
+		case io.EOF:
+			check()
+			return s, nil
+		default:
+			return s, err
+		}
+	}
+}
+
+// deleteBlobTimeout bounds deleteBlob, which runs after the request budget
+// (see Config.RequestBudgetSeconds) may have been used up.
+const deleteBlobTimeout = 10 * time.Second
+
+// deleteBlob deletes the object of a rejected upload. |ctx| must not be
+// limited by the request budget, so that the object is deleted even when the
+// upload was rejected for exceeding it.
+func deleteBlob(ctx context.Context, filename string) {
+	dctx, cancel := context.WithTimeout(ctx, deleteBlobTimeout)
+	defer cancel()
+	if err := objects.Delete(dctx, bucket, filename); err != nil && !os.IsNotExist(err) {
+		errorf(ctx, "deleting rejected log %q (left behind in bucket %q): %v", filename, bucket, err)
+	}
+}
+
+// parseLogIssue parses the optional repo and issue parameters of a log
+// upload, which name the issue the log belongs to. number is 0 if no issue was
+// specified.
+func parseLogIssue(cfg *Config, r *http.Request) (owner, repo string, number int, err error) {
+	if r.FormValue("issue") == "" {
+		return "", "", 0, nil
+	}
+	fullName := r.FormValue("repo")
+	allowed := false
+	for _, name := range cfg.LogIssueRepos {
+		if name == fullName {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", "", 0, fmt.Errorf("repo %q is not allowed", fullName)
+	}
+	owner, repo, ok := splitRepo(fullName)
+	if !ok {
+		return "", "", 0, fmt.Errorf("invalid repo %q, expected e.g. i3/i3", fullName)
+	}
+	number, err = strconv.Atoi(r.FormValue("issue"))
+	if err != nil || number <= 0 {
+		return "", "", 0, fmt.Errorf("invalid issue number %q", r.FormValue("issue"))
+	}
+	return owner, repo, number, nil
+}
+
+// getOpenIssue returns an IssuesEvent payload for the specified issue (so that
+// e.g. addComment can be used), provided it is an open issue.
+func getOpenIssue(ctx context.Context, client *apiClient, owner, repo string, number int) (github.IssuesEvent, error) {
+	issue, resp, err := client.Issues.Get(ctx, owner, repo, number)
 	if err != nil {
+		return github.IssuesEvent{}, err
+	}
+	discardResponse(resp)
+	if issue.IsPullRequest() {
+		return github.IssuesEvent{}, fmt.Errorf("%s/%s#%d is a pull request", owner, repo, number)
+	}
+	if issue.GetState() != "open" {
+		return github.IssuesEvent{}, fmt.Errorf("%s/%s#%d is not open", owner, repo, number)
+	}
+	return github.IssuesEvent{
+		Issue: issue,
+		Repo: &github.Repository{
+			Name:  github.String(repo),
+			Owner: &github.User{Login: github.String(owner)},
+		},
+	}, nil
+}
+
+// attachLog posts the link to an uploaded log (and its note, if any) on the
+// issue and removes the missing-log label.
+func attachLog(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.IssuesEvent, logURL, note string) bool {
+	comment := "Log uploaded: " + logURL
+	if note != "" {
+		comment += " (" + markdownNote(note) + ")"
+	}
+	if !addComment(ctx, client, cfg, payload, w, comment) {
+		return false
+	}
+	deleteLabel(ctx, client, payload, w, "missing-log")
+	return true
+}
+
+const indexPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>i3 debug logs</title>
+</head>
+<body>
+<h1>i3 debug logs</h1>
+<p>
+This service hosts debug logs for i3 bug reports, and runs the bot which
+checks new issues on <a href="https://github.com/i3/i3/issues">GitHub</a>.
+See <a href="https://i3wm.org/docs/debugging.html">Debugging i3</a> for how to
+obtain a debug log, then upload it using:
+</p>
+<pre>bzip2 -c -9 ~/i3.log | curl --data-binary @- https://logs.i3wm.org</pre>
+<h2>Endpoints</h2>
+<dl>
+<dt>POST /upload (or POST /)</dt>
+<dd>Upload a bzip2- or gzip-compressed log. The optional <code>repo</code>,
+<code>issue</code> and <code>note</code> parameters post the link on an
+issue.</dd>
+<dt>GET /logs/&lt;id&gt;</dt>
+<dd>Download an uploaded log.</dd>
+<dt>GET /logs/immutable/&lt;id&gt;</dt>
+<dd>Download an uploaded log, cacheable forever (e.g. by a CDN).</dd>
+<dt>POST /issues, /issue_comment, /installation</dt>
+<dd>GitHub webhooks.</dd>
+<dt>/update_github_token, /update_config, /bulk_label</dt>
+<dd>Administration (requires login).</dd>
+</dl>
+</body>
+</html>
+`
+
+// indexHandler serves a description of the service on GET requests and
+// accepts log uploads on POST requests, which is how uploads worked before
+// /upload existed (and what the debugging documentation still uses).
+func indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		logHandler(w, r)
+		return
+	}
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, indexPage)
+}
+
+// logLinkRegexp matches links to uploaded logs, capturing the log ID.
+var logLinkRegexp = regexp.MustCompile(`://logs\.i3wm\.org/logs/(?:immutable/)?([0-9A-Za-z]+)`)
+
+// logErrorRegexp matches log lines indicating an error, such as “ERROR: …”
+// or failed assertions.
+var logErrorRegexp = regexp.MustCompile(`\bERROR\b|\bBUG\b|Assertion .+ failed`)
+
+const (
+	// maxAnalyzeBytes is how much of a (decompressed) log firstLogError
+	// scans.
+	maxAnalyzeBytes = 32 << 20
+
+	// logErrorContext is the number of lines quoted before and after the
+	// error line.
+	logErrorContext = 3
+)
+
+// firstLogError returns the first error line in the (decompressed) log |r|,
+// surrounded by logErrorContext lines, or the empty string if there is no
+// error within the first maxAnalyzeBytes.
+func firstLogError(r io.Reader) (string, error) {
+	sc := bufio.NewScanner(io.LimitReader(r, maxAnalyzeBytes))
+	sc.Buffer(make([]byte, 0, 64*1024), 1<<20)
+	var before []string
+	var excerpt []string
+	after := -1 // number of context lines still to collect after the error
+	for sc.Scan() {
+		line := sc.Text()
+		if after > 0 {
+			excerpt = append(excerpt, line)
+			if after--; after == 0 {
+				break
+			}
+			continue
+		}
+		if logErrorRegexp.MatchString(line) {
+			excerpt = append(before, line)
+			after = logErrorContext
+			continue
+		}
+		if before = append(before, line); len(before) > logErrorContext {
+			before = before[1:]
+		}
+	}
+	if err := sc.Err(); err != nil {
 		return "", err
 	}
-	bw := client.Bucket(defaultBucket).Object(filename).NewWriter(ctx)
-	bw.ContentType = "application/octet-stream"
-	bw.ACL = []storage.ACLRule{{Entity: storage.AllUsers, Role: storage.RoleReader}}
-	if _, err := io.Copy(bw, r); err != nil {
+	return strings.Join(excerpt, "\n"), nil
+}
+
+// Errors returned by analyzeLog for logs whose contents must not be quoted
+// or cannot be read.
+var (
+	errDeletedLog       = errors.New("log has been deleted")
+	errPrivateLog       = errors.New("log is private")
+	errLegacyLogContent = errors.New("legacy blobstore logs cannot be analyzed")
+)
+
+// analyzeLog returns the first error (see firstLogError) in the log with ID
+// |logid|. The excerpt is quoted in public comments, so private (see
+// setLogPublic) and deleted logs are refused, like serveLog does for
+// non-administrators.
+func analyzeLog(ctx context.Context, logid string) (string, error) {
+	blobref, err := lookupBlobref(ctx, logid)
+	if err != nil {
+		if deleted, derr := blobrefs.Deleted(ctx, strings.ToLower(logid)); derr == nil && deleted {
+			return "", errDeletedLog
+		}
+		return "", fmt.Errorf("lookupBlobref(%q): %v", logid, err)
+	}
+	if blobref.Private {
+		return "", errPrivateLog
+	}
+	if blobref.Filename == "" {
+		return "", errLegacyLogContent
+	}
+	rc, err := objects.NewReader(ctx, bucket, blobref.Filename)
+	if err != nil {
 		return "", err
 	}
-	if err := bw.Close(); err != nil {
+	defer rc.Close()
+	rd, err := formatByName(blobref.Format).newReader(rc)
+	if err != nil {
 		return "", err
 	}
-
-	return filename, nil
+	return firstLogError(rd)
 }
 
 // TODO: wrap this so that errors contain an instruction on how to use the service.
 // logHandler takes a compressed i3 debug log and stores it on
-// Google Cloud Storage.
+// Google Cloud Storage. If the repo and issue parameters are specified
+// (e.g. /upload?repo=i3/i3&issue=1234), the link to the log is posted on that
+// issue.
 func logHandler(w http.ResponseWriter, r *http.Request) {
-	var body bytes.Buffer
-	rd := bzip2.NewReader(io.TeeReader(r.Body, &body))
-	uncompressed, err := ioutil.ReadAll(rd)
+	reqctx := appengine.NewContext(r)
+	cfg := configOrDefault(reqctx)
+	ctx, cancel := withRequestBudget(reqctx, cfg)
+	defer cancel()
+
+	stream, err := openLog(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// The compressed log is stored while the uncompressed log is scanned,
+	// so that neither needs to be held in memory. Rejected uploads are
+	// deleted again (using reqctx, as ctx may have expired).
+	created := time.Now()
+	filename := strconv.FormatInt(created.UnixNano(), 10)
+	bw, err := objects.NewWriter(ctx, bucket, filename, stream.format.contentType)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cloud storage: %v", err), errorStatus(ctx, err))
+		return
+	}
+	stored := false
+	defer func() {
+		if !stored {
+			deleteBlob(reqctx, filename)
+		}
+	}()
+	hash := sha256.New()
+	var scan logScan
+	err = stream.compressed.setWriter(io.MultiWriter(bw, hash))
+	if err == nil {
+		scan, err = cfg.scanLog(stream.uncompressed)
+		err = stream.finish(err)
+	}
+	if cerr := bw.Close(); err == nil && cerr != nil {
+		http.Error(w, fmt.Sprintf("cloud storage: %v", cerr), errorStatus(ctx, cerr))
+		return
+	}
 	if err != nil {
-		http.Error(w, "Data not bzip2-compressed.", http.StatusBadRequest)
+		if stream.compressed.werr != nil {
+			http.Error(w, fmt.Sprintf("cloud storage: %v", err), errorStatus(ctx, err))
+		} else {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
 		return
 	}
 
-	// TODO: match line by line, and have a certain percentage that needs to be an i3 log
 	// TODO: also allow strace log files
-	if !i3LogLine.Match(uncompressed) {
-		http.Error(w, "Data is not an i3 log file.", http.StatusBadRequest)
+	if scan.matched == 0 {
+		http.Error(w, "Data is not an i3 log file: no lines look like i3 log lines. "+
+			"Did you upload the right file?", http.StatusBadRequest)
+		return
+	}
+	if percent := scan.percent(); percent < cfg.MinLogLinePercent {
+		http.Error(w, fmt.Sprintf("Data is not an i3 log file: only %d%% of lines look like i3 log lines (need %d%%). "+
+			"Did you upload the right file?", percent, cfg.MinLogLinePercent), http.StatusBadRequest)
 		return
 	}
 
-	ctx := appengine.NewContext(r)
+	// Logs are publicly accessible, so refuse to host obvious secrets.
+	if what := scan.sensitive; what != "" {
+		http.Error(w, fmt.Sprintf("The log seems to contain %s. Please redact it (e.g. using sed) and upload the log again.", what),
+			http.StatusBadRequest)
+		return
+	}
 
-	filename, err := writeBlob(ctx, &body)
+	owner, repo, number, err := parseLogIssue(cfg, r)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("cloud storage: %v", err), http.StatusInternalServerError)
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	var (
+		client  *apiClient
+		payload github.IssuesEvent
+	)
+	if number != 0 {
+		if err := getGitHubToken(ctx); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if client, err = clientFor(ctx, owner); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// Verify the issue before recording the log, so that a rejected
+		// upload can simply be retried.
+		if payload, err = getOpenIssue(ctx, client, owner, repo, number); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
 
-	key, err := datastore.Put(ctx, datastore.NewIncompleteKey(ctx, "blobref", nil), &Blobref{Filename: filename})
+	blobref := Blobref{
+		Filename:  filename,
+		Backtrace: scan.backtrace,
+		Format:    stream.format.name,
+		Note:      sanitizeNote(r.FormValue("note")),
+		SHA256:    hex.EncodeToString(hash.Sum(nil)),
+		Created:   created,
+	}
+	if cfg.ShortLogURLs {
+		if blobref.Slug, err = newSlug(ctx); err != nil {
+			http.Error(w, err.Error(), errorStatus(ctx, err))
+			return
+		}
+	}
+	id, err := blobrefs.Put(ctx, &blobref)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(ctx, err))
 		return
 	}
+	stored = true
 
-	fmt.Fprintf(w, "https://logs.i3wm.org/logs/%d.bz2\n", key.IntID())
+	logURL := fmt.Sprintf("https://logs.i3wm.org/logs/%s.%s", logID(id, &blobref), stream.format.ext)
+	if number != 0 && !attachLog(ctx, w, client, cfg, payload, logURL, blobref.Note) {
+		return
+	}
+	fmt.Fprintln(w, logURL)
 }
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// notifyTimeout bounds how long sending a notification may take.
+const notifyTimeout = 5 * time.Second
+
+// notifyClient is used to send notifications.
+var notifyClient = &http.Client{Timeout: notifyTimeout}
+
+// closeNotification is sent to Config.CloseNotificationURL when the bot closes
+// an issue because of an unsupported version. The text field makes it usable
+// with Slack incoming webhooks.
+type closeNotification struct {
+	Text    string `json:"text"`
+	Repo    string `json:"repo"`
+	Issue   int    `json:"issue"`
+	Version string `json:"version"`
+	Latest  string `json:"latest"`
+	URL     string `json:"url"`
+}
+
+// enqueueNotification schedules notifyTaskHandler to send the JSON-encoded
+// closeNotification |body|. Tests replace it.
+var enqueueNotification = func(ctx context.Context, body []byte) error {
+	t := &taskqueue.Task{
+		Path:    "/tasks/notify",
+		Payload: body,
+		Header:  http.Header{"Content-Type": {"application/json"}},
+		Method:  "POST",
+	}
+	_, err := taskqueue.Add(ctx, t, "")
+	return err
+}
+
+// notifyClosed enqueues a closeNotification (using the task queue), so that a
+// slow or failing receiver never delays the webhook handler. It does nothing
+// if no CloseNotificationURL is configured.
+func notifyClosed(ctx context.Context, cfg *Config, payload interface{}, version, latest string) {
+	if cfg.CloseNotificationURL == "" {
+		return
+	}
+	repo, issue := getRepoAndIssue(payload)
+	fullName := repoKey(repo)
+	url := issue.GetHTMLURL()
+	if url == "" {
+		url = fmt.Sprintf("https://github.com/%s/issues/%d", fullName, issue.GetNumber())
+	}
+	n := closeNotification{
+		Text: fmt.Sprintf("Closed %s#%d (version %s, latest is %s): %s",
+			fullName, issue.GetNumber(), version, latest, url),
+		Repo:    fullName,
+		Issue:   issue.GetNumber(),
+		Version: version,
+		Latest:  latest,
+		URL:     url,
+	}
+	b, err := json.Marshal(&n)
+	if err != nil {
+		errorf(ctx, "notifyClosed: %v", err)
+		return
+	}
+	if err := enqueueNotification(ctx, b); err != nil {
+		errorf(ctx, "enqueueNotification: %v", err)
+	}
+}
+
+func notifyTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	// App Engine removes the X-AppEngine-QueueName header from external
+	// requests.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "Only callable from a task queue", http.StatusForbidden)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	sendNotification(ctx, w, configOrDefault(ctx), body)
+}
+
+// sendNotification posts the closeNotification |body| to the
+// CloseNotificationURL. Network errors and server errors of the receiver make
+// the task queue retry the task, other errors would only recur.
+func sendNotification(ctx context.Context, w http.ResponseWriter, cfg *Config, body []byte) {
+	if cfg.CloseNotificationURL == "" {
+		infof(ctx, "not sending notification: close_notification_url is no longer configured")
+		return
+	}
+	sendCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(sendCtx, "POST", cfg.CloseNotificationURL, bytes.NewReader(body))
+	if err != nil {
+		errorf(ctx, "notification: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := notifyClient.Do(req)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("notification: %v", err), http.StatusBadGateway)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		http.Error(w, fmt.Sprintf("notification: unexpected HTTP status %s", resp.Status), http.StatusBadGateway)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		errorf(ctx, "notification: unexpected HTTP status %s", resp.Status)
+	}
+}
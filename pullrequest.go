@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+)
+
+func pullRequestHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
+
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, event, err := readAndVerifyBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), verifyErrorStatus(err))
+		return
+	}
+
+	if event == "ping" {
+		return
+	}
+
+	if isInstallationEvent(event) {
+		// GitHub Apps send all events to the same URL.
+		handleInstallationWebhook(ctx, w, event, body)
+		return
+	}
+
+	if event != "pull_request" {
+		http.Error(w, "Expected X-GitHub-Event: pull_request", http.StatusBadRequest)
+		return
+	}
+
+	var payload github.PullRequestEvent
+	if err := json.Unmarshal(body, &payload); err != nil {
+		http.Error(w, fmt.Sprintf("Cannot parse JSON: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	client, err := clientFor(ctx, payload.GetRepo().GetOwner().GetLogin())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	handlePullRequestEvent(ctx, w, client, cfg, payload)
+}
+
+// handlePullRequestEvent sets the commit statuses (see Config.FileStatuses)
+// whose patterns match a file modified by the pull request on its head commit.
+func handlePullRequestEvent(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, payload github.PullRequestEvent) {
+	switch payload.GetAction() {
+	case "opened", "reopened", "synchronize":
+	default:
+		return
+	}
+	repo := payload.GetRepo()
+	statuses := cfg.fileStatuses(repo)
+	if len(statuses) == 0 {
+		return
+	}
+	owner := repo.GetOwner().GetLogin()
+	sha := payload.GetPullRequest().GetHead().GetSHA()
+	if owner == "" || repo.GetName() == "" || sha == "" {
+		errorf(ctx, "ignoring pull_request event: repository or head commit missing")
+		return
+	}
+
+	var files []string
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, resp, err := client.PullRequests.ListFiles(ctx, owner, repo.GetName(), payload.GetNumber(), opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ListFiles: %v", err), errorStatus(ctx, err))
+			return
+		}
+		discardResponse(resp)
+		for _, file := range page {
+			files = append(files, file.GetFilename())
+		}
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	for _, status := range statuses {
+		if !status.matchesAny(files) {
+			continue
+		}
+		_, resp, err := client.Repositories.CreateStatus(ctx, owner, repo.GetName(), sha, &github.RepoStatus{
+			State:       github.String("success"),
+			Context:     github.String(status.Context),
+			Description: github.String(status.Description),
+			TargetURL:   github.String(status.TargetURL),
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("CreateStatus: %v", err), errorStatus(ctx, err))
+			return
+		}
+		discardResponse(resp)
+	}
+}
+
+// matchesAny returns whether the status’s pattern matches one of |files|.
+func (s *FileStatus) matchesAny(files []string) bool {
+	for _, file := range files {
+		if s.re.MatchString(file) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/urlfetch"
+)
+
+const (
+	// maxRemoteLogSize is the maximum size of a remote log which is
+	// downloaded for validation. Larger files are not considered logs.
+	maxRemoteLogSize = 25 << 20
+
+	// maxRemoteLogLinks is the number of links per issue which are checked,
+	// bounding the egress caused by a single issue.
+	maxRemoteLogLinks = 3
+
+	// remoteLogTTL is how long validation results are cached.
+	remoteLogTTL = 1 * time.Hour
+)
+
+// defaultRemoteLogHosts are the default Config.RemoteLogHosts: paste services
+// which reporters commonly use for logs.
+var defaultRemoteLogHosts = []string{
+	"0x0.st",
+	"bpa.st",
+	"dpaste.com",
+	"gist.githubusercontent.com",
+	"paste.debian.net",
+	"paste.rs",
+	"paste.ubuntu.com",
+	"pastebin.com",
+	"termbin.com",
+}
+
+// remoteLinkRegexp matches links which might point to a log hosted elsewhere
+// than logs.i3wm.org (e.g. a paste service).
+var remoteLinkRegexp = regexp.MustCompile(`https?://[^\s<>()\[\]"'` + "`" + `]+`)
+
+// remoteLogResult is the (cached) validation result for a remote log.
+type remoteLogResult struct {
+	// IsLog is whether the content looks like an i3 log, see logLinePercent.
+	IsLog bool
+	// SHA256 is the hex-encoded hash of the downloaded content.
+	SHA256 string
+	// ETag and LastModified are the validators of the response the result
+	// was computed from. When a HEAD request returns different ones, the
+	// content changed and is downloaded again.
+	ETag         string
+	LastModified string
+}
+
+// remoteLogCache caches remoteLogResults, keyed by URL. Tests replace
+// remoteLogs with an in-memory implementation.
+type remoteLogCache interface {
+	Get(ctx context.Context, url string) (*remoteLogResult, bool)
+	Set(ctx context.Context, url string, result *remoteLogResult)
+}
+
+var remoteLogs remoteLogCache = memcacheRemoteLogs{}
+
+// remoteLogClient returns the HTTP client used to fetch remote logs. Tests
+// replace it.
+var remoteLogClient = func(ctx context.Context) *http.Client {
+	return urlfetch.Client(ctx)
+}
+
+// memcacheRemoteLogs implements remoteLogCache using App Engine memcache.
+type memcacheRemoteLogs struct{}
+
+func (memcacheRemoteLogs) Get(ctx context.Context, url string) (*remoteLogResult, bool) {
+	var result remoteLogResult
+	if _, err := memcache.JSON.Get(ctx, remoteLogKey(url), &result); err != nil {
+		if err != memcache.ErrCacheMiss {
+			errorf(ctx, "memcache.Get(%q): %v", url, err)
+		}
+		return nil, false
+	}
+	return &result, true
+}
+
+func (memcacheRemoteLogs) Set(ctx context.Context, url string, result *remoteLogResult) {
+	item := &memcache.Item{
+		Key:        remoteLogKey(url),
+		Object:     result,
+		Expiration: remoteLogTTL,
+	}
+	if err := memcache.JSON.Set(ctx, item); err != nil {
+		errorf(ctx, "memcache.Set(%q): %v", url, err)
+	}
+}
+
+// remoteLogKey returns the memcache key for |url|. Memcache keys are limited
+// to 250 bytes, so the URL is hashed.
+func remoteLogKey(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return "remotelog:" + hex.EncodeToString(sum[:])
+}
+
+// remoteLogAllowed returns whether |link| may be downloaded, see
+// Config.RemoteLogHosts. Links must not make the bot request internal
+// services, e.g. the metadata server.
+func (c *Config) remoteLogAllowed(link *url.URL) bool {
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return false
+	}
+	if port := link.Port(); port != "" && port != "80" && port != "443" {
+		return false
+	}
+	host := strings.TrimSuffix(strings.ToLower(link.Hostname()), ".")
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return false // IP address, or e.g. “localhost” or “metadata”
+	}
+	for _, suffix := range []string{".internal", ".local", ".localhost"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	for _, allowed := range c.RemoteLogHosts {
+		if host == allowed || strings.HasSuffix(host, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRemoteLog returns whether one of the first maxRemoteLogLinks links in
+// |body| to RemoteLogHosts points to an i3 log.
+func hasRemoteLog(ctx context.Context, cfg *Config, body string) bool {
+	checked := 0
+	for _, link := range remoteLinkRegexp.FindAllString(body, -1) {
+		u, err := url.Parse(link)
+		if err != nil || !cfg.remoteLogAllowed(u) {
+			continue
+		}
+		if checked++; checked > maxRemoteLogLinks {
+			break
+		}
+		result, err := validateRemoteLog(ctx, cfg, link)
+		if err != nil {
+			infof(ctx, "validating remote log %q: %v", link, err)
+			continue
+		}
+		if result.IsLog {
+			return true
+		}
+	}
+	return false
+}
+
+// validateRemoteLog downloads |url| and checks whether it contains an i3 log.
+// Results are cached (see remoteLogCache) and reused as long as a HEAD request
+// returns the same ETag and Last-Modified headers.
+func validateRemoteLog(ctx context.Context, cfg *Config, url string) (*remoteLogResult, error) {
+	client := *remoteLogClient(ctx)
+	// Redirects must not lead elsewhere than to RemoteLogHosts either.
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		if !cfg.remoteLogAllowed(req.URL) {
+			return fmt.Errorf("redirect to %s not allowed (see remote_log_hosts)", req.URL.Host)
+		}
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HEAD: unexpected status %s", resp.Status)
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if cached, ok := remoteLogs.Get(ctx, url); ok &&
+		cached.ETag == etag && cached.LastModified == lastModified {
+		return cached, nil
+	}
+
+	req, err = http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp, err = client.Do(req); err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET: unexpected status %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteLogSize+1))
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	result := &remoteLogResult{
+		SHA256:       hex.EncodeToString(sum[:]),
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if len(data) <= maxRemoteLogSize {
+		log := data
+		if _, ok := sniffFormat(data); ok {
+			if _, _, uncompressed, err := decompressLog(data); err == nil {
+				log = uncompressed
+			}
+		}
+		result.IsLog = i3LogLine.Match(log) && logLinePercent(log) >= cfg.MinLogLinePercent
+	}
+	remoteLogs.Set(ctx, url, result)
+	return result, nil
+}
+
+// String implements fmt.Stringer for log messages.
+func (r *remoteLogResult) String() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/taskqueue"
+)
+
+const (
+	// bugLabel marks issues which maintainers accepted as bug reports.
+	bugLabel = "bug"
+
+	// needsReproductionLabel is added to bug reports which no maintainer
+	// commented on for Config.ReproductionReminderDays.
+	needsReproductionLabel = "needs-reproduction"
+
+	// maxReproductionReminderDays is the largest ReproductionReminderDays:
+	// the task queue does not accept tasks due more than 30 days ahead.
+	maxReproductionReminderDays = 30
+)
+
+// taskNameRegexp matches the characters which task names must not contain.
+var taskNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+// reproductionTaskName returns the name of the check of the issue due at
+// |due|. Each issue must only have one chain of checks (every check enqueues
+// the next one), so checks of the same issue due at the same time are added
+// once only.
+func reproductionTaskName(owner, repo string, number int, due time.Time) string {
+	return taskNameRegexp.ReplaceAllString(
+		fmt.Sprintf("repro-%s-%s-%d-%d", owner, repo, number, due.Unix()), "_")
+}
+
+// enqueueReproductionCheck schedules reproductionTaskHandler to check the
+// issue at |due|, see Config.ReproductionReminderDays. Checks which were
+// already enqueued are not added again. Tests replace it.
+var enqueueReproductionCheck = func(ctx context.Context, owner, repo string, number int, due time.Time) error {
+	t := taskqueue.NewPOSTTask("/tasks/reproduction", url.Values{
+		"owner":  {owner},
+		"repo":   {repo},
+		"number": {strconv.Itoa(number)},
+	})
+	t.Name = reproductionTaskName(owner, repo, number, due)
+	t.ETA = due
+	if _, err := taskqueue.Add(ctx, t, ""); err != nil && err != taskqueue.ErrTaskAlreadyAdded {
+		return err
+	}
+	return nil
+}
+
+// scheduleReproductionCheck enqueues the first check of a bug report for the
+// needs-reproduction reminder, provided reminders are enabled.
+func scheduleReproductionCheck(ctx context.Context, cfg *Config, payload github.IssuesEvent) {
+	issue := payload.GetIssue()
+	if cfg.ReproductionReminderDays == 0 || !hasLabel(issue, bugLabel) || hasLabel(issue, needsReproductionLabel) {
+		return
+	}
+	repo := payload.GetRepo()
+	// Events arriving together (e.g. opened and labeled) schedule the same
+	// check. Should they straddle a minute, the checks compute the same due
+	// date from the issue (see reproductionDue), so their chains merge.
+	due := time.Now().Add(cfg.reproductionPeriod()).Truncate(time.Minute)
+	if err := enqueueReproductionCheck(ctx, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), due); err != nil {
+		errorf(ctx, "enqueueReproductionCheck: %v", err)
+	}
+}
+
+// reproductionPeriod returns how long bug reports may go without maintainer
+// comment, see ReproductionReminderDays.
+func (c *Config) reproductionPeriod() time.Duration {
+	return time.Duration(c.ReproductionReminderDays) * 24 * time.Hour
+}
+
+func reproductionTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	// App Engine removes the X-AppEngine-QueueName header from external
+	// requests.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "Only callable from a task queue", http.StatusForbidden)
+		return
+	}
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
+
+	owner, repo := r.FormValue("owner"), r.FormValue("repo")
+	number, err := strconv.Atoi(r.FormValue("number"))
+	if err != nil || owner == "" || repo == "" {
+		// Retrying will not help, so do not fail the task.
+		errorf(ctx, "invalid reproduction task: owner %q, repo %q, number %q", owner, repo, r.FormValue("number"))
+		return
+	}
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, err := clientFor(ctx, owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	checkReproduction(ctx, w, client, cfg, owner, repo, number, time.Now())
+}
+
+// checkReproduction adds the needs-reproduction label to the issue if it is
+// still an open bug report which is due (see reproductionDue) at |now|.
+// Otherwise, as long as the issue remains a candidate, the check is enqueued
+// again for when it will be due. Failed requests make the task queue retry
+// the task.
+func checkReproduction(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, owner, repo string, number int, now time.Time) {
+	if cfg.ReproductionReminderDays == 0 {
+		return
+	}
+	issue, resp, err := client.Issues.Get(ctx, owner, repo, number)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Get: %v", err), errorStatus(ctx, err))
+		return
+	}
+	discardResponse(resp)
+	if issue.GetState() != "open" || !hasLabel(issue, bugLabel) || hasLabel(issue, needsReproductionLabel) {
+		return
+	}
+
+	var comments []*github.IssueComment
+	opts := &github.IssueListCommentsOptions{ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		page, resp, err := client.Issues.ListComments(ctx, owner, repo, number, opts)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("ListComments: %v", err), errorStatus(ctx, err))
+			return
+		}
+		discardResponse(resp)
+		comments = append(comments, page...)
+		if resp.NextPage == 0 {
+			break
+		}
+		opts.Page = resp.NextPage
+	}
+
+	due, err := cfg.reproductionDue(issue, comments, func(login string) (bool, error) {
+		return isCollaborator(ctx, client, owner, repo, login)
+	})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("IsCollaborator: %v", err), errorStatus(ctx, err))
+		return
+	}
+	if now.Before(due) {
+		if err := enqueueReproductionCheck(ctx, owner, repo, number, due); err != nil {
+			http.Error(w, fmt.Sprintf("enqueueReproductionCheck: %v", err), errorStatus(ctx, err))
+		}
+		return
+	}
+	addLabel(ctx, client, cfg, github.IssuesEvent{
+		Issue: issue,
+		Repo: &github.Repository{
+			Name:  github.String(repo),
+			Owner: &github.User{Login: github.String(owner)},
+		},
+	}, w, needsReproductionLabel)
+}
+
+// reproductionDue returns when |issue| is due for the needs-reproduction
+// label: ReproductionReminderDays after it was opened or after the last of
+// |comments| (oldest first) by a maintainer, whichever is later. Comments by
+// the bot do not count.
+func (c *Config) reproductionDue(issue *github.Issue, comments []*github.IssueComment, isMaintainer func(login string) (bool, error)) (time.Time, error) {
+	since := issue.GetCreatedAt()
+	for i := len(comments) - 1; i >= 0; i-- {
+		comment := comments[i]
+		if c.isBotComment(comment) || !comment.GetCreatedAt().After(since) {
+			continue
+		}
+		maintainer, err := isMaintainer(comment.GetUser().GetLogin())
+		if err != nil {
+			return time.Time{}, err
+		}
+		if maintainer {
+			since = comment.GetCreatedAt()
+			break
+		}
+	}
+	return since.Add(c.reproductionPeriod()), nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/appengine"
+)
+
+// tokenScopesURL is requested to learn the scopes of githubToken, which
+// GitHub reports in the X-OAuth-Scopes header of authenticated responses.
+// Requesting the rate limit does not count against it.
+const tokenScopesURL = "https://api.github.com/rate_limit"
+
+// fetchTokenHeaders returns the response headers of an authenticated request
+// to tokenScopesURL. Tests replace it.
+var fetchTokenHeaders = func(ctx context.Context) (http.Header, error) {
+	transport := newGitHubTransport(ctx, configOrDefault(ctx))
+	req, err := http.NewRequestWithContext(ctx, "GET", tokenScopesURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := (&http.Client{Transport: transport}).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: unexpected status %s", tokenScopesURL, resp.Status)
+	}
+	return resp.Header, nil
+}
+
+var (
+	tokenScopesMu sync.Mutex
+	// tokenScopes is the result of the last checkTokenScopes on this
+	// instance, reported by /healthz.
+	tokenScopes = "not checked yet"
+)
+
+// describeTokenScopes describes the scopes reported in |h| (see
+// tokenScopesURL). ok is false if the token lacks the scope needed to label,
+// comment on and close issues. Fine-grained tokens (and GitHub App tokens)
+// have permissions instead of scopes, which GitHub does not report.
+func describeTokenScopes(h http.Header) (description string, ok bool) {
+	values, reported := h[http.CanonicalHeaderKey("X-OAuth-Scopes")]
+	if !reported {
+		return "scopes not reported (fine-grained or GitHub App token?), " +
+			"make sure it has read and write access to issues", true
+	}
+	var scopes []string
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	sort.Strings(scopes)
+	for _, scope := range scopes {
+		// public_repo suffices for public repositories.
+		if scope == "repo" || scope == "public_repo" {
+			return fmt.Sprintf("scopes %q", scopes), true
+		}
+	}
+	return fmt.Sprintf("WARNING: scopes %q lack repo (or public_repo), "+
+		"which is required to label, comment on and close issues", scopes), false
+}
+
+// checkTokenScopes checks the scopes of githubToken, which must be loaded,
+// and records the result for /healthz.
+func checkTokenScopes(ctx context.Context) string {
+	var description string
+	h, err := fetchTokenHeaders(ctx)
+	if err != nil {
+		description = fmt.Sprintf("scope check failed: %v", err)
+		errorf(ctx, "token %s", description)
+	} else {
+		var ok bool
+		if description, ok = describeTokenScopes(h); ok {
+			infof(ctx, "token %s", description)
+		} else {
+			errorf(ctx, "token %s", description)
+		}
+	}
+	tokenScopesMu.Lock()
+	defer tokenScopesMu.Unlock()
+	tokenScopes = description
+	return description
+}
+
+// lastTokenScopes returns the result of the last checkTokenScopes.
+func lastTokenScopes() string {
+	tokenScopesMu.Lock()
+	defer tokenScopesMu.Unlock()
+	return tokenScopes
+}
+
+// warmupHandler is called by App Engine when starting an instance (see
+// app.yaml), so that the token is checked before traffic arrives.
+func warmupHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if err := getGitHubToken(ctx); err != nil {
+		errorf(ctx, "warmup: %v", err)
+		return
+	}
+	checkTokenScopes(ctx)
+}
+
+func tokenScopesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	if !requireAdmin(ctx, w, r) {
+		return
+	}
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	fmt.Fprintf(w, "GitHub token: %s\n", checkTokenScopes(ctx))
+}
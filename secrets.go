@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	secretmanager "google.golang.org/api/secretmanager/v1"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	// githubTokenSecret is the resource name of a Secret Manager secret
+	// version (e.g. projects/i3-github-bot/secrets/github-token/versions/latest)
+	// containing the GitHubToken as JSON, e.g.
+	//   {"Token": "…", "Secret": "…"}
+	// The secret is used when datastore contains no GitHubToken, or always
+	// when preferSecretManager is set.
+	githubTokenSecret = os.Getenv("GITHUB_TOKEN_SECRET")
+
+	preferSecretManager = os.Getenv("PREFER_SECRET_MANAGER") != ""
+)
+
+// accessSecret returns the payload of the Secret Manager secret version
+// |name|.
+func accessSecret(ctx context.Context, name string) ([]byte, error) {
+	svc, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if resp.Payload == nil {
+		return nil, fmt.Errorf("secret %s has no payload", name)
+	}
+	return base64.StdEncoding.DecodeString(resp.Payload.Data)
+}
+
+// loadSecretGitHubToken reads the GitHubToken from the Secret Manager secret
+// version |name|. Tests replace it.
+var loadSecretGitHubToken = func(ctx context.Context, name string) (GitHubToken, error) {
+	var t GitHubToken
+	b, err := accessSecret(ctx, name)
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(b, &t); err != nil {
+		return t, fmt.Errorf("secret %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// loadCredentials reads the GitHubToken from Secret Manager or datastore, see
+// githubTokenSecret.
+func loadCredentials(ctx context.Context) (GitHubToken, error) {
+	if githubTokenSecret != "" && preferSecretManager {
+		return loadSecretGitHubToken(ctx, githubTokenSecret)
+	}
+	t, err := loadGitHubToken(ctx)
+	if err == datastore.ErrNoSuchEntity && githubTokenSecret != "" {
+		return loadSecretGitHubToken(ctx, githubTokenSecret)
+	}
+	return t, err
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/go-github/v47/github"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/memcache"
+	"google.golang.org/appengine/taskqueue"
+)
+
+// autoCloseDeferredLabel marks issues with an unsupported version which were
+// not closed yet because of Config.MaxAutoClosesPerHour.
+const autoCloseDeferredLabel = "auto-close-deferred"
+
+// autoCloseWindow is the period for which Config.MaxAutoClosesPerHour applies.
+const autoCloseWindow = time.Hour
+
+// closeCounter counts the issues auto-closed for reporting an unsupported
+// version, across all issues and instances. Tests replace autoCloses with an
+// in-memory implementation.
+type closeCounter interface {
+	// Increment records an auto-close in the window starting at |window| and
+	// returns the number of auto-closes recorded in that window so far.
+	Increment(ctx context.Context, window time.Time) (uint64, error)
+	// Decrement removes an auto-close recorded by Increment, e.g. because
+	// closing the issue failed.
+	Decrement(ctx context.Context, window time.Time) error
+}
+
+var autoCloses closeCounter = memcacheCloseCounter{}
+
+// memcacheCloseCounter implements closeCounter using App Engine memcache.
+type memcacheCloseCounter struct{}
+
+func autoCloseKey(window time.Time) string {
+	return "autoclose:" + window.UTC().Format(time.RFC3339)
+}
+
+func (memcacheCloseCounter) Increment(ctx context.Context, window time.Time) (uint64, error) {
+	key := autoCloseKey(window)
+	// Create the counter with an expiration (memcache.Increment would create
+	// it without one). ErrNotStored means it already exists.
+	item := &memcache.Item{
+		Key:        key,
+		Value:      []byte("0"),
+		Expiration: 2 * autoCloseWindow,
+	}
+	if err := memcache.Add(ctx, item); err != nil && err != memcache.ErrNotStored {
+		return 0, err
+	}
+	return memcache.Increment(ctx, key, 1, 0)
+}
+
+func (memcacheCloseCounter) Decrement(ctx context.Context, window time.Time) error {
+	// Memcache does not decrement below 0, e.g. after an eviction.
+	_, err := memcache.IncrementExisting(ctx, autoCloseKey(window), -1)
+	if err == memcache.ErrCacheMiss {
+		return nil
+	}
+	return err
+}
+
+// reserveAutoClose returns whether another issue may be closed for reporting
+// an unsupported version, see Config.MaxAutoClosesPerHour, and records the
+// close in the current window (which it returns). Callers must
+// releaseAutoClose if closing the issue fails. When the counter is
+// unavailable, closing is allowed.
+func reserveAutoClose(ctx context.Context, cfg *Config) (time.Time, bool) {
+	window := time.Now().Truncate(autoCloseWindow)
+	if cfg.MaxAutoClosesPerHour <= 0 {
+		return window, true
+	}
+	count, err := autoCloses.Increment(ctx, window)
+	if err != nil {
+		errorf(ctx, "counting auto-closes: %v", err)
+		return window, true
+	}
+	if count > uint64(cfg.MaxAutoClosesPerHour) {
+		// The close did not happen, so it must not count against the
+		// limit of issues closed later in the window.
+		releaseAutoClose(ctx, cfg, window)
+		return window, false
+	}
+	return window, true
+}
+
+// releaseAutoClose removes the close recorded by reserveAutoClose in |window|.
+func releaseAutoClose(ctx context.Context, cfg *Config, window time.Time) {
+	if cfg.MaxAutoClosesPerHour <= 0 {
+		return
+	}
+	if err := autoCloses.Decrement(ctx, window); err != nil {
+		errorf(ctx, "uncounting auto-close: %v", err)
+	}
+}
+
+// deferAutoClose marks an issue whose close was suppressed by
+// MaxAutoClosesPerHour with the autoCloseDeferredLabel and schedules closing
+// it (see enqueueAutoClose) for after |window|, unless maintainers intervene.
+func deferAutoClose(ctx context.Context, client *apiClient, cfg *Config, payload interface{}, w http.ResponseWriter, window time.Time, version, latest string) {
+	repo, issue := getRepoAndIssue(payload)
+	addLabel(ctx, client, cfg, payload, w, autoCloseDeferredLabel)
+	delay := time.Until(window.Add(autoCloseWindow))
+	if err := enqueueAutoClose(ctx, repo.GetOwner().GetLogin(), repo.GetName(), issue.GetNumber(), version, latest, delay); err != nil {
+		// The label still allows maintainers (or the next event on the
+		// issue) to find it.
+		errorf(ctx, "enqueueAutoClose: %v", err)
+	}
+}
+
+// enqueueAutoClose schedules autoCloseTaskHandler to close the issue, which
+// reported |version| instead of |latest|, after |delay|. The version is passed
+// on because it may have been found in a comment instead of the issue body.
+// Tests replace it.
+var enqueueAutoClose = func(ctx context.Context, owner, repo string, number int, version, latest string, delay time.Duration) error {
+	t := taskqueue.NewPOSTTask("/tasks/autoclose", url.Values{
+		"owner":   {owner},
+		"repo":    {repo},
+		"number":  {strconv.Itoa(number)},
+		"version": {version},
+		"latest":  {latest},
+	})
+	t.Delay = delay
+	_, err := taskqueue.Add(ctx, t, "")
+	return err
+}
+
+func autoCloseTaskHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := appengine.NewContext(r)
+	// App Engine removes the X-AppEngine-QueueName header from external
+	// requests.
+	if r.Header.Get("X-AppEngine-QueueName") == "" {
+		http.Error(w, "Only callable from a task queue", http.StatusForbidden)
+		return
+	}
+	cfg := configOrDefault(ctx)
+	ctx, cancel := withRequestBudget(ctx, cfg)
+	defer cancel()
+
+	owner, repo := r.FormValue("owner"), r.FormValue("repo")
+	version, latest := r.FormValue("version"), r.FormValue("latest")
+	number, err := strconv.Atoi(r.FormValue("number"))
+	if err != nil || owner == "" || repo == "" || version == "" || latest == "" {
+		// Retrying will not help, so do not fail the task.
+		errorf(ctx, "invalid auto-close task: owner %q, repo %q, number %q, version %q, latest %q",
+			owner, repo, r.FormValue("number"), version, latest)
+		return
+	}
+	if err := getGitHubToken(ctx); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	client, err := clientFor(ctx, owner)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	autoCloseDeferred(ctx, w, client, cfg, owner, repo, number, version, latest)
+}
+
+// autoCloseDeferred closes an issue whose close was deferred (see
+// deferAutoClose), unless it was closed or maintainers removed the
+// unsupported-version or autoCloseDeferredLabel label in the meantime. Failed
+// requests make the task queue retry the task.
+func autoCloseDeferred(ctx context.Context, w http.ResponseWriter, client *apiClient, cfg *Config, owner, repo string, number int, version, latest string) {
+	issue, resp, err := client.Issues.Get(ctx, owner, repo, number)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Get: %v", err), errorStatus(ctx, err))
+		return
+	}
+	discardResponse(resp)
+	if issue.GetState() != "open" ||
+		!hasLabel(issue, "unsupported-version") ||
+		!hasLabel(issue, autoCloseDeferredLabel) {
+		infof(ctx, "not closing %s/%s#%d: no longer open or no longer deferred", owner, repo, number)
+		return
+	}
+	closeUnsupported(ctx, client, cfg, github.IssuesEvent{
+		Action: github.String("labeled"),
+		Issue:  issue,
+		Repo: &github.Repository{
+			Name:  github.String(repo),
+			Owner: &github.User{Login: github.String(owner)},
+		},
+	}, w, version, latest)
+}
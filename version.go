@@ -1,7 +1,8 @@
 package main
 
 import (
-	"log"
+	"encoding/json"
+	"fmt"
 	"regexp"
 
 	"golang.org/x/text/collate"
@@ -9,31 +10,323 @@ import (
 )
 
 var (
-	reMajorVersion  = regexp.MustCompile(`(i3|i3status|i3lock):?\s*(?:version|v|vers|ver)?:?\s*(3\.[a-e]|3\.\p{Greek}|[0-9]\.[0-9]+)`)
+	// reMajorVersion matches e.g. “i3 version 4.20.1”, “i3status 2.13” or
+	// “i3bar version 4.22”. The version must be on the same line as the
+	// program name and must not be followed by further alphanumerics, so that
+	// e.g. “i3: 5.873s” in systemctl status output or a number on the line
+	// after a process name are not mistaken for versions.
+	reMajorVersion  = regexp.MustCompile(`\b(i3|i3status|i3lock|i3bar):?[ \t]*(?:version|v|vers|ver)?:?[ \t]*(3\.[a-e]|3\.\p{Greek}|[0-9]\.[0-9]+)((?:\.[0-9]+)*)(?:$|[^0-9A-Za-z])`)
 	stripConfigLine = regexp.MustCompile(`(?m) - config_parser.c:parse_config:([0-9]+) - CONFIG\(line [0-9]+\): # Before i3 v4\.8, we used to recommend this one as the default:\s*$`)
+
+	// reTruncatedVersion matches version output which was cut off while
+	// copying, e.g. “i3 version 4.” (which reMajorVersion does not match).
+	reTruncatedVersion = regexp.MustCompile(`(?m)\b(?:i3|i3status|i3lock|i3bar):?[ \t]*(?:version|vers|ver|v):?[ \t]*[0-9]+\.?(?:$|[^0-9A-Za-z.])`)
+
+	// reDateOnlyVersion matches version output which contains a build date,
+	// but no version number, e.g. “i3 version (2023-10-24)”, as produced by
+	// some misbuilt packages.
+	reDateOnlyVersion = regexp.MustCompile(`(?m)\b(?:i3|i3status|i3lock|i3bar):?[ \t]*version:?[ \t]*\(?[0-9]{4}-[0-9]{2}-[0-9]{2}\b`)
+
+	// rpmPackage matches package names as printed by e.g. “rpm -q i3”, such as
+	// i3-4.20.1-1.fc38.x86_64 (name-version-release.arch).
+	rpmPackage = regexp.MustCompile(`\b(i3|i3status|i3lock)-([0-9]\.[0-9]+(?:\.[0-9]+)*)-[0-9][^\s]*`)
+
+	// debianPackage matches the name of i3’s Debian package, as printed by
+	// e.g. “dpkg -l i3-wm” (“ii  i3-wm  4.23-1  amd64 …”). The Debian revision
+	// (“-1”) is not part of the upstream version and is ignored by
+	// reMajorVersion.
+	debianPackage = regexp.MustCompile(`\bi3-wm\b`)
+
+	// markdownVersionRow matches a markdown table row whose first cell names
+	// a program and whose second cell contains its version, e.g.
+	// “| i3 version | 4.23 |” or “| i3status | `2.14` |”. Rows are matched
+	// within a single line, so that cells of adjacent rows are not combined.
+	markdownVersionRow = regexp.MustCompile("(?m)^[ \t]*\\|?[ \t]*(i3|i3status|i3lock|i3bar)(?:[ \t]+version)?[ \t]*\\|[ \t]*`?[ \t]*(?:version[ \t]*)?(v?[0-9][0-9A-Za-z.-]*)")
+
+	// crashFrameSource matches gdb backtrace frames (see
+	// defaultBacktracePatterns) whose source file lies in an i3 source tree,
+	// which is named after the version, e.g.
+	//   #3  0x000055d1c4f1a2b3 in main (argc=1, argv=…) at ../i3-4.23/src/main.c:1040
+	// Debian’s tree is named i3-wm-4.23 and may contain the build directory,
+	// e.g. i3-wm-4.23/build/../src/main.c. Requiring both the frame and i3’s
+	// source layout avoids mistaking other programs’ frames for i3 versions.
+	crashFrameSource = regexp.MustCompile(`(?m)^(#[0-9]+[ \t].*[ \t]at[ \t]+\S*?)\bi3(?:-wm)?-([0-9]\.[0-9]+(?:\.[0-9]+)*)((?:-[^/\s]*)?/(?:[^/\s]+/)*?(?:src|libi3|i3bar|include)/)`)
+
+	// binaryVersionLine and runningVersionLine match the lines of
+	// “i3 --moreversion” output, e.g.
+	//   Binary i3 version:  4.10.1 (2015-03-29, branch "4.10.1") © 2009 …
+	//   Running i3 version: 4.10.1 (2015-03-29, branch "4.10.1") (pid 1552)
+	binaryVersionLine  = regexp.MustCompile(`(?m)^\s*Binary i3 version:\s*(\S+)`)
+	runningVersionLine = regexp.MustCompile(`(?m)^\s*Running i3 version:\s*(\S+)`)
+
+	// moreversionWarning matches the warning which “i3 --moreversion” prints
+	// when the running i3 binary was replaced or deleted on disk (e.g. by an
+	// upgrade without restarting i3).
+	moreversionWarning = regexp.MustCompile(`(?i)running binary different from binary on disk`)
+
+	// versionBranch matches the branch in i3’s version output, e.g.
+	// “4.23 (2023-10-24, branch "next")”. Releases are built from the tag,
+	// e.g. branch "4.23".
+	versionBranch = regexp.MustCompile(`\bbranch "([^"\n]+)"`)
+
+	// ipcVersionReply matches the JSON object which i3 sends in reply to the
+	// IPC get_version request.
+	ipcVersionReply = regexp.MustCompile(`\{[^{}]*"(?:major|human_readable)"\s*:[^{}]*\}`)
 )
 
-// extractVersion extracts all (i3|i3status|i3lock) versions out of |body| and
-// returns the highest version (numerically sorted).
+// extractVersion extracts all (i3|i3status|i3lock|i3bar) versions out of |body| and
+// returns the running i3 version (see runningVersion) if present, the highest
+// version (numerically sorted) otherwise. The result contains the
+// program at index 1, its major version (e.g. 4.20) at index 2 and its full
+// version (e.g. 4.20.1) at index 3.
 func extractVersion(body string) []string {
-	// Replace version numbers that occur in the default config file.
-	body = stripConfigLine.ReplaceAllString(body, "")
+	return extractProgramVersion(body, "")
+}
+
+// isI3Program returns whether |program| (as returned by extractVersion) is
+// released as part of i3 and therefore has i3’s version number. i3bar is
+// shipped with i3, whereas i3status and i3lock have their own releases.
+func isI3Program(program string) bool {
+	return program == "i3" || program == "i3bar"
+}
+
+// extractProgramVersion is like extractVersion, but if |body| contains
+// versions for multiple programs (e.g. i3 and i3lock), the versions of
+// |program| (typically the program whose repository the issue was filed in)
+// are used.
+func extractProgramVersion(body, program string) []string {
+	matches, _ := explainProgramVersion(body, program)
+	return matches
+}
+
+// versionDecision records how explainProgramVersion arrived at its result, so
+// that mislabeled versions can be debugged from the logs.
+type versionDecision struct {
+	// Normalizations describe how the body was changed before matching, see
+	// normalizeVersionBody.
+	Normalizations []string
+	// Candidates are all versions found, e.g. “i3 4.20.1”.
+	Candidates []string
+	// Reason describes which candidate was chosen and why.
+	Reason string
+	Result []string
+}
 
+func (d *versionDecision) String() string {
+	return fmt.Sprintf("normalizations %q, candidates %q: %s, result %q",
+		d.Normalizations, d.Candidates, d.Reason, d.Result)
+}
+
+// explainProgramVersion is like extractProgramVersion, but also returns how
+// the version was chosen.
+func explainProgramVersion(body, program string) ([]string, *versionDecision) {
+	d := &versionDecision{}
+	body, d.Normalizations = explainNormalizeVersionBody(body)
 	allmatches := reMajorVersion.FindAllStringSubmatch(body, -1)
 	if len(allmatches) == 0 {
-		return []string{}
+		d.Result = extractIPCVersion(body)
+		if len(d.Result) > 0 {
+			d.Reason = "no version output, used the IPC get_version reply"
+		} else {
+			d.Reason = "no version found"
+		}
+		return d.Result, d
 	}
-	versions := make([]string, len(allmatches))
-	firstProgram := allmatches[0][1]
-	for idx, match := range allmatches {
-		log.Printf("match = %v\n", match)
-		if match[1] != firstProgram {
-			// |body| contains versions for multiple programs (e.g. i3
-			// and i3lock). Just return the first one for now.
-			return allmatches[0]
+	chosen := allmatches[0][1]
+	programs := make(map[string]bool)
+	for _, match := range allmatches {
+		d.Candidates = append(d.Candidates, match[1]+" "+match[2]+match[3])
+		programs[match[1]] = true
+	}
+	if len(programs) > 1 {
+		if !programs[program] {
+			// |body| contains versions for multiple programs, none of which
+			// is the preferred one. Just return the first one for now.
+			first := allmatches[0]
+			d.Reason = fmt.Sprintf("multiple programs, none of which is %q: used the first candidate", program)
+			d.Result = []string{"", first[1], first[2], first[2] + first[3]}
+			return d.Result, d
 		}
-		versions[idx] = match[2]
+		chosen = program
+	}
+	if chosen == "i3" {
+		// The running version is what matters for reproducing the issue, so
+		// it takes precedence over e.g. the version of an installed package
+		// or of the binary on disk.
+		if running := runningVersion(body); running != nil {
+			d.Reason = "running i3 version"
+			d.Result = []string{"", "i3", running[2], running[2] + running[3]}
+			return d.Result, d
+		}
+	}
+	var versions []string
+	majorVersions := make(map[string]string, len(allmatches))
+	for _, match := range allmatches {
+		if match[1] != chosen {
+			continue
+		}
+		version := match[2] + match[3]
+		versions = append(versions, version)
+		majorVersions[version] = match[2]
 	}
 	collate.New(language.Und, collate.Numeric).SortStrings(versions)
-	return []string{"", firstProgram, versions[len(versions)-1]}
+	highest := versions[len(versions)-1]
+	d.Reason = fmt.Sprintf("highest %s version", chosen)
+	if len(programs) > 1 {
+		d.Reason += " (preferred among multiple programs)"
+	}
+	d.Result = []string{"", chosen, majorVersions[highest], highest}
+	return d.Result, d
+}
+
+// runningVersion returns the reMajorVersion match of the last “Running i3
+// version” line of “i3 --moreversion” output in |body|, or nil. The last line
+// is used as reporters sometimes paste the output from before and after a
+// fix.
+func runningVersion(body string) []string {
+	lines := runningVersionLine.FindAllString(body, -1)
+	for i := len(lines) - 1; i >= 0; i-- {
+		if match := reMajorVersion.FindStringSubmatch(lines[i]); match != nil {
+			return match
+		}
+	}
+	return nil
+}
+
+// extractBranches returns the branches in i3’s version output in |body|, e.g.
+// “next” or “4.23”.
+func extractBranches(body string) []string {
+	var branches []string
+	for _, match := range versionBranch.FindAllStringSubmatch(body, -1) {
+		branches = append(branches, match[1])
+	}
+	return branches
+}
+
+// isDevBuild returns whether |body| indicates a development build: a version
+// suffix like “-51-g9a4c6b4” (see devBuildRegexp) or a branch other than a
+// release tag, such as “next” or “master”.
+func isDevBuild(body string) bool {
+	if devBuildRegexp.MatchString(body) {
+		return true
+	}
+	for _, branch := range extractBranches(body) {
+		if !bareVersionRegexp.MatchString(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// versionMismatch returns the binary and running i3 versions if |body|
+// contains “i3 --moreversion” output in which they differ, e.g. because i3 was
+// not restarted after an upgrade. Each Binary line is compared with the
+// Running line at the same position, as reporters sometimes paste the output
+// from before and after a fix.
+func versionMismatch(body string) (binary, running string, ok bool) {
+	binaries := binaryVersionLine.FindAllStringSubmatch(body, -1)
+	runnings := runningVersionLine.FindAllStringSubmatch(body, -1)
+	for i := 0; i < len(binaries) && i < len(runnings); i++ {
+		if binaries[i][1] != runnings[i][1] {
+			return binaries[i][1], runnings[i][1], true
+		}
+	}
+	return "", "", false
+}
+
+// normalizeVersionBody prepares |body| for matching reMajorVersion.
+func normalizeVersionBody(body string) string {
+	body, _ = explainNormalizeVersionBody(body)
+	return body
+}
+
+// explainNormalizeVersionBody is like normalizeVersionBody, but also describes
+// the changes it made.
+func explainNormalizeVersionBody(body string) (string, []string) {
+	var changes []string
+	// Replace version numbers that occur in the default config file.
+	if n := len(stripConfigLine.FindAllStringIndex(body, -1)); n > 0 {
+		changes = append(changes, fmt.Sprintf("stripped %d default config line(s)", n))
+		body = stripConfigLine.ReplaceAllString(body, "")
+	}
+	// Turn RPM package names into “program version”.
+	for _, pkg := range rpmPackage.FindAllString(body, -1) {
+		changes = append(changes, fmt.Sprintf("read RPM package %q", pkg))
+	}
+	body = rpmPackage.ReplaceAllString(body, "$1 $2")
+	// Turn markdown table rows (e.g. from issue forms) into “program
+	// version”.
+	if n := len(markdownVersionRow.FindAllStringIndex(body, -1)); n > 0 {
+		changes = append(changes, fmt.Sprintf("read %d markdown table row(s)", n))
+		body = markdownVersionRow.ReplaceAllString(body, "$1 $2")
+	}
+	// Turn the source paths of crash backtrace frames into “program
+	// version”.
+	if n := len(crashFrameSource.FindAllStringIndex(body, -1)); n > 0 {
+		changes = append(changes, fmt.Sprintf("read %d crash backtrace frame(s)", n))
+		body = crashFrameSource.ReplaceAllString(body, "${1}i3 ${2}${3}")
+	}
+	if debianPackage.MatchString(body) {
+		changes = append(changes, "read Debian package i3-wm")
+		body = debianPackage.ReplaceAllString(body, "i3")
+	}
+	return body, changes
+}
+
+// extractVersions returns the highest version (e.g. 4.20.1) of each program
+// (i3, i3status, i3lock) mentioned in |body|.
+func extractVersions(body string) map[string]string {
+	body = normalizeVersionBody(body)
+	versions := make(map[string]string)
+	for _, match := range reMajorVersion.FindAllStringSubmatch(body, -1) {
+		version := match[2] + match[3]
+		if highest, ok := versions[match[1]]; !ok || compareVersions(version, highest) > 0 {
+			versions[match[1]] = version
+		}
+	}
+	if len(versions) == 0 {
+		if matches := extractIPCVersion(body); len(matches) > 0 {
+			versions[matches[1]] = matches[3]
+		}
+	}
+	return versions
+}
+
+// ipcVersion is the reply to the IPC get_version request.
+type ipcVersion struct {
+	Major         int    `json:"major"`
+	Minor         int    `json:"minor"`
+	Patch         int    `json:"patch"`
+	HumanReadable string `json:"human_readable"`
+}
+
+// extractIPCVersion extracts the i3 version out of a get_version reply (as
+// printed by “i3-msg -t get_version”) contained in |body|. The result is in
+// the same format as extractVersion’s.
+func extractIPCVersion(body string) []string {
+	for _, match := range ipcVersionReply.FindAllString(body, -1) {
+		var v ipcVersion
+		if err := json.Unmarshal([]byte(match), &v); err != nil {
+			continue
+		}
+		if v.Major > 0 {
+			major := fmt.Sprintf("%d.%d", v.Major, v.Minor)
+			full := major
+			if v.Patch > 0 {
+				full = fmt.Sprintf("%s.%d", major, v.Patch)
+			}
+			return []string{"", "i3", major, full}
+		}
+		if matches := reMajorVersion.FindStringSubmatch("i3 " + v.HumanReadable); matches != nil {
+			return []string{"", "i3", matches[2], matches[2] + matches[3]}
+		}
+	}
+	return []string{}
+}
+
+// compareVersions compares the versions |a| and |b| using the same numeric
+// collation as extractVersion. The result is 0 if a == b, -1 if a < b and +1
+// if a > b.
+func compareVersions(a, b string) int {
+	return collate.New(language.Und, collate.Numeric).CompareString(a, b)
 }